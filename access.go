@@ -0,0 +1,171 @@
+package main
+
+// access module
+//
+// Scan records are readable by the members of their beamtime request (BTR):
+// searches only return records whose btr is one of the BTRs in the caller's
+// token claims. Staff, whose tokens have the staff scope, may also read the
+// records of other BTRs which are not under embargo, and FOXDEN admins may
+// read all records. Deleted records are only read when asked for.
+//
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Scope of tokens whose requests may read the records of all BTRs which are
+// not under embargo
+const StaffScope = "staff"
+
+// RecordAccess describes which scan records a caller may read: all records,
+// or those of the given BTRs and, for staff, those of other BTRs which are
+// not under embargo at the given time; deleted records only if they are
+// included
+type RecordAccess struct {
+	All            bool
+	Staff          bool
+	Btrs           []string
+	Embargoed      []string
+	Now            float64
+	IncludeDeleted bool
+}
+
+// Return the access to the scan records in the given stores of the caller of
+// a request
+func requestAccess(c *gin.Context, stores ScanStores) (RecordAccess, error) {
+	claims, err := requestClaims(c)
+	if err != nil {
+		return RecordAccess{}, err
+	}
+	return claimsAccess(claims, stores)
+}
+
+// Return the access to the scan records in the given stores of the holder of
+// a token with the given claims
+func claimsAccess(claims *authz.Claims, stores ScanStores) (RecordAccess, error) {
+	if isAdmin(claims) {
+		return RecordAccess{All: true}, nil
+	}
+	access := RecordAccess{Btrs: claims.CustomClaims.Btrs, Now: epochTime(time.Now())}
+	if isStaff(claims) {
+		access.Staff = true
+		embargoed, err := embargoedBtrs(stores, access.Now)
+		if err != nil {
+			return access, fmt.Errorf("[SpecScansService.main.claimsAccess] embargoedBtrs error: %w", err)
+		}
+		access.Embargoed = embargoed
+	}
+	return access, nil
+}
+
+// Return the BTRs of the given stores under embargo at the given time
+func embargoedBtrs(stores ScanStores, now float64) ([]string, error) {
+	embargoes, err := stores.Embargoes.GetEmbargoes(nil, now)
+	if err != nil {
+		return nil, fmt.Errorf("[SpecScansService.main.embargoedBtrs] stores.Embargoes.GetEmbargoes error: %w", err)
+	}
+	var btrs []string
+	for _, embargo := range embargoes {
+		btrs = append(btrs, embargo.Btr)
+	}
+	return btrs, nil
+}
+
+// Check whether the given claims belong to staff
+func isStaff(claims *authz.Claims) bool {
+	return inList(StaffScope, strings.Fields(claims.CustomClaims.Scope))
+}
+
+// Abort the request unless it was made by staff or an admin; return whether
+// it was
+func requireStaff(c *gin.Context) bool {
+	claims, err := requestClaims(c)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusUnauthorized, services.TokenError, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, resp)
+		return false
+	}
+	if !isStaff(claims) && !isAdmin(claims) {
+		err := fmt.Errorf("user %s does not have the %s scope", claims.CustomClaims.User, StaffScope)
+		resp := services.Response("SpecScans", http.StatusForbidden, services.AuthError, err)
+		c.AbortWithStatusJSON(http.StatusForbidden, resp)
+		return false
+	}
+	return true
+}
+
+// Abort a request whose access to scan records could not be determined
+func abortAccessError(c *gin.Context, err error) {
+	resp := services.Response("SpecScans", http.StatusUnauthorized, services.TokenError, err)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, resp)
+}
+
+// Check whether a record may be read
+func (a RecordAccess) Allows(record UserRecord) bool {
+	if record.DeletedAt != 0 && !a.IncludeDeleted {
+		return false
+	}
+	if a.All || inList(record.Btr, a.Btrs) {
+		return true
+	}
+	return a.Staff && !inList(record.Btr, a.Embargoed) && record.EmbargoUntil <= a.Now
+}
+
+// Return the given document store query restricted to the records that may
+// be read
+func (a RecordAccess) Restrict(spec map[string]any) map[string]any {
+	var conditions []any
+	if len(spec) > 0 {
+		conditions = append(conditions, spec)
+	}
+	if !a.All {
+		condition := map[string]any{"btr": map[string]any{"$in": anyList(a.Btrs)}}
+		if a.Staff {
+			open := map[string]any{
+				"btr": map[string]any{"$nin": anyList(a.Embargoed)},
+				"$or": []any{
+					map[string]any{"embargo_until": map[string]any{"$exists": false}},
+					map[string]any{"embargo_until": map[string]any{"$lte": a.Now}},
+				},
+			}
+			condition = map[string]any{"$or": []any{condition, open}}
+		}
+		conditions = append(conditions, condition)
+	}
+	if !a.IncludeDeleted {
+		conditions = append(conditions, map[string]any{"deleted_at": map[string]any{"$exists": false}})
+	}
+	switch len(conditions) {
+	case 0:
+		return map[string]any{}
+	case 1:
+		return conditions[0].(map[string]any)
+	}
+	return map[string]any{"$and": conditions}
+}
+
+// Return the records that may be read
+func (a RecordAccess) Filter(records []UserRecord) []UserRecord {
+	var allowed []UserRecord
+	for _, record := range records {
+		if a.Allows(record) {
+			allowed = append(allowed, record)
+		}
+	}
+	return allowed
+}
+
+// Return a list of strings as a list of query values
+func anyList(list []string) []any {
+	values := []any{}
+	for _, item := range list {
+		values = append(values, item)
+	}
+	return values
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test restricting document store queries to the BTRs a caller may read
+func TestRecordAccess(t *testing.T) {
+	access := RecordAccess{Btrs: []string{"test-123-a"}}
+	if !access.Allows(UserRecord{Btr: "test-123-a"}) || access.Allows(UserRecord{Btr: "other-456-b"}) {
+		t.Errorf("%+v allows the wrong BTRs", access)
+	}
+	spec := access.Restrict(map[string]any{"beamline": "3a"})
+	if _, ok := spec["$and"]; !ok {
+		t.Errorf("restricted query is %+v", spec)
+	}
+	spec = map[string]any{"beamline": "3a"}
+	if restricted := (RecordAccess{All: true, IncludeDeleted: true}).Restrict(spec); len(restricted) != 1 || restricted["beamline"] != "3a" {
+		t.Errorf("unrestricted access changed query to %+v", restricted)
+	}
+}
+
+// Test that every search path only returns the records of the caller's BTRs
+func TestSearchAccess(t *testing.T) {
+	r := SetupTestService(t)
+	srvConfig.Config.AccessRules.AdminGroup = "admins"
+
+	own := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	other := testUserRecord(2, 1709647260, map[string]float64{"samx": 1})
+	other.DatasetId = "/beamline=3a/btr=other-456-b/cycle=2024-1/sample_name=sample"
+	other.Btr = "other-456-b"
+	for _, record := range []UserRecord{own, other} {
+		if response := serveTestRequest(t, r, "POST", "/add", record); response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+	}
+
+	tokens := map[string]string{
+		"user":  testToken(t, authz.CustomClaims{User: "user", Scope: "read", Btrs: []string{"test-123-a"}}),
+		"staff": testToken(t, authz.CustomClaims{User: "staff", Scope: "read " + StaffScope}),
+		"admin": testToken(t, authz.CustomClaims{User: "admin", Scope: "read", Groups: []string{"admins"}}),
+		"none":  testToken(t, authz.CustomClaims{User: "none", Scope: "read"}),
+	}
+	expected := map[string]int{"user": 1, "staff": 2, "admin": 2, "none": 0}
+	queries := []services.ServiceQuery{
+		{Query: "{}"},
+		{Query: `{"beamline": "3a"}`},
+		{Query: `{"motors.samx": 1}`},
+		{Query: `{"beamline": "3a", "motors.samx": 1}`},
+		{Spec: map[string]any{"beamline": "3a"}},
+	}
+	for caller, token := range tokens {
+		for _, query := range queries {
+			request := services.ServiceRequest{ServiceQuery: query}
+			response := serveTokenRequest(t, r, "POST", "/search", token, request)
+			if response.HttpCode != http.StatusOK {
+				t.Fatalf("search %+v by %s failed: %+v", query, caller, response)
+			}
+			if len(response.Results.Records) != expected[caller] {
+				t.Errorf("search %+v by %s found %d records; want %d", query, caller, len(response.Results.Records), expected[caller])
+			}
+			for _, record := range response.Results.Records {
+				if caller == "user" && record["btr"] != "test-123-a" {
+					t.Errorf("search %+v by %s found a record of BTR %v", query, caller, record["btr"])
+				}
+			}
+		}
+	}
+
+	sid := searchTestService(t, r, `{"btr": "other-456-b"}`)[0]["sid"].(string)
+	response := serveTokenRequest(t, r, "GET", "/status?sid="+sid, tokens["user"], nil)
+	if response.Results.NRecords != 0 {
+		t.Errorf("user got the status of a scan of another BTR: %+v", response)
+	}
+	response = serveTokenRequest(t, r, "POST", "/search", "", services.ServiceRequest{ServiceQuery: queries[0]})
+	if response.HttpCode != http.StatusUnauthorized {
+		t.Errorf("search without a token returned %+v", response)
+	}
+}
+
+// Test that callers may only edit the records they may read, and not move
+// them to other BTRs
+func TestEditAccess(t *testing.T) {
+	r := SetupTestService(t)
+	own := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	other := testUserRecord(2, 1709647260, map[string]float64{"samx": 1})
+	other.DatasetId = "/beamline=3a/btr=other-456-b/cycle=2024-1/sample_name=sample"
+	other.Btr = "other-456-b"
+	for _, record := range []UserRecord{own, other} {
+		if response := serveTestRequest(t, r, "POST", "/add", record); response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+	}
+	own_sid := searchTestService(t, r, `{"btr": "test-123-a"}`)[0]["sid"].(string)
+	other_sid := searchTestService(t, r, `{"btr": "other-456-b"}`)[0]["sid"].(string)
+	user := testToken(t, authz.CustomClaims{User: "user", Scope: "read write", Btrs: []string{"test-123-a"}})
+
+	response := serveTokenRequest(t, r, "PUT", "/edit", user, map[string]any{"sid": other_sid, "command": "dscan samx 0 1 10 1"})
+	if response.HttpCode != http.StatusNotFound || response.Results.NRecords != 0 {
+		t.Errorf("editing a record of another BTR returned %+v", response)
+	}
+	response = serveTokenRequest(t, r, "POST", "/status", user, StatusTransition{ScanId: other_sid, Status: StatusCompleted})
+	if response.HttpCode != http.StatusNotFound {
+		t.Errorf("changing the status of a record of another BTR returned %+v", response)
+	}
+	moved := map[string]any{"sid": own_sid, "btr": "other-456-b", "did": other.DatasetId}
+	response = serveTokenRequest(t, r, "PUT", "/edit", user, moved)
+	if response.SrvCode == services.OK {
+		t.Errorf("moving a record to another BTR was accepted: %+v", response)
+	}
+	response = serveTokenRequest(t, r, "PUT", "/edit", user, map[string]any{"sid": own_sid, "command": "dscan samx 0 1 10 1"})
+	if response.SrvCode != services.OK {
+		t.Errorf("editing an own record failed: %+v", response)
+	}
+	if record := searchTestService(t, r, `{"btr": "other-456-b"}`)[0]; record["command"] != other.Command || record["status"] == StatusCompleted {
+		t.Errorf("record of another BTR was changed to %+v", record)
+	}
+}
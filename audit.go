@@ -0,0 +1,552 @@
+package main
+
+// audit module
+//
+// Every request which may change scan records is recorded in an append-only
+// audit log: who made it, to which endpoint, the scans it affected with the
+// values of their changed fields before and after, and its outcome. Changes
+// are captured by the stores the request's handler uses, so they are the
+// changes actually made to the stored records.
+//
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// var AuditDocs holds the audit log; entries are only ever inserted
+var AuditDocs DocumentStore
+
+// Outcomes of audited requests
+const (
+	AuditSuccess = "success"
+	AuditPartial = "partial"
+	AuditFailure = "failure"
+)
+
+// Key of the audit trail of a request in its gin context
+const auditTrailKey = "audit_trail"
+
+// AuditChange is a change made to a scan's document, or to another stored
+// entry (motor catalog entries, variable declarations, BTR embargoes) named by
+// its key: the values of the changed fields before and after the change
+type AuditChange struct {
+	ScanId string         `json:"sid,omitempty"`
+	Key    string         `json:"key,omitempty"`
+	Before map[string]any `json:"before,omitempty"`
+	After  map[string]any `json:"after,omitempty"`
+}
+
+// AuditEntry is the audit log entry of a request
+type AuditEntry struct {
+	Time     float64             `json:"time"`
+	Actor    Identity            `json:"actor"`
+	Method   string              `json:"method"`
+	Endpoint string              `json:"endpoint"`
+	Params   map[string][]string `json:"params,omitempty"`
+	Sandbox  bool                `json:"sandbox,omitempty"`
+	Sids     []string            `json:"sids"`
+	Changes  []AuditChange       `json:"changes"`
+	HttpCode int                 `json:"http_code,omitempty"`
+	GrpcCode string              `json:"grpc_code,omitempty"`
+	Outcome  string              `json:"outcome"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// AuditTrail collects the changes made while serving a request, and the
+// errors of parts of it which failed
+type AuditTrail struct {
+	mu       sync.Mutex
+	changes  []AuditChange
+	failures []string
+}
+
+// Add a change to the trail; changes to a nil trail are not recorded
+func (t *AuditTrail) Record(change AuditChange) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.changes = append(t.changes, change)
+}
+
+// Add the error of a failed part of a request to the trail
+func (t *AuditTrail) RecordFailure(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = append(t.failures, err.Error())
+}
+
+// Return the errors of failed parts of the request
+func (t *AuditTrail) Failures() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.failures...)
+}
+
+// Return the changes in the trail
+func (t *AuditTrail) Changes() []AuditChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]AuditChange{}, t.changes...)
+}
+
+// Return the audit trail of a request, or nil if it is not audited
+func requestAuditTrail(c *gin.Context) *AuditTrail {
+	if trail, ok := c.Get(auditTrailKey); ok {
+		return trail.(*AuditTrail)
+	}
+	return nil
+}
+
+// Return the change between two versions of a document, with the fields
+// which differ
+func documentChange(sid string, before map[string]any, after map[string]any) AuditChange {
+	change := AuditChange{ScanId: sid, Before: map[string]any{}, After: map[string]any{}}
+	for key, value := range before {
+		if key == "_id" {
+			continue
+		}
+		if after_value, ok := after[key]; !ok || !reflect.DeepEqual(value, after_value) {
+			change.Before[key] = value
+		}
+	}
+	for key, value := range after {
+		if key == "_id" {
+			continue
+		}
+		if before_value, ok := before[key]; !ok || !reflect.DeepEqual(value, before_value) {
+			change.After[key] = value
+		}
+	}
+	return change
+}
+
+// Return a stored entry as a document, or nil if there is none
+func entryDocument[T any](entries []T) map[string]any {
+	if len(entries) == 0 {
+		return nil
+	}
+	var document map[string]any
+	if err := normalizeDocument(entries[0], &document); err != nil {
+		log.Printf("Unable to record entry %+v in the audit log: %v", entries[0], err)
+	}
+	return document
+}
+
+// auditDocumentStore is a DocumentStore which records the changes made
+// through it in an audit trail
+type auditDocumentStore struct {
+	DocumentStore
+	trail *AuditTrail
+}
+
+func (s auditDocumentStore) Insert(records ...map[string]any) error {
+	err := s.DocumentStore.Insert(records...)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		sid, _ := record["sid"].(string)
+		s.trail.Record(documentChange(sid, nil, record))
+	}
+	return nil
+}
+
+func (s auditDocumentStore) Update(spec map[string]any, update map[string]any) error {
+	before, err := s.DocumentStore.Get(spec, 0, 1)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.auditDocumentStore.Update] Get error: %w", err)
+	}
+	err = s.DocumentStore.Update(spec, update)
+	if err != nil || len(before) == 0 {
+		return err
+	}
+	sid, _ := before[0]["sid"].(string)
+	after, err := s.DocumentStore.Get(map[string]any{"sid": sid}, 0, 1)
+	if err != nil || len(after) == 0 {
+		log.Printf("Unable to record the update of %s in the audit log: %v", sid, err)
+		return nil
+	}
+	s.trail.Record(documentChange(sid, before[0], after[0]))
+	return nil
+}
+
+func (s auditDocumentStore) Remove(spec map[string]any) error {
+	before, err := s.DocumentStore.Get(spec, 0, 0)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.auditDocumentStore.Remove] Get error: %w", err)
+	}
+	err = s.DocumentStore.Remove(spec)
+	if err != nil {
+		return err
+	}
+	for _, record := range before {
+		sid, _ := record["sid"].(string)
+		s.trail.Record(documentChange(sid, record, nil))
+	}
+	return nil
+}
+
+// auditMotorStore is a MotorStore which records the changes made to the
+// motor catalog through it in an audit trail
+type auditMotorStore struct {
+	MotorStore
+	trail *AuditTrail
+}
+
+func (s auditMotorStore) SetMotorInfo(info MotorInfo) error {
+	before, _ := s.MotorStore.GetMotorInfo([]string{info.Beamline}, []string{info.Mne})
+	err := s.MotorStore.SetMotorInfo(info)
+	if err != nil {
+		return err
+	}
+	after, _ := s.MotorStore.GetMotorInfo([]string{info.Beamline}, []string{info.Mne})
+	change := documentChange("", entryDocument(before), entryDocument(after))
+	change.Key = fmt.Sprintf("motor:%s/%s", info.Beamline, info.Mne)
+	s.trail.Record(change)
+	return nil
+}
+
+// auditVariableStore is a VariableStore which records the changes made to
+// variable declarations through it in an audit trail
+type auditVariableStore struct {
+	VariableStore
+	trail *AuditTrail
+}
+
+func (s auditVariableStore) SetVariableInfo(info VariableInfo) error {
+	before, _ := s.VariableStore.GetVariableInfo([]string{info.Name})
+	err := s.VariableStore.SetVariableInfo(info)
+	if err != nil {
+		return err
+	}
+	after, _ := s.VariableStore.GetVariableInfo([]string{info.Name})
+	change := documentChange("", entryDocument(before), entryDocument(after))
+	change.Key = "variable:" + info.Name
+	s.trail.Record(change)
+	return nil
+}
+
+// auditEmbargoStore is an EmbargoStore which records the changes made
+// through it in an audit trail
+type auditEmbargoStore struct {
+	EmbargoStore
+	trail *AuditTrail
+}
+
+func (s auditEmbargoStore) SetEmbargo(embargo Embargo) error {
+	before, _ := s.EmbargoStore.GetEmbargoes([]string{embargo.Btr}, 0)
+	err := s.EmbargoStore.SetEmbargo(embargo)
+	if err != nil {
+		return err
+	}
+	after, _ := s.EmbargoStore.GetEmbargoes([]string{embargo.Btr}, 0)
+	change := documentChange("", entryDocument(before), entryDocument(after))
+	change.Key = "embargo:" + embargo.Btr
+	s.trail.Record(change)
+	return nil
+}
+
+// Return the given stores recording the changes made through them in the
+// given audit trail (the stores themselves if the trail is nil)
+func auditedStores(stores ScanStores, trail *AuditTrail) ScanStores {
+	if trail == nil {
+		return stores
+	}
+	return ScanStores{
+		Docs:      auditDocumentStore{DocumentStore: stores.Docs, trail: trail},
+		Motors:    auditMotorStore{MotorStore: stores.Motors, trail: trail},
+		Variables: auditVariableStore{VariableStore: stores.Variables, trail: trail},
+		Embargoes: auditEmbargoStore{EmbargoStore: stores.Embargoes, trail: trail},
+		Sandbox:   stores.Sandbox,
+	}
+}
+
+// auditWriter keeps a copy of the response to an audited request
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Return the scan ids given in the JSON body of a request, by the "sid" keys
+// of a record or a list of records, so requests which fail before changing
+// anything are still logged with the scans they were meant for
+func bodySids(body []byte) []string {
+	var records []map[string]any
+	if json.Unmarshal(body, &records) != nil {
+		var record map[string]any
+		if json.Unmarshal(body, &record) != nil {
+			return nil
+		}
+		records = append(records, record)
+	}
+	var sids []string
+	for _, record := range records {
+		if sid, ok := record["sid"].(string); ok && sid != "" {
+			sids = append(sids, sid)
+		}
+	}
+	return sids
+}
+
+// Return the audit log entry of a request served with the given trail and
+// response
+func newAuditEntry(c *gin.Context, body []byte, trail *AuditTrail, writer *auditWriter, at float64) AuditEntry {
+	entry := AuditEntry{
+		Time:     at,
+		Actor:    requestIdentity(c),
+		Method:   c.Request.Method,
+		Endpoint: c.FullPath(),
+		Params:   c.Request.URL.Query(),
+		Changes:  trail.Changes(),
+		HttpCode: writer.Status(),
+	}
+	entry.Sandbox, _ = isSandboxRequest(c)
+	// Service responses carry their own status, e.g. of partly failed adds
+	var response services.ServiceResponse
+	if json.Unmarshal(writer.body.Bytes(), &response) == nil && response.HttpCode != 0 {
+		entry.HttpCode = response.HttpCode
+		entry.Error = response.Error
+	}
+	switch {
+	case entry.HttpCode == http.StatusMultiStatus:
+		entry.Outcome = AuditPartial
+	case entry.HttpCode < http.StatusBadRequest && entry.Error == "":
+		entry.Outcome = AuditSuccess
+	default:
+		entry.Outcome = AuditFailure
+	}
+	entry.setSids(c.QueryArray("sid"), bodySids(body))
+	return entry
+}
+
+// Set the scan ids of an entry, those of its changes and the given ones
+func (e *AuditEntry) setSids(given ...[]string) {
+	sids := map[string]bool{}
+	for _, change := range e.Changes {
+		if change.ScanId != "" {
+			sids[change.ScanId] = true
+		}
+	}
+	for _, list := range given {
+		for _, sid := range list {
+			sids[sid] = true
+		}
+	}
+	e.Sids = []string{}
+	for sid := range sids {
+		e.Sids = append(e.Sids, sid)
+	}
+	sort.Strings(e.Sids)
+}
+
+// Insert an entry in the audit log
+func recordAuditEntry(entry AuditEntry) {
+	if AuditDocs == nil {
+		return
+	}
+	var document map[string]any
+	err := normalizeDocument(entry, &document)
+	if err == nil {
+		err = AuditDocs.Insert(document)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to record %s %s in the audit log: %v", entry.Method, entry.Endpoint, err)
+	}
+}
+
+// Return a handler serving requests with the given handler and recording
+// them in the audit log
+func Audited(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		at := epochTime(time.Now())
+		trail := &AuditTrail{}
+		c.Set(auditTrailKey, trail)
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(c.Request.Body)
+			if err != nil {
+				log.Printf("ERROR: unable to read the body of %s %s: %v", c.Request.Method, c.Request.URL, err)
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		writer := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		handler(c)
+		recordAuditEntry(newAuditEntry(c, body, trail, writer, at))
+	}
+}
+
+// Routes which only read records although their method is not GET
+var readOnlyRoutes = []string{"POST /search", "POST /graphql"}
+
+// Return the given routes, with those which may change records audited
+func auditRoutes(routes []server.Route) []server.Route {
+	for i, route := range routes {
+		if route.Method != http.MethodGet && !inList(route.Method+" "+route.Path, readOnlyRoutes) {
+			routes[i].Handler = Audited(route.Handler)
+		}
+	}
+	return routes
+}
+
+// Handler for querying the audit log, by the actor ("actor" URL parameter),
+// the time ("time" URL parameter, in the syntax of time queries) and the scan
+// ids ("sid" URL parameters) of entries. The changes of scans the caller may
+// not read are left out.
+func AuditHandler(c *gin.Context) {
+	if !requireStaff(c) {
+		return
+	}
+	if AuditDocs == nil {
+		err := errors.New("the audit log is not configured")
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DatabaseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	spec := map[string]any{}
+	if actor := c.Query("actor"); actor != "" {
+		spec["actor.user"] = actor
+	}
+	if endpoint := c.Query("endpoint"); endpoint != "" {
+		spec["endpoint"] = endpoint
+	}
+	if sids := c.QueryArray("sid"); len(sids) > 0 {
+		spec["sids"] = map[string]any{"$in": anyList(sids)}
+	}
+	if at := c.Query("time"); at != "" {
+		value, err := convertTimeQuery(at, time.Now())
+		if err != nil {
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		spec["time"] = value
+	}
+	idx, limit, err := pageParams(c)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	entries, err := AuditDocs.Get(spec, idx, limit)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	for _, entry := range entries {
+		delete(entry, "_id")
+	}
+	claims, err := requestClaims(c)
+	if err == nil {
+		entries, err = redactAuditEntries(claims, entries)
+	}
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(entries),
+			Records:  entries,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Return audit log entries without the changes of the scans which the holder
+// of a token with the given claims may not read, marking the entries whose
+// changes were removed as redacted
+func redactAuditEntries(claims *authz.Claims, entries []map[string]any) ([]map[string]any, error) {
+	if isAdmin(claims) {
+		return entries, nil
+	}
+	redacted := make([]map[string]any, 0, len(entries))
+	sids := map[bool][]string{}
+	for _, entry := range entries {
+		var normalized map[string]any
+		if err := normalizeDocument(entry, &normalized); err != nil {
+			return nil, fmt.Errorf("[SpecScansService.main.redactAuditEntries] normalizeDocument error: %w", err)
+		}
+		sandbox, _ := normalized["sandbox"].(bool)
+		changes, _ := normalized["changes"].([]any)
+		for _, change := range changes {
+			if sid, _ := change.(map[string]any)["sid"].(string); sid != "" {
+				sids[sandbox] = append(sids[sandbox], sid)
+			}
+		}
+		redacted = append(redacted, normalized)
+	}
+	readable := map[bool]map[string]bool{}
+	for sandbox, namespace_sids := range sids {
+		readable[sandbox] = map[string]bool{}
+		stores, err := scanStores(sandbox, nil)
+		if err != nil {
+			// without a sandbox, none of its scans may be read
+			continue
+		}
+		access, err := claimsAccess(claims, stores)
+		if err != nil {
+			return nil, fmt.Errorf("[SpecScansService.main.redactAuditEntries] claimsAccess error: %w", err)
+		}
+		access.IncludeDeleted = true
+		spec := access.Restrict(map[string]any{"sid": map[string]any{"$in": anyList(namespace_sids)}})
+		records, err := stores.Docs.Get(spec, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("[SpecScansService.main.redactAuditEntries] stores.Docs.Get error: %w", err)
+		}
+		for _, record := range records {
+			readable[sandbox][fmt.Sprint(record["sid"])] = true
+		}
+	}
+	for _, entry := range redacted {
+		sandbox, _ := entry["sandbox"].(bool)
+		changes, _ := entry["changes"].([]any)
+		kept := []any{}
+		for _, change := range changes {
+			sid, _ := change.(map[string]any)["sid"].(string)
+			if sid == "" || readable[sandbox][sid] {
+				kept = append(kept, change)
+			}
+		}
+		if len(kept) < len(changes) {
+			entry["changes"] = kept
+			entry["redacted"] = true
+		}
+	}
+	return redacted, nil
+}
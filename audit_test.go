@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test that write requests are recorded in the audit log
+func TestAuditLog(t *testing.T) {
+	r := SetupTestService(t)
+
+	response := serveTestRequest(t, r, "POST", "/add", testUserRecord(1, 1709647200, map[string]float64{"samx": 1}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("adding a record failed: %+v", response)
+	}
+	sid := response.Results.Records[0]["sid"].(string)
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sid, "command": "dscan samx 0 1 10 1"})
+	if response.SrvCode != services.OK {
+		t.Fatalf("editing a record failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sid, "duration": 1.0})
+	if response.SrvCode == services.OK {
+		t.Fatalf("editing the duration of a scan was accepted: %+v", response)
+	}
+	response = serveTestRequest(t, r, "POST", "/status", StatusTransition{ScanId: sid, Status: StatusCompleted, Time: 1709647290})
+	if response.SrvCode != services.OK {
+		t.Fatalf("completing a scan failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "DELETE", "/records?sid="+sid, nil)
+	if response.SrvCode != services.OK {
+		t.Fatalf("deleting a scan failed: %+v", response)
+	}
+	user := testToken(t, authz.CustomClaims{User: "user", Scope: "read write", Btrs: []string{"test-123-a"}})
+	cataloger := testToken(t, authz.CustomClaims{User: "user", Scope: "read write " + StaffScope})
+	response = serveTokenRequest(t, r, "PUT", "/motors", cataloger, []MotorInfo{{Mne: "samx", Units: "mm", Beamline: "3a"}})
+	if response.SrvCode != services.OK {
+		t.Fatalf("editing the motor catalog failed: %+v", response)
+	}
+	searchTestService(t, r, "{}")
+
+	audit := func(token string, query url.Values) services.ServiceResponse {
+		return serveTokenRequest(t, r, "GET", "/audit?"+query.Encode(), token, nil)
+	}
+	staff := testToken(t, authz.CustomClaims{User: "staff", Scope: "read " + StaffScope})
+	response = audit(staff, url.Values{"sid": {sid}})
+	if response.SrvCode != services.OK {
+		t.Fatalf("querying the audit log failed: %+v", response)
+	}
+	entries := response.Results.Records
+	if len(entries) != 5 {
+		t.Fatalf("audit log has %d entries of %s; want 5: %+v", len(entries), sid, entries)
+	}
+	expected := []struct {
+		method   string
+		endpoint string
+		outcome  string
+	}{
+		{"POST", "/add", AuditSuccess},
+		{"PUT", "/edit", AuditSuccess},
+		{"PUT", "/edit", AuditFailure},
+		{"POST", "/status", AuditSuccess},
+		{"DELETE", "/records", AuditSuccess},
+	}
+	for i, entry := range entries {
+		actor, _ := entry["actor"].(map[string]any)
+		if entry["method"] != expected[i].method || entry["endpoint"] != expected[i].endpoint || entry["outcome"] != expected[i].outcome || actor["user"] != "staff" {
+			t.Errorf("audit log entry %d is %+v; want %+v by staff", i, entry, expected[i])
+		}
+	}
+	changes, _ := entries[1]["changes"].([]any)
+	if len(changes) != 1 {
+		t.Fatalf("edit changed %d records; want 1: %+v", len(changes), entries[1])
+	}
+	change := changes[0].(map[string]any)
+	before, _ := change["before"].(map[string]any)
+	after, _ := change["after"].(map[string]any)
+	if change["sid"] != sid || before["command"] != "ascan  samx 0 1 10 1" || after["command"] != "dscan samx 0 1 10 1" {
+		t.Errorf("edit recorded change %+v", change)
+	}
+	if entries[2]["http_code"] == float64(http.StatusOK) || entries[2]["error"] == "" || len(entries[2]["changes"].([]any)) != 0 {
+		t.Errorf("failed edit recorded as %+v", entries[2])
+	}
+
+	response = audit(staff, url.Values{"actor": {"user"}})
+	if len(response.Results.Records) != 1 {
+		t.Fatalf("audit log has %d entries by user; want 1", len(response.Results.Records))
+	}
+	changes, _ = response.Results.Records[0]["changes"].([]any)
+	if len(changes) != 1 || changes[0].(map[string]any)["key"] != "motor:3a/samx" {
+		t.Errorf("motor catalog edit recorded changes %+v", changes)
+	}
+	response = audit(staff, url.Values{"time": {"last 1h"}})
+	if len(response.Results.Records) != 6 {
+		t.Errorf("audit log has %d entries of the last hour; want 6", len(response.Results.Records))
+	}
+	response = audit(staff, url.Values{"time": {"2024-01-01..2024-01-02"}})
+	if len(response.Results.Records) != 0 {
+		t.Errorf("audit log has %d entries of 2024-01-01", len(response.Results.Records))
+	}
+	response = audit(staff, url.Values{"time": {"sometime"}})
+	if response.HttpCode != http.StatusBadRequest {
+		t.Errorf("querying the audit log with an invalid time returned %+v", response)
+	}
+	response = audit(user, url.Values{})
+	if response.HttpCode != http.StatusForbidden {
+		t.Errorf("querying the audit log without the staff scope returned %+v", response)
+	}
+
+	// Staff do not see the changes of scans under embargo, admins do
+	response = serveTestRequest(t, r, "PUT", "/embargo", Embargo{ScanId: sid, Until: epochTime(time.Now().Add(time.Hour))})
+	if response.SrvCode != services.OK {
+		t.Fatalf("setting an embargo failed: %+v", response)
+	}
+	response = audit(staff, url.Values{"sid": {sid}, "endpoint": {"/edit"}})
+	if entries := response.Results.Records; len(entries) != 2 || entries[0]["redacted"] != true || len(entries[0]["changes"].([]any)) != 0 {
+		t.Errorf("audit log has edits of a scan under embargo %+v", entries)
+	}
+	srvConfig.Config.AccessRules.AdminGroup = "admins"
+	admin := testToken(t, authz.CustomClaims{User: "admin", Scope: "read", Groups: []string{"admins"}})
+	response = audit(admin, url.Values{"sid": {sid}, "endpoint": {"/edit"}})
+	if entries := response.Results.Records; len(entries) != 2 || entries[0]["redacted"] != nil || len(entries[0]["changes"].([]any)) != 1 {
+		t.Errorf("audit log has edits %+v for admins", entries)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Return the claims of the token a request was made with
+func requestClaims(c *gin.Context) (*authz.Claims, error) {
+	return tokenClaims(authz.RequestToken(c.Request))
+}
+
+// Return the claims of a token
+func tokenClaims(token string) (*authz.Claims, error) {
+	if token == "" {
+		return nil, errors.New("request has no token")
+	}
+	claims, err := authz.TokenClaims(token, srvConfig.Config.Authz.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("[SpecScansService.main.tokenClaims] authz.TokenClaims error: %w", err)
+	}
+	return claims, nil
+}
+
+// Identity is who makes a change to a record: the authenticated user and the
+// client application their token was issued to
+type Identity struct {
+	User   string `json:"user" mapstructure:"user"`
+	Client string `json:"client,omitempty" mapstructure:"client,omitempty"`
+}
+
+// Return the identity of the caller of a request, which is empty for requests
+// without a valid token
+func requestIdentity(c *gin.Context) Identity {
+	claims, err := requestClaims(c)
+	if err != nil {
+		return Identity{}
+	}
+	return claimsIdentity(claims)
+}
+
+// Return the identity of the holder of a token with the given claims
+func claimsIdentity(claims *authz.Claims) Identity {
+	return Identity{User: claims.CustomClaims.User, Client: claims.CustomClaims.Application}
+}
+
+// Check whether the given claims belong to a member of the FOXDEN admin group
+func isAdmin(claims *authz.Claims) bool {
+	admin_group := srvConfig.Config.AccessRules.AdminGroup
+	return admin_group != "" && inList(admin_group, claims.CustomClaims.Groups)
+}
+
+// Abort the request unless it was made by an admin; return whether it was
+func requireAdmin(c *gin.Context) bool {
+	claims, err := requestClaims(c)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusUnauthorized, services.TokenError, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, resp)
+		return false
+	}
+	if !isAdmin(claims) {
+		err := fmt.Errorf("user %s is not a member of the %s group", claims.CustomClaims.User, srvConfig.Config.AccessRules.AdminGroup)
+		resp := services.Response("SpecScans", http.StatusForbidden, services.AuthError, err)
+		c.AbortWithStatusJSON(http.StatusForbidden, resp)
+		return false
+	}
+	return true
+}
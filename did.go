@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	utils "github.com/CHESSComputing/golib/utils"
+)
+
+// Fields of scan records which are also components of their DIDs
+var DIDFields = []string{"beamline", "btr", "cycle"}
+
+// Return the DID separator and divider of the configuration, or their
+// defaults
+func didFormat() (string, string) {
+	separator, divider := "/", "="
+	if srvConfig.Config != nil {
+		if srvConfig.Config.DID.Separator != "" {
+			separator = srvConfig.Config.DID.Separator
+		}
+		if srvConfig.Config.DID.Divider != "" {
+			divider = srvConfig.Config.DID.Divider
+		}
+	}
+	return separator, divider
+}
+
+// Parse a DID (e.g. "/beamline=3a/btr=abc-123-a/cycle=2024-1") into its
+// components, by key
+func ParseDID(did string) (map[string]string, error) {
+	separator, divider := didFormat()
+	components := make(map[string]string)
+	for _, part := range strings.Split(did, separator) {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, divider)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("[SpecScansService.main.ParseDID] component %q of did %s is not of the form key%svalue", part, did, divider)
+		}
+		key = strings.ToLower(key)
+		if _, ok := components[key]; ok {
+			return nil, fmt.Errorf("[SpecScansService.main.ParseDID] did %s has more than one %s", did, key)
+		}
+		components[key] = value
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("[SpecScansService.main.ParseDID] did %q has no components", did)
+	}
+	return components, nil
+}
+
+// Return the DID of a dataset with the given components, in the configured
+// format
+func BuildDID(components map[string]any) string {
+	separator, divider := didFormat()
+	attributes := ""
+	if srvConfig.Config != nil {
+		attributes = srvConfig.Config.DID.Attributes
+	}
+	return utils.CreateDID(components, attributes, separator, divider)
+}
+
+// Check the DID fields of a record against its DID. Empty fields are set to
+// the DID's components; fields the DID has no component for are not checked.
+func matchDID(did string, fields map[string]*string) error {
+	components, err := ParseDID(did)
+	if err != nil {
+		return err
+	}
+	for _, key := range DIDFields {
+		component, ok := components[key]
+		if !ok {
+			continue
+		}
+		if *fields[key] == "" {
+			*fields[key] = component
+		} else if !strings.EqualFold(*fields[key], component) {
+			return fmt.Errorf("[SpecScansService.main.matchDID] %s %q contradicts did %s", key, *fields[key], did)
+		}
+	}
+	return nil
+}
+
+// Check the beamline, btr and cycle of a new record against its DID. Missing
+// fields are filled in from the DID, and a record without a DID gets one
+// built from its fields.
+func PrepareDID(record *UserRecord) error {
+	fields := map[string]*string{"beamline": &record.Beamline, "btr": &record.Btr, "cycle": &record.Cycle}
+	if record.DatasetId != "" {
+		return matchDID(record.DatasetId, fields)
+	}
+	components := make(map[string]any)
+	for _, key := range DIDFields {
+		if *fields[key] == "" {
+			return fmt.Errorf("[SpecScansService.main.PrepareDID] record has no did, and no %s to build one", key)
+		}
+		components[key] = *fields[key]
+	}
+	record.DatasetId = BuildDID(components)
+	if record.DatasetId == "" {
+		return fmt.Errorf("[SpecScansService.main.PrepareDID] configured did attributes include none of %v", DIDFields)
+	}
+	return nil
+}
+
+// Check the beamline, btr and cycle of an edited record (as a map) against
+// its DID
+func CheckDID(record map[string]any) error {
+	did, _ := record["did"].(string)
+	if did == "" {
+		return fmt.Errorf("[SpecScansService.main.CheckDID] record has no did")
+	}
+	fields := make(map[string]*string)
+	for _, key := range DIDFields {
+		value, _ := record[key].(string)
+		fields[key] = &value
+	}
+	return matchDID(did, fields)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test parsing DIDs with the configured separator and divider
+func TestParseDID(t *testing.T) {
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	components, err := ParseDID("/beamline=3a/BTR=test-123-a/cycle=2024-1/sample_name=a=b")
+	expected := map[string]string{"beamline": "3a", "btr": "test-123-a", "cycle": "2024-1", "sample_name": "a=b"}
+	if err != nil || !reflect.DeepEqual(components, expected) {
+		t.Errorf("ParseDID returned %v, %v; want %v", components, err, expected)
+	}
+	for _, did := range []string{"", "/", "/beamline=3a/beamline=3b", "/beamline", "/=3a"} {
+		if _, err := ParseDID(did); err == nil {
+			t.Errorf("ParseDID(%q) should fail", did)
+		}
+	}
+
+	srvConfig.Config.DID.Separator = ":"
+	srvConfig.Config.DID.Divider = "-"
+	components, err = ParseDID(":beamline-3a:cycle-2024-1")
+	if err != nil || components["beamline"] != "3a" || components["cycle"] != "2024-1" {
+		t.Errorf("ParseDID with a custom format returned %v, %v", components, err)
+	}
+}
+
+// Test checking records against their DIDs and deriving missing fields
+func TestPrepareDID(t *testing.T) {
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	record := UserRecord{DatasetId: "/beamline=3a/btr=test-123-a/cycle=2024-1/sample_name=s", Beamline: "3A"}
+	if err := PrepareDID(&record); err != nil {
+		t.Fatalf("PrepareDID error: %v", err)
+	}
+	if record.Beamline != "3A" || record.Btr != "test-123-a" || record.Cycle != "2024-1" {
+		t.Errorf("PrepareDID derived %+v", record)
+	}
+
+	record = UserRecord{DatasetId: "/beamline=3a/btr=test-123-a", Beamline: "3b"}
+	if err := PrepareDID(&record); err == nil {
+		t.Error("PrepareDID accepted a beamline contradicting the did")
+	}
+
+	record = UserRecord{Beamline: "3a", Btr: "Test-123-a", Cycle: "2024-1"}
+	if err := PrepareDID(&record); err != nil || record.DatasetId != "/beamline=3a/btr=test-123-a/cycle=2024-1" {
+		t.Errorf("PrepareDID built did %q, %v", record.DatasetId, err)
+	}
+	if err := PrepareDID(&UserRecord{Beamline: "3a", Cycle: "2024-1"}); err == nil {
+		t.Error("PrepareDID built a did without a btr")
+	}
+}
+
+// Test that records contradicting their DIDs are rejected at ingest and edit
+func TestAddEditDID(t *testing.T) {
+	r := SetupTestService(t)
+
+	record := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	record.Cycle = "2023-3"
+	response := serveTestRequest(t, r, "POST", "/add", record)
+	if response.HttpCode != http.StatusUnprocessableEntity {
+		t.Errorf("adding a record contradicting its did returned %+v", response)
+	}
+
+	record = testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	record.DatasetId = ""
+	response = serveTestRequest(t, r, "POST", "/add", record)
+	if response.SrvCode != services.OK {
+		t.Fatalf("adding a record without a did failed: %+v", response)
+	}
+	records := searchTestService(t, r, `{"did": "/beamline=3a/btr=test-123-a/cycle=2024-1"}`)
+	if len(records) != 1 {
+		t.Errorf("found %d records by their built did; want 1", len(records))
+	}
+
+	edit := map[string]any{"sid": records[0]["sid"], "btr": "other-456-b"}
+	response = serveTestRequest(t, r, "PUT", "/edit", edit)
+	if response.HttpCode != http.StatusUnprocessableEntity {
+		t.Errorf("editing a btr contradicting the did returned %+v", response)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+// embargo module
+//
+// Embargoes hide the scans of proprietary beamtime requests (BTRs) from
+// general search until a given time: staff only see embargoed records of the
+// BTRs in their token claims, like other users. An embargo covers either all
+// scans of a BTR, including ones added later, or a single scan (kept in the
+// scan's document as embargo_until). Embargoes are released when their time
+// passes, without further action.
+//
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Record keys maintained by the embargo API, which adds and edits may not set
+var EmbargoKeys = []string{"embargo_until"}
+
+// Error of embargoes which are neither of a BTR nor of a scan
+var ErrInvalidEmbargo = errors.New("invalid embargo")
+
+// Embargo hides the scans of a BTR, or a single scan, from general search
+// until the given epoch time. An embargo without an end time lifts any
+// embargo of the BTR or scan.
+type Embargo struct {
+	Btr    string  `json:"btr,omitempty"`
+	ScanId string  `json:"sid,omitempty"`
+	Until  float64 `json:"until"`
+}
+
+// Check that an embargo is either of a BTR or of a scan
+func (e Embargo) Validate() error {
+	if (e.Btr == "") == (e.ScanId == "") {
+		return errors.New("an embargo needs either a btr or a sid")
+	}
+	if e.Until < 0 {
+		return fmt.Errorf("invalid embargo end time %v", e.Until)
+	}
+	return nil
+}
+
+// Get the embargoes of the given BTRs (of all BTRs if none are given) which
+// last beyond the given time
+func GetEmbargoes(btrs []string, after float64, db *sql.DB, dialect SQLDialect) ([]Embargo, error) {
+	embargoes := []Embargo{}
+	query := "SELECT btr, embargo_until FROM Embargoes WHERE embargo_until > ?"
+	args := []any{after}
+	if len(btrs) > 0 {
+		query += " AND btr IN (?" + strings.Repeat(", ?", len(btrs)-1) + ")"
+		for _, btr := range btrs {
+			args = append(args, btr)
+		}
+	}
+	rows, err := db.Query(dialect.Rebind(query+" ORDER BY btr"), args...)
+	if err != nil {
+		return embargoes, fmt.Errorf("[SpecScansService.main.GetEmbargoes] db.Query error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var embargo Embargo
+		if err = rows.Scan(&embargo.Btr, &embargo.Until); err != nil {
+			return embargoes, fmt.Errorf("[SpecScansService.main.GetEmbargoes] rows.Scan error: %w", err)
+		}
+		embargoes = append(embargoes, embargo)
+	}
+	if err = rows.Err(); err != nil {
+		return embargoes, fmt.Errorf("[SpecScansService.main.GetEmbargoes] rows.Err error: %w", err)
+	}
+	return embargoes, nil
+}
+
+// Set the embargo of a BTR, or lift it if it has no end time
+func SetEmbargo(embargo Embargo, db *sql.DB, dialect SQLDialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetEmbargo] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(dialect.Rebind("DELETE FROM Embargoes WHERE btr = ?"), embargo.Btr)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetEmbargo] tx.Exec error: %w", err)
+	}
+	if embargo.Until != 0 {
+		_, err = tx.Exec(dialect.Rebind("INSERT INTO Embargoes (btr, embargo_until) VALUES (?, ?)"), embargo.Btr, embargo.Until)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.SetEmbargo] tx.Exec error: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetEmbargo] tx.Commit error: %w", err)
+	}
+	return nil
+}
+
+// Set or lift an embargo in the given stores
+func ApplyEmbargo(stores ScanStores, embargo Embargo) error {
+	if err := embargo.Validate(); err != nil {
+		return fmt.Errorf("[SpecScansService.main.ApplyEmbargo] %w: %v", ErrInvalidEmbargo, err)
+	}
+	if embargo.Btr != "" {
+		if err := stores.Embargoes.SetEmbargo(embargo); err != nil {
+			return fmt.Errorf("[SpecScansService.main.ApplyEmbargo] stores.Embargoes.SetEmbargo error: %w", err)
+		}
+		return nil
+	}
+	spec := map[string]any{"sid": embargo.ScanId}
+	n, err := stores.Docs.Count(spec)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.ApplyEmbargo] stores.Docs.Count error: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("[SpecScansService.main.ApplyEmbargo] %w: %s", ErrScanNotFound, embargo.ScanId)
+	}
+	update := map[string]any{"$set": map[string]any{"embargo_until": embargo.Until}}
+	if embargo.Until == 0 {
+		update = map[string]any{"$unset": map[string]any{"embargo_until": ""}}
+	}
+	if err = stores.Docs.Update(spec, update); err != nil {
+		return fmt.Errorf("[SpecScansService.main.ApplyEmbargo] stores.Docs.Update error: %w", err)
+	}
+	return nil
+}
+
+// Get the embargoes in the given stores of the given BTRs (of all BTRs if
+// none are given) in effect at the given time: embargoes of BTRs, then of
+// single scans
+func ActiveEmbargoes(stores ScanStores, btrs []string, at float64) ([]Embargo, error) {
+	embargoes, err := stores.Embargoes.GetEmbargoes(btrs, at)
+	if err != nil {
+		return embargoes, fmt.Errorf("[SpecScansService.main.ActiveEmbargoes] stores.Embargoes.GetEmbargoes error: %w", err)
+	}
+	spec := map[string]any{"embargo_until": map[string]any{"$gt": at}}
+	if len(btrs) > 0 {
+		spec["btr"] = map[string]any{"$in": anyList(btrs)}
+	}
+	records, err := getMongoRecords(stores.Docs, spec, 0, 0)
+	if err != nil {
+		return embargoes, fmt.Errorf("[SpecScansService.main.ActiveEmbargoes] getMongoRecords error: %w", err)
+	}
+	for _, record := range records {
+		embargoes = append(embargoes, Embargo{Btr: record.Btr, ScanId: record.ScanId, Until: record.EmbargoUntil})
+	}
+	return embargoes, nil
+}
+
+// Return embargoes as the records of a service response
+func embargoRecords(embargoes []Embargo) []map[string]any {
+	records := []map[string]any{}
+	for _, embargo := range embargoes {
+		record := map[string]any{"btr": embargo.Btr, "until": embargo.Until}
+		if embargo.ScanId != "" {
+			record["sid"] = embargo.ScanId
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Handler for getting the embargoes in effect, of the BTRs given by "btr" URL
+// parameters or of all BTRs
+func EmbargoesHandler(c *gin.Context) {
+	if !requireStaff(c) {
+		return
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	embargoes, err := ActiveEmbargoes(stores, c.QueryArray("btr"), epochTime(time.Now()))
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	records := embargoRecords(embargoes)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(records),
+			Records:  records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for setting or lifting the embargo of a BTR or a scan
+func EmbargoHandler(c *gin.Context) {
+	if !requireStaff(c) {
+		return
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	defer c.Request.Body.Close()
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("ReadAll error: %v", err)
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ReaderError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var embargo Embargo
+	err = json.Unmarshal(body, &embargo)
+	if err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.UnmarshalError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	err = ApplyEmbargo(stores, embargo)
+	if err != nil {
+		code, srvcode := http.StatusInternalServerError, services.UpdateError
+		if errors.Is(err, ErrScanNotFound) {
+			code, srvcode = http.StatusNotFound, services.NotFoundError
+		} else if errors.Is(err, ErrInvalidEmbargo) {
+			code, srvcode = http.StatusBadRequest, services.ValidateError
+		}
+		resp := services.Response("SpecScans", code, srvcode, err)
+		c.JSON(code, resp)
+		return
+	}
+	log.Printf("Embargo of %s%s set until %v by %s", embargo.Btr, embargo.ScanId, embargo.Until, requestIdentity(c).User)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: 1,
+			Records:  embargoRecords([]Embargo{embargo}),
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
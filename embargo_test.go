@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test storing BTR embargoes in the motors database
+func TestSetEmbargo(t *testing.T) {
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	for _, embargo := range []Embargo{{Btr: "a", Until: 100}, {Btr: "b", Until: 200}, {Btr: "a", Until: 300}} {
+		if err := SetEmbargo(embargo, db, SQLiteDialect); err != nil {
+			t.Fatalf("SetEmbargo error: %v", err)
+		}
+	}
+	embargoes, err := GetEmbargoes(nil, 250, db, SQLiteDialect)
+	if err != nil || len(embargoes) != 1 || embargoes[0] != (Embargo{Btr: "a", Until: 300}) {
+		t.Errorf("GetEmbargoes returned %+v, %v", embargoes, err)
+	}
+	if err = SetEmbargo(Embargo{Btr: "a"}, db, SQLiteDialect); err != nil {
+		t.Fatalf("SetEmbargo error: %v", err)
+	}
+	embargoes, err = GetEmbargoes([]string{"a", "b"}, 0, db, SQLiteDialect)
+	if err != nil || len(embargoes) != 1 || embargoes[0].Btr != "b" {
+		t.Errorf("GetEmbargoes after lifting an embargo returned %+v, %v", embargoes, err)
+	}
+}
+
+// Test that embargoed scans are hidden from staff until their embargo ends
+func TestEmbargo(t *testing.T) {
+	r := SetupTestService(t)
+
+	own := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	other := testUserRecord(2, 1709647260, map[string]float64{"samx": 1})
+	other.DatasetId = "/beamline=3a/btr=other-456-b/cycle=2024-1/sample_name=sample"
+	other.Btr = "other-456-b"
+	other.EmbargoUntil = 1
+	response := serveTestRequest(t, r, "POST", "/add", other)
+	if response.HttpCode != http.StatusUnprocessableEntity {
+		t.Errorf("adding a record with an embargo returned %+v", response)
+	}
+	other.EmbargoUntil = 0
+	for _, record := range []UserRecord{own, other} {
+		if response := serveTestRequest(t, r, "POST", "/add", record); response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+	}
+	sid := searchTestService(t, r, `{"btr": "test-123-a"}`)[0]["sid"].(string)
+
+	user := testToken(t, authz.CustomClaims{User: "user", Scope: "read write", Btrs: []string{"other-456-b"}})
+	staff := testToken(t, authz.CustomClaims{User: "staff", Scope: "read write " + StaffScope})
+	search := func(token string) int {
+		request := services.ServiceRequest{ServiceQuery: services.ServiceQuery{Query: "{}"}}
+		response := serveTokenRequest(t, r, "POST", "/search", token, request)
+		if response.HttpCode != http.StatusOK {
+			t.Fatalf("search failed: %+v", response)
+		}
+		return len(response.Results.Records)
+	}
+
+	future := epochTime(time.Now().Add(time.Hour))
+	response = serveTokenRequest(t, r, "PUT", "/embargo", user, Embargo{Btr: "other-456-b", Until: future})
+	if response.HttpCode != http.StatusForbidden {
+		t.Errorf("setting an embargo without the staff scope returned %+v", response)
+	}
+	for _, embargo := range []Embargo{{Btr: "other-456-b", Until: future}, {ScanId: sid, Until: future}} {
+		response = serveTokenRequest(t, r, "PUT", "/embargo", staff, embargo)
+		if response.SrvCode != services.OK {
+			t.Fatalf("setting embargo %+v failed: %+v", embargo, response)
+		}
+	}
+	response = serveTokenRequest(t, r, "PUT", "/embargo", staff, Embargo{Btr: "other-456-b", ScanId: sid})
+	if response.HttpCode != http.StatusBadRequest {
+		t.Errorf("setting an embargo of both a btr and a sid returned %+v", response)
+	}
+	response = serveTokenRequest(t, r, "GET", "/embargo", staff, nil)
+	if response.Results.NRecords != 2 {
+		t.Errorf("listing embargoes returned %+v", response)
+	}
+
+	if n := search(staff); n != 0 {
+		t.Errorf("staff found %d records under embargo; want 0", n)
+	}
+	// Members of an embargoed BTR still see its scans
+	if n := search(user); n != 1 {
+		t.Errorf("member of an embargoed BTR found %d records; want 1", n)
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sid, "embargo_until": 0})
+	if response.SrvCode == services.OK {
+		t.Errorf("editing embargo_until was accepted: %+v", response)
+	}
+
+	// Embargoes end by themselves when their time passes
+	past := epochTime(time.Now().Add(-time.Hour))
+	response = serveTokenRequest(t, r, "PUT", "/embargo", staff, Embargo{Btr: "other-456-b", Until: past})
+	if response.SrvCode != services.OK {
+		t.Fatalf("setting an ended embargo failed: %+v", response)
+	}
+	if n := search(staff); n != 1 {
+		t.Errorf("staff found %d records after an embargo ended; want 1", n)
+	}
+	response = serveTokenRequest(t, r, "GET", "/embargo", staff, nil)
+	if response.Results.NRecords != 1 || response.Results.Records[0]["sid"] != sid {
+		t.Errorf("listing embargoes after one ended returned %+v", response)
+	}
+}
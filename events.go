@@ -0,0 +1,281 @@
+package main
+
+// events module
+//
+// Adding, editing, deleting a record and changing its status publish a scan
+// event, which is delivered to webhooks (see webhooks.go) and pushed to
+// subscriptions of callers who may read the record. Clients subscribe to
+// the events of the records matching a query, in the syntax of POST /search,
+// on GET /subscribe, which pushes them as Server-Sent Events: beamline
+// dashboards see new scans as they land instead of polling /search.
+//
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Types of scan events
+const (
+	ScanAdded   = "add"
+	ScanEdited  = "edit"
+	ScanDeleted = "delete"
+	ScanStatus  = "status"
+)
+
+// ScanEvent is a change of a scan record, with the record as changed
+type ScanEvent struct {
+	Event   string     `json:"event"`
+	Time    float64    `json:"time"`
+	Sandbox bool       `json:"sandbox,omitempty"`
+	Record  UserRecord `json:"record"`
+}
+
+// ScanFilter matches scan records against a search query
+type ScanFilter struct {
+	Query     string
+	spec      map[string]any
+	positions []MotorPositionQuery
+}
+
+// Return the filter of a search query, in the syntax of POST /search, on the
+// records of the given stores
+func NewScanFilter(stores ScanStores, query string) (*ScanFilter, error) {
+	queries, err := searchQueries(stores, query)
+	if err != nil {
+		return nil, err
+	}
+	filter := &ScanFilter{Query: query, spec: queries["mongo"]}
+	if queries["sql"] != nil {
+		// Motors are resolved within the beamlines the query is restricted
+		// to, as by searches
+		motorsdb_query := translateQuery(queries["sql"], queryBeamlines(queries["mongo"]))
+		err = expandMotorAliases(stores.Motors, &motorsdb_query)
+		if err != nil {
+			return nil, fmt.Errorf("[SpecScansService.main.NewScanFilter] expandMotorAliases error: %w", err)
+		}
+		filter.positions = motorsdb_query.MotorPositionQueries
+	}
+	return filter, nil
+}
+
+// Check whether a record matches the filter's query
+func (f *ScanFilter) Match(record UserRecord) (bool, error) {
+	if f.positions != nil && !matchPositionQueries(record.Motors, record.Beamline, f.positions) {
+		return false, nil
+	}
+	if len(f.spec) == 0 {
+		return true, nil
+	}
+	var document map[string]any
+	err := normalizeDocument(record, &document)
+	if err != nil {
+		return false, fmt.Errorf("[SpecScansService.main.ScanFilter.Match] normalizeDocument error: %w", err)
+	}
+	return matchDocument(document, f.spec)
+}
+
+// Number of events a subscription holds for its subscriber; a subscriber
+// falling further behind is dropped
+const SubscriptionBuffer = 256
+
+// Interval of the comments keeping idle subscription streams open
+var SubscriptionHeartbeat = 30 * time.Second
+
+// Subscription is the subscription of a caller with the given access to the
+// scan events of the records matching a filter, of production or of the
+// sandbox
+type Subscription struct {
+	Events  chan ScanEvent
+	filter  *ScanFilter
+	access  RecordAccess
+	sandbox bool
+}
+
+// ScanSubscriptions are the subscriptions to scan events
+type ScanSubscriptions struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// Subscriptions to the scan events of the service
+var Subscriptions = &ScanSubscriptions{subscriptions: make(map[*Subscription]struct{})}
+
+// Subscribe a caller with the given access to the scan events of the records
+// matching a filter, of the sandbox or of production
+func (s *ScanSubscriptions) Subscribe(filter *ScanFilter, access RecordAccess, sandbox bool) *Subscription {
+	subscription := &Subscription{
+		Events:  make(chan ScanEvent, SubscriptionBuffer),
+		filter:  filter,
+		access:  access,
+		sandbox: sandbox,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[subscription] = struct{}{}
+	return subscription
+}
+
+// End a subscription, closing its events channel
+func (s *ScanSubscriptions) Unsubscribe(subscription *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[subscription]; ok {
+		delete(s.subscriptions, subscription)
+		close(subscription.Events)
+	}
+}
+
+// Return the number of subscriptions
+func (s *ScanSubscriptions) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscriptions)
+}
+
+// Push an event of a record in the given stores to the subscriptions to it.
+// Embargoes are those at the time of the event, not at the time of
+// subscribing, so staff stop receiving the scans of BTRs put under embargo
+// and receive those whose embargo ended. The subscriptions are filtered
+// without holding their lock, which is only taken to list them and to push
+// the event, so slow store reads do not block other publishers and
+// subscribers.
+func (s *ScanSubscriptions) Publish(stores ScanStores, event ScanEvent) {
+	s.mu.Lock()
+	var candidates []*Subscription
+	for subscription := range s.subscriptions {
+		if subscription.sandbox == event.Sandbox {
+			candidates = append(candidates, subscription)
+		}
+	}
+	s.mu.Unlock()
+
+	now := epochTime(time.Now())
+	var embargoed []string
+	var embargo_err error
+	embargoes_read := false
+	var matches []*Subscription
+	for _, subscription := range candidates {
+		access := subscription.access
+		if access.Staff {
+			if !embargoes_read {
+				embargoed, embargo_err = embargoedBtrs(stores, now)
+				embargoes_read = true
+			}
+			if embargo_err != nil {
+				log.Printf("Error getting embargoes for subscription %s: %v", subscription.filter.Query, embargo_err)
+				continue
+			}
+			access.Embargoed = embargoed
+		}
+		access.Now = now
+		// the record of a delete event has just been deleted, and may be
+		// read by those who could read it before
+		access.IncludeDeleted = access.IncludeDeleted || event.Event == ScanDeleted
+		if !access.Allows(event.Record) {
+			continue
+		}
+		match, err := subscription.filter.Match(event.Record)
+		if err != nil {
+			log.Printf("Error matching record %s against subscription %s: %v", event.Record.ScanId, subscription.filter.Query, err)
+			continue
+		}
+		if match {
+			matches = append(matches, subscription)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, subscription := range matches {
+		// the subscription may have ended while it was filtered
+		if _, ok := s.subscriptions[subscription]; !ok {
+			continue
+		}
+		select {
+		case subscription.Events <- event:
+		default:
+			log.Printf("Dropping subscription %s which fell %d events behind", subscription.filter.Query, SubscriptionBuffer)
+			delete(s.subscriptions, subscription)
+			close(subscription.Events)
+		}
+	}
+}
+
+// Publish an event of the record with the given sid in the given stores to
+// subscriptions and webhooks, if there are any
+func publishScanEvent(stores ScanStores, event string, sid string) {
+	hooks := eventWebhooks(event, stores.Sandbox)
+	if Subscriptions.Len() == 0 && len(hooks) == 0 {
+		return
+	}
+	mongo_records, err := getMongoRecords(stores.Docs, map[string]any{"sid": sid}, 0, 0)
+	if err != nil {
+		log.Printf("Error getting record %s for its %s event: %v", sid, event, err)
+		return
+	}
+	records, err := CompleteMongoRecords(stores.Motors, mongo_records...)
+	if err != nil || len(records) != 1 {
+		log.Printf("Error getting record %s for its %s event: %v", sid, event, err)
+		return
+	}
+	scan_event := ScanEvent{Event: event, Time: epochTime(time.Now()), Sandbox: stores.Sandbox, Record: records[0]}
+	Subscriptions.Publish(stores, scan_event)
+	deliverWebhooks(stores, hooks, scan_event)
+}
+
+// Handler for subscriptions to the scan events of the records matching the
+// "query" URL parameter (all records if none), which streams the events as
+// Server-Sent Events named by their type until the client disconnects
+func SubscribeHandler(c *gin.Context) {
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	filter, err := NewScanFilter(stores, c.DefaultQuery("query", "{}"))
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParseError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	subscription := Subscriptions.Subscribe(filter, access, stores.Sandbox)
+	defer Subscriptions.Unsubscribe(subscription)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("subscribed", gin.H{"query": filter.Query, "sandbox": stores.Sandbox})
+	c.Writer.Flush()
+	heartbeat := time.NewTicker(SubscriptionHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Event, event)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			return true
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Helper to return the name and data of the next Server-Sent Event read from
+// a stream
+func readTestEvent(t *testing.T, events chan [2]string) (string, string) {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("event stream ended")
+		}
+		return event[0], event[1]
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return "", ""
+}
+
+// Test pushing the events of matching scans to subscribers
+func TestSubscriptions(t *testing.T) {
+	r := SetupTestService(t)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	query := url.Values{"query": {`{"scan_number": 2}`}}
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/subscribe?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, authz.CustomClaims{User: "user", Scope: "read", Btrs: []string{"test-123-a"}}))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("subscription responded with %s", resp.Header.Get("Content-Type"))
+	}
+	events := make(chan [2]string, 16)
+	go func() {
+		defer close(events)
+		var name string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if value, ok := strings.CutPrefix(line, "event:"); ok {
+				name = value
+			} else if value, ok := strings.CutPrefix(line, "data:"); ok {
+				events <- [2]string{name, value}
+			}
+		}
+	}()
+	if name, _ := readTestEvent(t, events); name != "subscribed" {
+		t.Fatalf("first event is %s; want subscribed", name)
+	}
+
+	var sids []string
+	for i := 1; i <= 2; i++ {
+		response := serveTestRequest(t, r, "POST", "/add", testUserRecord(uint16(i), float64(1709647200+i), map[string]float64{"samx": float64(i)}))
+		if response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+		sids = append(sids, response.Results.Records[0]["sid"].(string))
+	}
+	for _, sid := range sids {
+		response := serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sid, "command": "dscan samx 0 1 10 1"})
+		if response.SrvCode != services.OK {
+			t.Fatalf("editing a record failed: %+v", response)
+		}
+	}
+
+	expected := []string{ScanAdded, ScanEdited}
+	for _, expected_event := range expected {
+		name, data := readTestEvent(t, events)
+		var event ScanEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("event %s has data %s: %v", name, data, err)
+		}
+		if name != expected_event || event.Event != expected_event || event.Record.ScanId != sids[1] || event.Record.Motors["samx"] != 2 {
+			t.Errorf("event is %s %+v; want %s of %s", name, event, expected_event, sids[1])
+		}
+		if expected_event == ScanEdited && event.Record.Command != "dscan samx 0 1 10 1" {
+			t.Errorf("edit event has record %+v", event.Record)
+		}
+	}
+
+	// Events are only pushed to the subscriptions of callers who may read
+	// the record, of the same namespace
+	stores := ProductionStores()
+	filter, err := NewScanFilter(stores, `{"motors.samx": {"$gt": 1.5}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := Subscriptions.Subscribe(filter, RecordAccess{Btrs: []string{"other-1"}}, false)
+	defer Subscriptions.Unsubscribe(other)
+	sandbox := Subscriptions.Subscribe(filter, RecordAccess{All: true}, true)
+	defer Subscriptions.Unsubscribe(sandbox)
+	admin := Subscriptions.Subscribe(filter, RecordAccess{All: true}, false)
+	defer Subscriptions.Unsubscribe(admin)
+	for _, sid := range sids {
+		publishScanEvent(stores, ScanEdited, sid)
+	}
+	if len(other.Events) != 0 || len(sandbox.Events) != 0 || len(admin.Events) != 1 {
+		t.Errorf("subscriptions got %d, %d and %d events; want 0, 0 and 1", len(other.Events), len(sandbox.Events), len(admin.Events))
+	}
+
+	// Embargoes are those at the time of each event
+	staff := Subscriptions.Subscribe(filter, RecordAccess{Staff: true, Now: 1}, false)
+	defer Subscriptions.Unsubscribe(staff)
+	for _, until := range []time.Time{time.Now().Add(time.Hour), time.Now().Add(-time.Hour)} {
+		if err := stores.Embargoes.SetEmbargo(Embargo{Btr: "test-123-a", Until: epochTime(until)}); err != nil {
+			t.Fatal(err)
+		}
+		publishScanEvent(stores, ScanEdited, sids[1])
+	}
+	if len(staff.Events) != 1 {
+		t.Errorf("staff subscription got %d events of a BTR under embargo and then not; want 1", len(staff.Events))
+	}
+
+	// Deletes are pushed to the subscriptions of callers who could read the
+	// record before it was deleted
+	reader := Subscriptions.Subscribe(filter, RecordAccess{Btrs: []string{"test-123-a"}}, false)
+	defer Subscriptions.Unsubscribe(reader)
+	if err := DeleteRecord(stores, RecordAccess{All: true}, Identity{User: "admin"}, sids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.Events) != 1 || len(other.Events) != 0 {
+		t.Fatalf("subscriptions got %d and %d delete events; want 1 and 0", len(reader.Events), len(other.Events))
+	}
+	if event := <-reader.Events; event.Event != ScanDeleted || event.Record.ScanId != sids[1] || event.Record.DeletedAt == 0 {
+		t.Errorf("delete event is %+v", event)
+	}
+
+	response := serveTestRequest(t, r, "GET", "/subscribe?query=%7B", nil)
+	if response.HttpCode != http.StatusBadRequest {
+		t.Errorf("subscription with an invalid query returned %+v", response)
+	}
+}
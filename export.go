@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Top-level columns of exported tables: the fields of UserRecord, in their
+// order, except for the flattened ones
+var TableColumns = recordColumns()
+
+// Return the JSON names of the fields of UserRecord which are not flattened
+func recordColumns() []string {
+	var columns []string
+	record_type := reflect.TypeOf(UserRecord{})
+	for i := 0; i < record_type.NumField(); i++ {
+		name, _, _ := strings.Cut(record_type.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" && !inList(name, FlattenedColumns) {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// Record fields which are flattened into one column per key
+var FlattenedColumns = []string{"motors", "motor_units", "variables"}
+
+// Supported table export formats and their field separators
+var TableFormats = map[string]rune{
+	"csv": ',',
+	"tsv": '\t',
+}
+
+// Content types of the table export formats
+var TableContentTypes = map[string]string{
+	"csv": "text/csv",
+	"tsv": "text/tab-separated-values",
+}
+
+// Write records as a flat table with one row per scan. Each key of the
+// flattened fields (e.g. each motor mnemonic) gets its own column, named like
+// "motors.samx"; cells of scans lacking that key are left empty.
+func WriteTable(w io.Writer, records []map[string]any, format string) error {
+	comma, ok := TableFormats[format]
+	if !ok {
+		return fmt.Errorf("[SpecScansService.main.WriteTable] unsupported table format %q", format)
+	}
+	columns := tableColumns(records)
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	err := writer.Write(columns)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.WriteTable] writer.Write error: %w", err)
+	}
+	for _, record := range records {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			val, ok := lookupColumn(record, column)
+			if !ok {
+				continue
+			}
+			row[i], err = formatCell(val)
+			if err != nil {
+				return fmt.Errorf("[SpecScansService.main.WriteTable] formatCell error: %w", err)
+			}
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.WriteTable] writer.Write error: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Return the full, stable list of columns for a set of records: the fixed
+// record columns followed by the sorted keys of each flattened field.
+func tableColumns(records []map[string]any) []string {
+	columns := append([]string{}, TableColumns...)
+	for _, field := range FlattenedColumns {
+		keys := make(map[string]bool)
+		for _, record := range records {
+			if values, ok := record[field].(map[string]any); ok {
+				for key := range values {
+					keys[key] = true
+				}
+			}
+		}
+		var sorted_keys []string
+		for key := range keys {
+			sorted_keys = append(sorted_keys, key)
+		}
+		sort.Strings(sorted_keys)
+		for _, key := range sorted_keys {
+			columns = append(columns, fmt.Sprintf("%s.%s", field, key))
+		}
+	}
+	return columns
+}
+
+func lookupColumn(record map[string]any, column string) (any, bool) {
+	for _, field := range FlattenedColumns {
+		if key, found := strings.CutPrefix(column, field+"."); found {
+			values, ok := record[field].(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			val, ok := values[key]
+			return val, ok
+		}
+	}
+	val, ok := record[column]
+	return val, ok
+}
+
+// Format a single table cell. Scalars are written as-is; lists and nested
+// values are written as JSON.
+func formatCell(val any) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test flattening of records into CSV and TSV tables
+func TestWriteTable(t *testing.T) {
+	records := []map[string]any{
+		{
+			"sid":         "1",
+			"scan_number": 1.0,
+			"start_time":  1709647200.5,
+			"comments":    []any{"first", "second"},
+			"motors":      map[string]any{"samz": 1.5, "samx": -2.0},
+			"variables":   map[string]any{"ring_current": 100.0},
+		},
+		{
+			"sid":         "2",
+			"scan_number": 2.0,
+			"motors":      map[string]any{"th": 0.25},
+		},
+	}
+	header := []string{"sid", "did", "cycle", "beamline", "btr", "spec_file", "scan_number", "start_time", "command", "status",
+		"end_time", "duration", "comments", "userlines", "spec_version", "status_history", "created_by", "created_at",
+		"updated_by", "updated_at", "embargo_until", "deleted_at", "deleted_by",
+		"motors.samx", "motors.samz", "motors.th", "variables.ring_current"}
+	rows := [][]string{
+		{"1", "", "", "", "", "", "1", "1709647200.5", "", "", "", "", `"[""first"",""second""]"`, "", "", "", "", "", "", "", "", "", "", "-2", "1.5", "", "100"},
+		{"2", "", "", "", "", "", "2", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "0.25", ""},
+	}
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{
+			format: "csv",
+			expected: []string{
+				strings.Join(header, ","),
+				strings.Join(rows[0], ","),
+				strings.Join(rows[1], ","),
+			},
+		},
+		{
+			format: "tsv",
+			expected: []string{
+				strings.Join(header, "\t"),
+				strings.Join(rows[0], "\t"),
+				strings.Join(rows[1], "\t"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteTable(&buf, records, tt.format)
+			if err != nil {
+				t.Fatalf("WriteTable(%s) error: %v", tt.format, err)
+			}
+			got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(got) != len(tt.expected) {
+				t.Fatalf("WriteTable(%s) wrote %d lines; want %d", tt.format, len(got), len(tt.expected))
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("WriteTable(%s) line %d = %q; want %q", tt.format, i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, records, "xlsx"); err == nil {
+		t.Error("WriteTable should fail for unsupported formats")
+	}
+}
+
+// Test that exported tables have a column for every field of scan records
+func TestTableColumns(t *testing.T) {
+	identity := &Identity{User: "user"}
+	record := UserRecord{
+		ScanId: "1", DatasetId: "did", Cycle: "2024-1", Beamline: "3a", Btr: "btr", SpecFile: "/data/a",
+		ScanNumber: 1, StartTime: 1, Command: "ascan", Status: StatusCompleted, EndTime: 2, Duration: 1,
+		Comments: []string{"c"}, Userlines: []string{"u"}, SpecVersion: "6", Motors: map[string]float64{"samx": 1},
+		Variables: map[string]any{"ring_current": 100.0}, StatusHistory: []StatusChange{{Status: StatusCompleted, Time: 2}},
+		CreatedBy: identity, CreatedAt: 1, UpdatedBy: identity, UpdatedAt: 2, EmbargoUntil: 3, DeletedAt: 4, DeletedBy: identity,
+	}
+	var document map[string]any
+	if err := normalizeDocument(record, &document); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, []map[string]any{document}, "csv"); err != nil {
+		t.Fatalf("WriteTable error: %v", err)
+	}
+	header, _, _ := strings.Cut(buf.String(), "\n")
+	columns := strings.Split(header, ",")
+	for field := range document {
+		if !inList(field, columns) && !inList(field, FlattenedColumns) {
+			t.Errorf("exported table has no %s column", field)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+// graphql module
+//
+// POST /graphql answers GraphQL queries over scans, their datasets and their
+// motor positions, so that a client can fetch e.g. a dataset, its scans and a
+// few of their motors in one round trip. Scans are read from the document
+// store with the caller's access rules. Motor positions (and units) are
+// looked up lazily, for all the scans at one level of a query together: one
+// call to GetMotorRecords serves every scan of a result, not one per scan.
+//
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+)
+
+// GraphQLRequest is the body of a GraphQL request
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Motor is the position of a motor in a scan
+type Motor struct {
+	Mne      string  `json:"mne"`
+	Position float64 `json:"position"`
+	beamline string
+}
+
+// Dataset is the dataset of the scans with a DID, with the components of
+// its DID
+type Dataset struct {
+	DatasetId  string `json:"did"`
+	Beamline   string `json:"beamline"`
+	Btr        string `json:"btr"`
+	Cycle      string `json:"cycle"`
+	SampleName string `json:"sample_name"`
+}
+
+// Return the dataset with the given DID
+func datasetOf(did string) *Dataset {
+	dataset := &Dataset{DatasetId: did}
+	components, err := ParseDID(did)
+	if err == nil {
+		dataset.Beamline = components["beamline"]
+		dataset.Btr = components["btr"]
+		dataset.Cycle = components["cycle"]
+		dataset.SampleName = components["sample_name"]
+	}
+	return dataset
+}
+
+// graphqlLoader reads the records of one GraphQL query, collecting the
+// lookups of motor positions and units of its scans into batches
+type graphqlLoader struct {
+	stores         ScanStores
+	access         RecordAccess
+	pending_sids   []string
+	positions      map[string]map[string]float64
+	positions_err  error
+	pending_motors map[string][]string
+	units          map[string]map[string]string
+	units_err      error
+}
+
+// Key of the loader of a GraphQL query in its context
+type graphqlLoaderKey struct{}
+
+// Return the loader of the GraphQL query a field is resolved for
+func loaderOf(p graphql.ResolveParams) *graphqlLoader {
+	return p.Context.Value(graphqlLoaderKey{}).(*graphqlLoader)
+}
+
+// Return a function returning the motor positions of a scan, which looks up
+// those of all the scans asked for until it is first called
+func (l *graphqlLoader) motorPositions(sid string) func() (map[string]float64, error) {
+	if _, ok := l.positions[sid]; !ok {
+		l.pending_sids = append(l.pending_sids, sid)
+	}
+	return func() (map[string]float64, error) {
+		if len(l.pending_sids) > 0 {
+			sids := l.pending_sids
+			l.pending_sids = nil
+			motor_records, err := GetMotorRecords(l.stores.Motors, sids...)
+			if err != nil {
+				l.positions_err = fmt.Errorf("[SpecScansService.main.motorPositions] GetMotorRecords error: %w", err)
+			}
+			for _, sid := range sids {
+				l.positions[sid] = nil
+			}
+			for _, motor_record := range motor_records {
+				l.positions[motor_record.ScanId] = motor_record.Motors
+			}
+		}
+		return l.positions[sid], l.positions_err
+	}
+}
+
+// Return a function returning the units of a motor of a beamline, which
+// looks up those of all the motors asked for until it is first called
+func (l *graphqlLoader) motorUnits(beamline string, mne string) func() (string, error) {
+	if _, ok := l.units[beamline][mne]; !ok && !inList(mne, l.pending_motors[beamline]) {
+		l.pending_motors[beamline] = append(l.pending_motors[beamline], mne)
+	}
+	return func() (string, error) {
+		if len(l.pending_motors) > 0 {
+			var records []UserRecord
+			for beamline, mnes := range l.pending_motors {
+				record := UserRecord{Beamline: beamline, Motors: make(map[string]float64)}
+				for _, mne := range mnes {
+					record.Motors[mne] = 0
+				}
+				records = append(records, record)
+			}
+			l.pending_motors = make(map[string][]string)
+			units, err := motorUnits(l.stores.Motors, records)
+			if err != nil {
+				l.units_err = err
+			}
+			for beamline, beamline_units := range units {
+				if l.units[beamline] == nil {
+					l.units[beamline] = make(map[string]string)
+				}
+				for mne, unit := range beamline_units {
+					l.units[beamline][mne] = unit
+				}
+			}
+		}
+		return l.units[beamline][mne], l.units_err
+	}
+}
+
+// Return the scans of the records in the document store matching a query
+// the caller may read, without their motor positions
+func (l *graphqlLoader) scans(spec map[string]any, idx int, limit int) ([]*UserRecord, error) {
+	mongo_records, err := getMongoRecords(l.stores.Docs, l.access.Restrict(spec), idx, limit)
+	if err != nil {
+		return nil, err
+	}
+	scans := []*UserRecord{}
+	for _, mongo_record := range mongo_records {
+		scan := CompleteRecord(mongo_record, MotorRecord{})
+		scans = append(scans, &scan)
+	}
+	return scans, nil
+}
+
+// Return the motors of a scan with the given positions, in the order of the
+// given names, or of their names if none are given
+func scanMotors(scan *UserRecord, positions map[string]float64, mnes []string) []Motor {
+	if len(mnes) == 0 {
+		for mne := range positions {
+			mnes = append(mnes, mne)
+		}
+		sort.Strings(mnes)
+	}
+	motors := []Motor{}
+	for _, mne := range mnes {
+		if position, ok := positions[mne]; ok {
+			motors = append(motors, Motor{Mne: mne, Position: position, beamline: scan.Beamline})
+		}
+	}
+	return motors
+}
+
+// Return the values of a list argument of a GraphQL field
+func stringsArg(p graphql.ResolveParams, name string) []string {
+	var values []string
+	list, _ := p.Args[name].([]any)
+	for _, value := range list {
+		if s, ok := value.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// Return the value of an integer argument of a GraphQL field
+func intArg(p graphql.ResolveParams, name string) int {
+	value, _ := p.Args[name].(int)
+	return value
+}
+
+// JSON scalar, of variables of scans
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value",
+	Serialize:   func(value any) any { return value },
+})
+
+// Return the GraphQL schema of scans, datasets and motors
+func newGraphQLSchema() (graphql.Schema, error) {
+	identityType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Identity",
+		Description: "User who made a change to a scan, and the client their token was issued to",
+		Fields: graphql.Fields{
+			"user":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"client": &graphql.Field{Type: graphql.String},
+		},
+	})
+	statusChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "StatusChange",
+		Description: "Transition of a scan to a status",
+		Fields: graphql.Fields{
+			"status": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"time":   &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+	motorType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Motor",
+		Description: "Position of a motor in a scan",
+		Fields: graphql.Fields{
+			"mne":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"position": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"units": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Units of the motor in the motor catalog",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					motor := p.Source.(Motor)
+					units := loaderOf(p).motorUnits(motor.beamline, motor.Mne)
+					return func() (any, error) {
+						unit, err := units()
+						if unit == "" {
+							return nil, err
+						}
+						return unit, err
+					}, nil
+				},
+			},
+		},
+	})
+	var datasetType *graphql.Object
+	scanType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Scan",
+		Description: "Scan record",
+		Fields: (graphql.FieldsThunk)(func() graphql.Fields {
+			fields := graphql.Fields{
+				"sid":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"did":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"cycle":          &graphql.Field{Type: graphql.String},
+				"beamline":       &graphql.Field{Type: graphql.String},
+				"btr":            &graphql.Field{Type: graphql.String},
+				"spec_file":      &graphql.Field{Type: graphql.String},
+				"scan_number":    &graphql.Field{Type: graphql.Int},
+				"start_time":     &graphql.Field{Type: graphql.Float},
+				"command":        &graphql.Field{Type: graphql.String},
+				"status":         &graphql.Field{Type: graphql.String},
+				"end_time":       &graphql.Field{Type: graphql.Float},
+				"duration":       &graphql.Field{Type: graphql.Float},
+				"comments":       &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"userlines":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"spec_version":   &graphql.Field{Type: graphql.String},
+				"status_history": &graphql.Field{Type: graphql.NewList(statusChangeType)},
+				"created_by":     &graphql.Field{Type: identityType},
+				"created_at":     &graphql.Field{Type: graphql.Float},
+				"updated_by":     &graphql.Field{Type: identityType},
+				"updated_at":     &graphql.Field{Type: graphql.Float},
+				"embargo_until":  &graphql.Field{Type: graphql.Float},
+				"deleted_at":     &graphql.Field{Type: graphql.Float},
+				"deleted_by":     &graphql.Field{Type: identityType},
+				"variables": &graphql.Field{
+					Type: jsonScalar,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						// Variables may hold values of the document store's types
+						var variables map[string]any
+						err := normalizeDocument(p.Source.(*UserRecord).Variables, &variables)
+						return variables, err
+					},
+				},
+				"motors": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(motorType))),
+					Description: "Positions of the motors of the scan, of the given motors or of all",
+					Args: graphql.FieldConfigArgument{
+						"mne": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					},
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						scan := p.Source.(*UserRecord)
+						mnes := stringsArg(p, "mne")
+						if scan.Motors != nil {
+							return scanMotors(scan, scan.Motors, mnes), nil
+						}
+						positions := loaderOf(p).motorPositions(scan.ScanId)
+						return func() (any, error) {
+							motors, err := positions()
+							return scanMotors(scan, motors, mnes), err
+						}, nil
+					},
+				},
+				"dataset": &graphql.Field{
+					Type: graphql.NewNonNull(datasetType),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return datasetOf(p.Source.(*UserRecord).DatasetId), nil
+					},
+				},
+			}
+			return fields
+		}),
+	})
+	pageArgs := graphql.FieldConfigArgument{
+		"idx":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0, Description: "index of the first scan"},
+		"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0, Description: "maximum number of scans (all if 0)"},
+	}
+	datasetType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Dataset",
+		Description: "Dataset of the scans with a DID",
+		Fields: graphql.Fields{
+			"did":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"beamline":    &graphql.Field{Type: graphql.String},
+			"btr":         &graphql.Field{Type: graphql.String},
+			"cycle":       &graphql.Field{Type: graphql.String},
+			"sample_name": &graphql.Field{Type: graphql.String},
+			"scans": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(scanType))),
+				Description: "Scans of the dataset",
+				Args:        pageArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					dataset := p.Source.(*Dataset)
+					return loaderOf(p).scans(map[string]any{"did": dataset.DatasetId}, intArg(p, "idx"), intArg(p, "limit"))
+				},
+			},
+			"scan_count": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Number of scans of the dataset",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					loader := loaderOf(p)
+					return loader.stores.Docs.Count(loader.access.Restrict(map[string]any{"did": p.Source.(*Dataset).DatasetId}))
+				},
+			},
+		},
+	})
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"scans": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(scanType))),
+				Description: "Scans matching a query, in the syntax of POST /search",
+				Args: graphql.FieldConfigArgument{
+					"query":           &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "{}"},
+					"idx":             pageArgs["idx"],
+					"limit":           pageArgs["limit"],
+					"include_deleted": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					loader := loaderOf(p)
+					access := loader.access
+					access.IncludeDeleted, _ = p.Args["include_deleted"].(bool)
+					query, _ := p.Args["query"].(string)
+					service_query := services.ServiceQuery{Query: query, Idx: intArg(p, "idx"), Limit: intArg(p, "limit")}
+					records, err := SearchRecords(loader.stores, access, service_query)
+					if err != nil {
+						return nil, err
+					}
+					scans := []*UserRecord{}
+					for i := range records {
+						scans = append(scans, &records[i])
+					}
+					return scans, nil
+				},
+			},
+			"scan": &graphql.Field{
+				Type:        scanType,
+				Description: "Scan with a scan id",
+				Args: graphql.FieldConfigArgument{
+					"sid": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					scans, err := loaderOf(p).scans(map[string]any{"sid": p.Args["sid"]}, 0, 1)
+					if err != nil || len(scans) == 0 {
+						return nil, err
+					}
+					return scans[0], nil
+				},
+			},
+			"dataset": &graphql.Field{
+				Type:        datasetType,
+				Description: "Dataset with a DID, if it has scans the caller may read",
+				Args: graphql.FieldConfigArgument{
+					"did": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					loader := loaderOf(p)
+					did, _ := p.Args["did"].(string)
+					if _, err := ParseDID(did); err != nil {
+						return nil, err
+					}
+					count, err := loader.stores.Docs.Count(loader.access.Restrict(map[string]any{"did": did}))
+					if err != nil || count == 0 {
+						return nil, err
+					}
+					return datasetOf(did), nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
+
+var graphQLSchema graphql.Schema
+var graphQLSchemaErr error
+var graphQLSchemaOnce sync.Once
+
+// Return the GraphQL schema of the service
+func GraphQLSchema() (graphql.Schema, error) {
+	graphQLSchemaOnce.Do(func() {
+		graphQLSchema, graphQLSchemaErr = newGraphQLSchema()
+	})
+	return graphQLSchema, graphQLSchemaErr
+}
+
+// Handler for GraphQL queries; responds with the GraphQL result (data and
+// errors) of the query
+func GraphQLHandler(c *gin.Context) {
+	var request GraphQLRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParseError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	schema, err := GraphQLSchema()
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	loader := &graphqlLoader{
+		stores:         stores,
+		access:         access,
+		positions:      make(map[string]map[string]float64),
+		pending_motors: make(map[string][]string),
+		units:          make(map[string]map[string]string),
+	}
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  request.Query,
+		OperationName:  request.OperationName,
+		VariableValues: request.Variables,
+		Context:        context.WithValue(c.Request.Context(), graphqlLoaderKey{}, loader),
+	})
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// countingMotorStore is a motor store counting the queries of motor positions
+type countingMotorStore struct {
+	MotorStore
+	queries int
+}
+
+func (s *countingMotorStore) QueryMotors(query MotorsDbQuery) ([]MotorRecord, error) {
+	s.queries++
+	return s.MotorStore.QueryMotors(query)
+}
+
+// graphqlTestResult is the result of a GraphQL query
+type graphqlTestResult struct {
+	Data   map[string]any   `json:"data"`
+	Errors []map[string]any `json:"errors"`
+}
+
+// Helper to send a GraphQL query with the given token to the test router
+func graphqlTestRequest(t *testing.T, r *gin.Engine, token string, query string, variables map[string]any) graphqlTestResult {
+	data, err := json.Marshal(GraphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/graphql", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var result graphqlTestResult
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to unmarshal GraphQL result %s: %v", w.Body.String(), err)
+	}
+	return result
+}
+
+// Test querying a dataset, its scans and their motors with GraphQL
+func TestGraphQL(t *testing.T) {
+	r := SetupTestService(t)
+	var sids []string
+	for i := 1; i <= 3; i++ {
+		record := testUserRecord(uint16(i), float64(1709647200+i), map[string]float64{"samx": float64(i), "samy": -float64(i)})
+		response := serveTestRequest(t, r, "POST", "/add", record)
+		if response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+		sids = append(sids, response.Results.Records[0]["sid"].(string))
+	}
+	response := serveTestRequest(t, r, "PUT", "/motors", []MotorInfo{{Mne: "samx", Units: "mm", Beamline: "3a"}})
+	if response.SrvCode != services.OK {
+		t.Fatalf("editing the motor catalog failed: %+v", response)
+	}
+	motors := &countingMotorStore{MotorStore: ScanMotors}
+	ScanMotors = motors
+	staff := testToken(t, authz.CustomClaims{User: "staff", Scope: "read " + StaffScope})
+	did := "/beamline=3a/btr=test-123-a/cycle=2024-1/sample_name=sample"
+
+	query := `query ($did: String!) {
+		dataset(did: $did) {
+			did btr sample_name scan_count
+			scans(limit: 2) { sid scan_number motors(mne: ["samx"]) { mne position units } }
+		}
+	}`
+	result := graphqlTestRequest(t, r, staff, query, map[string]any{"did": did})
+	if len(result.Errors) > 0 {
+		t.Fatalf("dataset query failed: %+v", result.Errors)
+	}
+	dataset, _ := result.Data["dataset"].(map[string]any)
+	if dataset["did"] != did || dataset["btr"] != "test-123-a" || dataset["sample_name"] != "sample" || dataset["scan_count"] != 3.0 {
+		t.Errorf("dataset is %+v", dataset)
+	}
+	scans, _ := dataset["scans"].([]any)
+	if len(scans) != 2 {
+		t.Fatalf("dataset has %d scans; want 2: %+v", len(scans), dataset)
+	}
+	for i, scan := range scans {
+		scan := scan.(map[string]any)
+		scan_motors, _ := scan["motors"].([]any)
+		if scan["sid"] != sids[i] || len(scan_motors) != 1 {
+			t.Fatalf("scan %d is %+v", i, scan)
+		}
+		motor := scan_motors[0].(map[string]any)
+		if motor["mne"] != "samx" || motor["position"] != float64(i+1) || motor["units"] != "mm" {
+			t.Errorf("motor of scan %d is %+v", i, motor)
+		}
+	}
+	if motors.queries != 1 {
+		t.Errorf("motor positions of the dataset's scans were queried %d times; want 1", motors.queries)
+	}
+
+	motors.queries = 0
+	result = graphqlTestRequest(t, r, staff, `{ scans(query: "{\"scan_number\": 3}") { sid motors { mne } dataset { did } } }`, nil)
+	scans, _ = result.Data["scans"].([]any)
+	if len(result.Errors) > 0 || len(scans) != 1 {
+		t.Fatalf("scans query returned %+v", result)
+	}
+	scan := scans[0].(map[string]any)
+	if scan["sid"] != sids[2] || len(scan["motors"].([]any)) != 2 || scan["dataset"].(map[string]any)["did"] != did {
+		t.Errorf("scan is %+v", scan)
+	}
+	if motors.queries != 1 {
+		t.Errorf("motor positions of searched scans were queried %d times; want 1", motors.queries)
+	}
+
+	result = graphqlTestRequest(t, r, staff, `query ($sid: String!) { scan(sid: $sid) { command variables motors { mne position units } } }`, map[string]any{"sid": sids[0]})
+	scan, _ = result.Data["scan"].(map[string]any)
+	if len(result.Errors) > 0 || scan["command"] != "ascan  samx 0 1 10 1" || scan["variables"].(map[string]any)["ring_current"] != 100.0 {
+		t.Fatalf("scan query returned %+v", result)
+	}
+	if motor := scan["motors"].([]any)[1].(map[string]any); motor["mne"] != "samy" || motor["position"] != -1.0 || motor["units"] != nil {
+		t.Errorf("motor samy of scan is %+v", motor)
+	}
+
+	other := testToken(t, authz.CustomClaims{User: "other", Scope: "read", Btrs: []string{"other-1"}})
+	result = graphqlTestRequest(t, r, other, query, map[string]any{"did": did})
+	if len(result.Errors) > 0 || result.Data["dataset"] != nil {
+		t.Errorf("dataset query by a user of another BTR returned %+v", result)
+	}
+	result = graphqlTestRequest(t, r, staff, `{ scans { nonexistent } }`, nil)
+	if len(result.Errors) == 0 {
+		t.Errorf("query of an unknown field returned %+v", result)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+// grpc module
+//
+// The gRPC interface (specscanspb/specscans.proto) serves high-rate clients
+// next to the HTTP API: AddScans streams records in, EditScan edits one and
+// SearchScans streams matching records out. Calls are authorized by FOXDEN
+// tokens like HTTP requests, and go through the same record validation,
+// storage, access rules and audit log as the HTTP handlers.
+//
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/CHESSComputing/SpecScansService/specscanspb"
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Port of the gRPC server; it is not started if the port is 0
+var GrpcPort int
+
+// Token scopes gRPC calls need, by method
+var grpcScopes = map[string]string{
+	pb.SpecScans_AddScans_FullMethodName:    "write",
+	pb.SpecScans_EditScan_FullMethodName:    "write",
+	pb.SpecScans_SearchScans_FullMethodName: "read",
+}
+
+// Methods of the gRPC service which change records, and are audited
+var grpcAuditedMethods = []string{pb.SpecScans_AddScans_FullMethodName, pb.SpecScans_EditScan_FullMethodName}
+
+// Key of the caller of a gRPC call in its context
+type grpcCallKey struct{}
+
+// grpcCall describes the caller of a gRPC call: the claims of their token,
+// whether the call uses the sandbox, and the audit trail of calls which may
+// change records
+type grpcCall struct {
+	claims  *authz.Claims
+	sandbox bool
+	trail   *AuditTrail
+	sids    []string
+}
+
+// Return the caller of a gRPC call
+func callerOf(ctx context.Context) *grpcCall {
+	return ctx.Value(grpcCallKey{}).(*grpcCall)
+}
+
+// Authorize a call to the given gRPC method, by the token in its
+// "authorization" metadata, and return its caller
+func authorizeCall(ctx context.Context, method string) (*grpcCall, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get("authorization"); len(values) > 0 {
+		token = values[0]
+		if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+			token = token[7:]
+		}
+	}
+	claims, err := tokenClaims(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	scope := grpcScopes[method]
+	if !strings.Contains(claims.CustomClaims.Scope, scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "token scope %q does not have the %s scope", claims.CustomClaims.Scope, scope)
+	}
+	call := &grpcCall{claims: claims, sandbox: isSandboxClaims(claims)}
+	if values := md.Get("sandbox"); len(values) > 0 {
+		sandbox, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid sandbox metadata %q", values[0])
+		}
+		call.sandbox = call.sandbox || sandbox
+	}
+	if inList(method, grpcAuditedMethods) {
+		call.trail = &AuditTrail{}
+	}
+	return call, nil
+}
+
+// Return the stores used by a gRPC call
+func (call *grpcCall) stores() (ScanStores, error) {
+	stores, err := scanStores(call.sandbox, call.trail)
+	if err != nil {
+		return stores, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return stores, nil
+}
+
+// Record a gRPC call which ended with the given error in the audit log
+func (call *grpcCall) audit(method string, at float64, err error) {
+	if call.trail == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:     at,
+		Actor:    claimsIdentity(call.claims),
+		Method:   "gRPC",
+		Endpoint: method,
+		Sandbox:  call.sandbox,
+		Changes:  call.trail.Changes(),
+		GrpcCode: status.Code(err).String(),
+	}
+	failures := call.trail.Failures()
+	switch {
+	case err != nil:
+		entry.Outcome = AuditFailure
+		entry.Error = status.Convert(err).Message()
+	case len(failures) > 0 && len(entry.Changes) > 0:
+		entry.Outcome = AuditPartial
+		entry.Error = strings.Join(failures, "; ")
+	case len(failures) > 0:
+		entry.Outcome = AuditFailure
+		entry.Error = strings.Join(failures, "; ")
+	default:
+		entry.Outcome = AuditSuccess
+	}
+	entry.setSids(call.sids)
+	recordAuditEntry(entry)
+}
+
+// Authorize and audit unary gRPC calls
+func grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	at := epochTime(time.Now())
+	call, err := authorizeCall(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handler(context.WithValue(ctx, grpcCallKey{}, call), req)
+	call.audit(info.FullMethod, at, err)
+	return resp, err
+}
+
+// grpcStream is a server stream with the context of its call
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+// Authorize and audit streaming gRPC calls
+func grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	at := epochTime(time.Now())
+	call, err := authorizeCall(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	err = handler(srv, grpcStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), grpcCallKey{}, call)})
+	call.audit(info.FullMethod, at, err)
+	return err
+}
+
+// specScansServer implements the SpecScans gRPC service
+type specScansServer struct {
+	pb.UnimplementedSpecScansServer
+}
+
+// Add the records streamed by the client, each on its own
+func (specScansServer) AddScans(stream pb.SpecScans_AddScansServer) error {
+	call := callerOf(stream.Context())
+	stores, err := call.stores()
+	if err != nil {
+		return err
+	}
+	by := claimsIdentity(call.claims)
+	response := &pb.AddScansResponse{}
+	for index := int32(0); ; index++ {
+		scan, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(response)
+		}
+		if err != nil {
+			return err
+		}
+		result := &pb.AddResult{Index: index}
+		record, err := scanRecord(scan)
+		if err == nil {
+			SetCreated(&record, by, epochTime(time.Now()))
+			var new_record map[string]any
+			new_record, err = AddRecord(stores, record)
+			if err == nil {
+				result.Sid, _ = new_record["sid"].(string)
+				response.Added++
+			}
+		}
+		if err != nil {
+			log.Printf("Error adding record: %s", err)
+			result.Error = err.Error()
+			response.Failed++
+			call.trail.RecordFailure(fmt.Errorf("record %d: %w", index, err))
+		}
+		response.Results = append(response.Results, result)
+	}
+}
+
+// Edit a record, identified by its sid or by its spec_file and scan_number
+func (specScansServer) EditScan(ctx context.Context, req *pb.EditScanRequest) (*pb.Scan, error) {
+	call := callerOf(ctx)
+	stores, err := call.stores()
+	if err != nil {
+		return nil, err
+	}
+	edit := map[string]any{}
+	if req.Fields != nil {
+		edit = req.Fields.AsMap()
+	}
+	if req.Sid != "" {
+		edit["sid"] = req.Sid
+		call.sids = append(call.sids, req.Sid)
+	} else if req.SpecFile != "" {
+		edit["spec_file"] = req.SpecFile
+		edit["scan_number"] = float64(req.ScanNumber)
+	}
+	access, err := claimsAccess(call.claims, stores)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	edited_record, err := EditRecord(stores, access, claimsIdentity(call.claims), edit)
+	if errors.Is(err, ErrScanNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if errors.Is(err, ErrIllegalTransition) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sid, _ := edited_record["sid"].(string)
+	mongo_records, err := getMongoRecords(stores.Docs, map[string]any{"sid": sid}, 0, 0)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	records, err := CompleteMongoRecords(stores.Motors, mongo_records...)
+	if err != nil || len(records) != 1 {
+		return nil, status.Errorf(codes.Internal, "unable to get edited record %s: %v", sid, err)
+	}
+	return scanMessage(records[0])
+}
+
+// Stream the records matching a search to the client
+func (specScansServer) SearchScans(req *pb.SearchScansRequest, stream pb.SpecScans_SearchScansServer) error {
+	call := callerOf(stream.Context())
+	stores, err := call.stores()
+	if err != nil {
+		return err
+	}
+	access, err := claimsAccess(call.claims, stores)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	access.IncludeDeleted = req.IncludeDeleted
+	query := services.ServiceQuery{Query: req.Query, Idx: int(req.Idx), Limit: int(req.Limit)}
+	if req.Spec != nil {
+		query.Spec = req.Spec.AsMap()
+	}
+	records, err := SearchRecords(stores, access, query)
+	if err != nil {
+		code := codes.Internal
+		var search_err *SearchError
+		if errors.As(err, &search_err) && search_err.SrvCode == services.ParseError {
+			code = codes.InvalidArgument
+		}
+		return status.Error(code, err.Error())
+	}
+	for _, record := range records {
+		scan, err := scanMessage(record)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(scan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the message of an identity
+func identityMessage(identity *Identity) *pb.Identity {
+	if identity == nil {
+		return nil
+	}
+	return &pb.Identity{User: identity.User, Client: identity.Client}
+}
+
+// Return the identity of a message
+func identityRecord(identity *pb.Identity) *Identity {
+	if identity == nil {
+		return nil
+	}
+	return &Identity{User: identity.User, Client: identity.Client}
+}
+
+// Return the message of a scan record
+func scanMessage(record UserRecord) (*pb.Scan, error) {
+	scan := &pb.Scan{
+		Sid:          record.ScanId,
+		Did:          record.DatasetId,
+		Cycle:        record.Cycle,
+		Beamline:     record.Beamline,
+		Btr:          record.Btr,
+		SpecFile:     record.SpecFile,
+		ScanNumber:   uint32(record.ScanNumber),
+		StartTime:    record.StartTime,
+		Command:      record.Command,
+		Status:       record.Status,
+		EndTime:      record.EndTime,
+		Duration:     record.Duration,
+		Comments:     record.Comments,
+		Userlines:    record.Userlines,
+		SpecVersion:  record.SpecVersion,
+		Motors:       record.Motors,
+		CreatedBy:    identityMessage(record.CreatedBy),
+		CreatedAt:    record.CreatedAt,
+		UpdatedBy:    identityMessage(record.UpdatedBy),
+		UpdatedAt:    record.UpdatedAt,
+		EmbargoUntil: record.EmbargoUntil,
+		DeletedAt:    record.DeletedAt,
+		DeletedBy:    identityMessage(record.DeletedBy),
+	}
+	for _, change := range record.StatusHistory {
+		scan.StatusHistory = append(scan.StatusHistory, &pb.StatusChange{Status: change.Status, Time: change.Time})
+	}
+	if record.Variables != nil {
+		// Variables may hold values of the document store's types
+		var variables map[string]any
+		err := normalizeDocument(record.Variables, &variables)
+		if err == nil {
+			scan.Variables, err = structpb.NewStruct(variables)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "variables of record %s: %v", record.ScanId, err)
+		}
+	}
+	return scan, nil
+}
+
+// Return the scan record of a message
+func scanRecord(scan *pb.Scan) (UserRecord, error) {
+	if scan.ScanNumber > 0xffff {
+		return UserRecord{}, fmt.Errorf("scan_number %d is out of range", scan.ScanNumber)
+	}
+	record := UserRecord{
+		ScanId:       scan.Sid,
+		DatasetId:    scan.Did,
+		Cycle:        scan.Cycle,
+		Beamline:     scan.Beamline,
+		Btr:          scan.Btr,
+		SpecFile:     scan.SpecFile,
+		ScanNumber:   uint16(scan.ScanNumber),
+		StartTime:    scan.StartTime,
+		Command:      scan.Command,
+		Status:       scan.Status,
+		EndTime:      scan.EndTime,
+		Duration:     scan.Duration,
+		Comments:     scan.Comments,
+		Userlines:    scan.Userlines,
+		SpecVersion:  scan.SpecVersion,
+		Motors:       scan.Motors,
+		CreatedBy:    identityRecord(scan.CreatedBy),
+		CreatedAt:    scan.CreatedAt,
+		UpdatedBy:    identityRecord(scan.UpdatedBy),
+		UpdatedAt:    scan.UpdatedAt,
+		EmbargoUntil: scan.EmbargoUntil,
+		DeletedAt:    scan.DeletedAt,
+		DeletedBy:    identityRecord(scan.DeletedBy),
+	}
+	for _, change := range scan.StatusHistory {
+		record.StatusHistory = append(record.StatusHistory, StatusChange{Status: change.Status, Time: change.Time})
+	}
+	if scan.Variables != nil {
+		record.Variables = scan.Variables.AsMap()
+	}
+	return record, nil
+}
+
+// Return a gRPC server of the SpecScans service
+func NewGrpcServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(grpcUnaryInterceptor), grpc.ChainStreamInterceptor(grpcStreamInterceptor))
+	s := grpc.NewServer(opts...)
+	pb.RegisterSpecScansServer(s, specScansServer{})
+	return s
+}
+
+// Serve the gRPC interface on the given port, with the TLS certificate of the
+// given web server if it has one
+func StartGrpcServer(port int, webServer srvConfig.WebServer) error {
+	var opts []grpc.ServerOption
+	if webServer.ServerCrt != "" && webServer.ServerKey != "" {
+		creds, err := credentials.NewServerTLSFromFile(webServer.ServerCrt, webServer.ServerKey)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.StartGrpcServer] credentials.NewServerTLSFromFile error: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.StartGrpcServer] net.Listen error: %w", err)
+	}
+	log.Printf("Starting gRPC server on port %d", port)
+	return NewGrpcServer(opts...).Serve(listener)
+}
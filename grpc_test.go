@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	pb "github.com/CHESSComputing/SpecScansService/specscanspb"
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Helper to serve the gRPC interface in memory and return a client of it
+func setupTestGrpcClient(t *testing.T) pb.SpecScansClient {
+	listener := bufconn.Listen(1 << 20)
+	s := NewGrpcServer()
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewSpecScansClient(conn)
+}
+
+// Helper to return a context of gRPC calls with the given token
+func grpcTokenContext(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// Test adding, editing and searching records through the gRPC interface
+func TestGrpcService(t *testing.T) {
+	r := SetupTestService(t)
+	client := setupTestGrpcClient(t)
+	staff := testToken(t, authz.CustomClaims{User: "staff", Scope: "read write " + StaffScope})
+	ctx := grpcTokenContext(staff)
+
+	record, err := scanMessage(testUserRecord(1, 1709647200, map[string]float64{"samx": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := &pb.Scan{Beamline: "3a", ScanNumber: 70000}
+	stream, err := client.AddScans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scan := range []*pb.Scan{record, invalid} {
+		if err = stream.Send(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	added, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("adding records failed: %v", err)
+	}
+	if added.Added != 1 || added.Failed != 1 || len(added.Results) != 2 || added.Results[0].Sid == "" || added.Results[1].Error == "" {
+		t.Fatalf("adding a valid and an invalid record returned %+v", added)
+	}
+	sid := added.Results[0].Sid
+
+	fields, _ := structpb.NewStruct(map[string]any{"command": "dscan samx 0 1 10 1"})
+	edited, err := client.EditScan(ctx, &pb.EditScanRequest{Sid: sid, Fields: fields})
+	if err != nil {
+		t.Fatalf("editing a record failed: %v", err)
+	}
+	if edited.Command != "dscan samx 0 1 10 1" || edited.Motors["samx"] != 1 || edited.Variables.AsMap()["ring_current"] != 100.0 {
+		t.Errorf("edited record is %+v", edited)
+	}
+	fields, _ = structpb.NewStruct(map[string]any{"duration": 1.0})
+	_, err = client.EditScan(ctx, &pb.EditScanRequest{SpecFile: record.SpecFile, ScanNumber: 1, Fields: fields})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("editing the duration of a scan returned %v", err)
+	}
+
+	search := func(ctx context.Context, query string) ([]*pb.Scan, error) {
+		stream, err := client.SearchScans(ctx, &pb.SearchScansRequest{Query: query})
+		if err != nil {
+			return nil, err
+		}
+		var scans []*pb.Scan
+		for {
+			scan, err := stream.Recv()
+			if err == io.EOF {
+				return scans, nil
+			}
+			if err != nil {
+				return scans, err
+			}
+			scans = append(scans, scan)
+		}
+	}
+	scans, err := search(ctx, `{"beamline": "3a"}`)
+	if err != nil || len(scans) != 1 || scans[0].Sid != sid || scans[0].Command != "dscan samx 0 1 10 1" {
+		t.Errorf("search returned %+v, %v", scans, err)
+	}
+	if _, err = search(ctx, "{"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid search returned %v", err)
+	}
+	other := testToken(t, authz.CustomClaims{User: "other", Scope: "read", Btrs: []string{"other-1"}})
+	if scans, err = search(grpcTokenContext(other), "{}"); err != nil || len(scans) != 0 {
+		t.Errorf("search by a user of another BTR returned %+v, %v", scans, err)
+	}
+
+	if _, err = search(context.Background(), "{}"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("search without a token returned %v", err)
+	}
+	_, err = client.EditScan(grpcTokenContext(other), &pb.EditScanRequest{Sid: sid, Fields: fields})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("edit without the write scope returned %v", err)
+	}
+
+	response := serveTestRequest(t, r, "GET", "/audit?"+url.Values{"endpoint": {pb.SpecScans_AddScans_FullMethodName}}.Encode(), nil)
+	if response.SrvCode != services.OK || len(response.Results.Records) != 1 {
+		t.Fatalf("audit log of AddScans is %+v", response)
+	}
+	entry := response.Results.Records[0]
+	if entry["method"] != "gRPC" || entry["outcome"] != AuditPartial || entry["grpc_code"] != "OK" || entry["error"] == "" {
+		t.Errorf("AddScans recorded as %+v", entry)
+	}
+	response = serveTestRequest(t, r, "GET", "/audit?"+url.Values{"sid": {sid}}.Encode(), nil)
+	if len(response.Results.Records) != 2 {
+		t.Fatalf("audit log has %d entries of %s; want 2: %+v", len(response.Results.Records), sid, response.Results.Records)
+	}
+	if entry = response.Results.Records[1]; entry["endpoint"] != pb.SpecScans_EditScan_FullMethodName || entry["outcome"] != AuditSuccess {
+		t.Errorf("EditScan recorded as %+v", entry)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,13 +9,13 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	mapstructure "github.com/mitchellh/mapstructure"
 
 	srvConfig "github.com/CHESSComputing/golib/config"
 	lexicon "github.com/CHESSComputing/golib/lexicon"
-	mongo "github.com/CHESSComputing/golib/mongo"
 	ql "github.com/CHESSComputing/golib/ql"
 	services "github.com/CHESSComputing/golib/services"
 )
@@ -50,12 +51,36 @@ func AddHandler(c *gin.Context) {
 	if Verbose > 0 {
 		log.Printf("AddHandler received request %+v", records)
 	}
+	identity := requestIdentity(c)
+	now := epochTime(time.Now())
+	for i := range records {
+		SetCreated(&records[i], identity, now)
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	record_stores := make([]ScanStores, len(records))
+	for i, record := range records {
+		record_stores[i] = stores
+		if record.ScanId == "test" {
+			// Records with the "test" sid are the test records of clients
+			// which predate the sandbox
+			log.Printf("WARNING: records with sid \"test\" are deprecated, add them to the sandbox instead")
+			record_stores[i], err = requestSandboxStores(c)
+			if err != nil {
+				abortStoresError(c, err)
+				return
+			}
+		}
+	}
 	rec_ch := make(chan map[string]any)
 	err_ch := make(chan error)
 	defer close(rec_ch)
 	defer close(err_ch)
-	for _, record := range records {
-		go addRecord(record, rec_ch, err_ch)
+	for i, record := range records {
+		go addRecord(record_stores[i], record, rec_ch, err_ch)
 	}
 	var result_records []map[string]any
 	var result_err string
@@ -126,16 +151,28 @@ func EditHandler(c *gin.Context) {
 	if Verbose > 0 {
 		log.Printf("EditHandler received request %+v", edits)
 	}
+	identity := requestIdentity(c)
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
 
 	rec_ch := make(chan map[string]any)
 	err_ch := make(chan error)
 	defer close(rec_ch)
 	defer close(err_ch)
 	for _, edit := range edits {
-		go editRecord(edit, rec_ch, err_ch)
+		go editRecord(stores, access, identity, edit, rec_ch, err_ch)
 	}
 	var result_records []map[string]any
 	var result_err string
+	not_found, conflicts := 0, 0
 	for i := 0; i < len(edits); i++ {
 		select {
 		case new_record := <-rec_ch:
@@ -144,6 +181,11 @@ func EditHandler(c *gin.Context) {
 		case edit_err := <-err_ch:
 			result_err = fmt.Sprintf("%s; %s", result_err, edit_err)
 			log.Printf("Error editing record: %s", edit_err)
+			if errors.Is(edit_err, ErrScanNotFound) {
+				not_found++
+			} else if errors.Is(edit_err, ErrIllegalTransition) {
+				conflicts++
+			}
 		}
 	}
 	var httpcode, srvcode int
@@ -151,7 +193,11 @@ func EditHandler(c *gin.Context) {
 		httpcode = http.StatusOK
 		srvcode = services.OK
 	} else {
-		if len(result_records) == 0 {
+		if not_found == len(edits) {
+			httpcode = http.StatusNotFound
+		} else if conflicts == len(edits) {
+			httpcode = http.StatusConflict
+		} else if len(result_records) == 0 {
 			httpcode = http.StatusUnprocessableEntity
 		} else {
 			httpcode = http.StatusMultiStatus
@@ -174,9 +220,6 @@ func EditHandler(c *gin.Context) {
 
 // Handler for querying the databases for records
 func SearchHandler(c *gin.Context) {
-
-	matching_records := *new([]UserRecord)
-
 	// Parse database query from request
 	var query_request services.ServiceRequest
 	if err := c.Bind(&query_request); err != nil {
@@ -185,182 +228,583 @@ func SearchHandler(c *gin.Context) {
 		return
 	}
 	log.Printf("service request: %+v", query_request)
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	access.IncludeDeleted, err = includeDeletedRequest(c)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	matching_records, err := SearchRecords(stores, access, query_request.ServiceQuery)
+	if err != nil {
+		var search_err *SearchError
+		if !errors.As(err, &search_err) {
+			search_err = &SearchError{HttpCode: http.StatusInternalServerError, SrvCode: services.QueryError, Err: err}
+		}
+		resp := services.Response("SpecScans", search_err.HttpCode, search_err.SrvCode, search_err.Err)
+		c.JSON(search_err.HttpCode, resp)
+		return
+	}
+	searchResponse(c, stores, query_request.ServiceQuery, matching_records)
+}
+
+// SearchError is an error of a search, with the status codes of the
+// responses to search requests failing with it
+type SearchError struct {
+	HttpCode int
+	SrvCode  int
+	Err      error
+}
+
+func (e *SearchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SearchError) Unwrap() error {
+	return e.Err
+}
+
+// Helper function to return a search error
+func searchError(httpcode int, srvcode int, err error) error {
+	return &SearchError{HttpCode: httpcode, SrvCode: srvcode, Err: err}
+}
+
+// Search the given stores for the records the caller with the given access
+// may read matching a service query
+func SearchRecords(stores ScanStores, access RecordAccess, service_query services.ServiceQuery) ([]UserRecord, error) {
+	matching_records := *new([]UserRecord)
 
 	// Get all attributes we need for querying the mongodb
-	query := query_request.ServiceQuery.Query
-	idx := query_request.ServiceQuery.Idx
-	limit := query_request.ServiceQuery.Limit
+	query := service_query.Query
+	idx := service_query.Idx
+	limit := service_query.Limit
 
 	// If a pre-built spec map was provided (e.g. a compound $and/$or filter from the
 	// Frontend), use it directly — same approach as MetaData/handlers.go QueryHandler.
 	// This avoids re-parsing the JSON query string through ql.ParseQuery, which would
 	// strip compound $and operators via adjustQuery.
-	if query_request.ServiceQuery.Spec != nil {
-		spec := query_request.ServiceQuery.Spec
-		mongo_records, err := getMongoRecords(spec, idx, limit)
-		if err != nil {
-			resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-			c.JSON(http.StatusInternalServerError, resp)
-			return
+	if service_query.Spec != nil {
+		spec, err := ConvertTimeQueries(service_query.Spec)
+		if err == nil {
+			spec, err = ConvertVariableQueries(stores.Variables, spec)
 		}
-		matching_records, err = CompleteMongoRecords(mongo_records...)
 		if err != nil {
-			resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-			c.JSON(http.StatusInternalServerError, resp)
-			return
+			return nil, searchError(http.StatusBadRequest, services.ParseError, err)
 		}
-		var map_records []map[string]any
-		err = Decode(matching_records, &map_records)
+		mongo_records, err := getMongoRecords(stores.Docs, access.Restrict(spec), idx, limit)
 		if err != nil {
-			resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
-			c.JSON(http.StatusInternalServerError, resp)
-			return
+			return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 		}
-		response := services.ServiceResponse{
-			HttpCode:     http.StatusOK,
-			SrvCode:      services.OK,
-			Service:      "SpecScans",
-			ServiceQuery: query_request.ServiceQuery,
-			Results: services.ServiceResults{
-				NRecords: len(map_records),
-				Records:  map_records,
-			},
+		matching_records, err = CompleteMongoRecords(stores.Motors, mongo_records...)
+		if err != nil {
+			return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 		}
-		c.JSON(http.StatusOK, response)
-		return
+		return matching_records, nil
 	}
 
-	spec, err := ql.ParseQuery(query)
-	if Verbose > 0 {
-		log.Printf("search query='%s' spec=%+v", query, spec)
-	}
-	if err != nil {
-		rec := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
-		c.JSON(http.StatusInternalServerError, rec)
-		return
-	}
-	if len(spec) == 0 &&
-		strings.Contains(query, srvConfig.Config.DID.Separator) &&
-		strings.Contains(query, srvConfig.Config.DID.Divider) {
-		// User's query string did not represent a mapping, but it could be a DID.
-		query = fmt.Sprintf("{\"did\": \"%s\"}", query)
-	}
-
-	// Get query string as map of values
-	log.Printf("### query: %+v", query)
-	queries, err := getServiceQueriesByDBType(QLM, "SpecScans", query)
+	queries, err := searchQueries(stores, query)
 	if err != nil {
-		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
-		c.JSON(http.StatusInternalServerError, resp)
-		return
+		return nil, err
 	}
-	log.Printf("queries %+v", queries)
 
 	if queries["mongo"] == nil {
 		if queries["sql"] == nil {
 			// queries["mongo"] == nil && queries["sql"] == nil
 			// User query is empty -- match _all_ records
-			mongo_records, err := getMongoRecords(map[string]any{}, idx, limit)
+			mongo_records, err := getMongoRecords(stores.Docs, access.Restrict(map[string]any{}), idx, limit)
 			if err != nil {
-				resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-				c.JSON(http.StatusInternalServerError, resp)
-				return
+				return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 			}
-			matching_records, err = CompleteMongoRecords(mongo_records...)
+			matching_records, err = CompleteMongoRecords(stores.Motors, mongo_records...)
 			if err != nil {
-				resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-				c.JSON(http.StatusInternalServerError, resp)
-				return
+				return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 			}
 		} else {
 			// queries["mongo"] == nil && queries["sql"] != nil
 			// Search for matching records by motor positions only, then complete all
 			// the matching motor records with their mongodb portion
-			motor_records, err := getMotorRecords(queries["sql"])
+			motor_records, err := getMotorRecords(stores.Motors, queries["sql"], nil, access.IncludeDeleted)
 			if err != nil {
-				resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-				c.JSON(http.StatusInternalServerError, resp)
-				return
+				return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 			}
-			matching_records, err = CompleteMotorRecords(motor_records...)
+			matching_records, err = CompleteMotorRecords(stores.Docs, motor_records...)
 			if err != nil {
-				resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-				c.JSON(http.StatusInternalServerError, resp)
-				return
+				return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 			}
+			matching_records = access.Filter(matching_records)
 		}
 	} else {
-		mongo_records, err := getMongoRecords(queries["mongo"], idx, limit)
+		mongo_records, err := getMongoRecords(stores.Docs, access.Restrict(queries["mongo"]), idx, limit)
 		if err != nil {
-			resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-			c.JSON(http.StatusInternalServerError, resp)
-			return
+			return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 		}
 		if queries["sql"] == nil {
 			// queries["mongo"] != nil && queries["sql"] == nil
 			// Search for matching records in the mongodb only, then complete all
 			// matching mongo records with their motors component
-			matching_records, err = CompleteMongoRecords(mongo_records...)
+			matching_records, err = CompleteMongoRecords(stores.Motors, mongo_records...)
 			if err != nil {
-				resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-				c.JSON(http.StatusInternalServerError, resp)
-				return
+				return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 			}
 		} else {
 			// queries["mongo"] != nil && queries["sql"] != nil
 			// Search both dbs separately, then return the _intersection_ of the two
 			// matching sets (NB: doesn't allow conditional filtering on fields in
-			// separate dbs!).
-			motor_records, err := getMotorRecords(queries["sql"])
+			// separate dbs!). Motors are resolved within the beamlines the
+			// query is restricted to.
+			motor_records, err := getMotorRecords(stores.Motors, queries["sql"], queryBeamlines(queries["mongo"]), access.IncludeDeleted)
 			if err != nil {
-				resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
-				c.JSON(http.StatusInternalServerError, resp)
-				return
+				return nil, searchError(http.StatusInternalServerError, services.QueryError, err)
 			}
 			matching_records = getIntersectionRecords(mongo_records, motor_records)
 		}
 	}
-	var map_records []map[string]any
-	err = Decode(matching_records, &map_records)
+	return matching_records, nil
+}
+
+// Return the queries of the document store ("mongo") and of motor positions
+// ("sql") making up a search query, either of which is nil if the search does
+// not query that store
+func searchQueries(stores ScanStores, query string) (map[string]map[string]any, error) {
+	spec, err := ql.ParseQuery(query)
+	if Verbose > 0 {
+		log.Printf("search query='%s' spec=%+v", query, spec)
+	}
 	if err != nil {
-		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
+		return nil, searchError(http.StatusInternalServerError, services.ParseError, err)
+	}
+	if len(spec) == 0 &&
+		strings.Contains(query, srvConfig.Config.DID.Separator) &&
+		strings.Contains(query, srvConfig.Config.DID.Divider) {
+		// User's query string did not represent a mapping, but it could be a DID.
+		query = fmt.Sprintf("{\"did\": \"%s\"}", query)
+	}
+
+	// Get query string as map of values
+	log.Printf("### query: %+v", query)
+	queries, err := getServiceQueriesByDBType(QLM, "SpecScans", query)
+	if err != nil {
+		return nil, searchError(http.StatusInternalServerError, services.ParseError, err)
+	}
+	log.Printf("queries %+v", queries)
+	if queries["mongo"] != nil {
+		// Convert human-readable times (ISO 8601, ranges, "last 24h") to epochs
+		// and give queries on numeric variables numeric semantics
+		queries["mongo"], err = ConvertTimeQueries(queries["mongo"])
+		if err == nil {
+			queries["mongo"], err = ConvertVariableQueries(stores.Variables, queries["mongo"])
+		}
+		if err != nil {
+			return nil, searchError(http.StatusBadRequest, services.ParseError, err)
+		}
+	}
+	return queries, nil
+}
+
+// Handler for getting motor catalog entries, of the motors and beamlines
+// given by "mne" and "beamline" URL parameters or of all motors
+func MotorsHandler(c *gin.Context) {
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	infos, err := stores.Motors.GetMotorInfo(c.QueryArray("beamline"), c.QueryArray("mne"))
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var records []map[string]any
+	err = Decode(infos, &records)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DecodeError, err)
 		c.JSON(http.StatusInternalServerError, resp)
 		return
 	}
 	response := services.ServiceResponse{
-		HttpCode:     http.StatusOK,
-		SrvCode:      services.OK,
-		Service:      "SpecScans",
-		ServiceQuery: query_request.ServiceQuery,
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
 		Results: services.ServiceResults{
-			NRecords: len(map_records),
-			Records:  map_records,
+			NRecords: len(records),
+			Records:  records,
 		},
 	}
 	c.JSON(http.StatusOK, response)
-	return
 }
 
-// Helper function to add a single record to the database(s)
+// Handler for creating or replacing motor catalog entries (units,
+// description, beamline and aliases). Only staff and admins manage the
+// catalog.
+func EditMotorsHandler(c *gin.Context) {
+	if !requireStaff(c) {
+		return
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	// Get single entry OR multiple entries to submit
+	defer c.Request.Body.Close()
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("ReadAll error: %v", err)
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ReaderError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var infos []MotorInfo
+	err = json.Unmarshal(body, &infos)
+	if err != nil {
+		var info MotorInfo
+		err = json.Unmarshal(body, &info)
+		if err != nil {
+			log.Printf("Unmarshal error: %v", err)
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.UnmarshalError, err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		infos = []MotorInfo{info}
+	}
+	for _, info := range infos {
+		if err := info.Validate(); err != nil {
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.ValidateError, err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+	}
+
+	var updated []MotorInfo
+	var result_err string
+	for _, info := range infos {
+		err = stores.Motors.SetMotorInfo(info)
+		if err == nil {
+			var edited []MotorInfo
+			edited, err = stores.Motors.GetMotorInfo([]string{info.Beamline}, []string{info.Mne})
+			updated = append(updated, edited...)
+		}
+		if err != nil {
+			result_err = fmt.Sprintf("%s; %s", result_err, err)
+			log.Printf("Error editing motor %s at beamline %q: %s", info.Mne, info.Beamline, err)
+		}
+	}
+	var result_records []map[string]any
+	err = Decode(updated, &result_records)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DecodeError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var httpcode, srvcode int
+	if result_err == "" {
+		httpcode = http.StatusOK
+		srvcode = services.OK
+	} else {
+		if len(result_records) == 0 {
+			httpcode = http.StatusUnprocessableEntity
+		} else {
+			httpcode = http.StatusMultiStatus
+		}
+		srvcode = services.TransactionError
+	}
+	response := services.ServiceResponse{
+		HttpCode: httpcode,
+		SrvCode:  srvcode,
+		Service:  "SpecScans",
+		Error:    result_err,
+		Results: services.ServiceResults{
+			NRecords: len(result_records),
+			Records:  result_records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for getting variable registry entries, of the variables given by
+// "name" URL parameters or of all variables
+func VariablesHandler(c *gin.Context) {
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	infos, err := stores.Variables.GetVariableInfo(c.QueryArray("name"))
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var records []map[string]any
+	err = Decode(infos, &records)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DecodeError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(records),
+			Records:  records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for declaring variables (type, units, description, and whether
+// they are indexed and stored). Declaring a variable does not convert the
+// values of records already ingested. Only staff and admins declare
+// variables.
+func EditVariablesHandler(c *gin.Context) {
+	if !requireStaff(c) {
+		return
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	// Get single declaration OR multiple declarations to submit
+	defer c.Request.Body.Close()
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("ReadAll error: %v", err)
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ReaderError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var infos []VariableInfo
+	err = json.Unmarshal(body, &infos)
+	if err != nil {
+		var info VariableInfo
+		err = json.Unmarshal(body, &info)
+		if err != nil {
+			log.Printf("Unmarshal error: %v", err)
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.UnmarshalError, err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		infos = []VariableInfo{info}
+	}
+	for _, info := range infos {
+		if err := info.Validate(); err != nil {
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.ValidateError, err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+	}
+
+	var names []string
+	var result_err string
+	for _, info := range infos {
+		err = stores.Variables.SetVariableInfo(info)
+		if err == nil && info.Indexed {
+			err = stores.Docs.EnsureIndex("variables." + info.Name)
+		}
+		if err != nil {
+			result_err = fmt.Sprintf("%s; %s", result_err, err)
+			log.Printf("Error declaring variable %s: %s", info.Name, err)
+			continue
+		}
+		names = append(names, info.Name)
+	}
+	var result_records []map[string]any
+	if len(names) > 0 {
+		updated, err := stores.Variables.GetVariableInfo(names)
+		if err == nil {
+			err = Decode(updated, &result_records)
+		}
+		if err != nil {
+			resp := services.Response("SpecScans", http.StatusInternalServerError, services.DecodeError, err)
+			c.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+	}
+	var httpcode, srvcode int
+	if result_err == "" {
+		httpcode = http.StatusOK
+		srvcode = services.OK
+	} else {
+		if len(result_records) == 0 {
+			httpcode = http.StatusUnprocessableEntity
+		} else {
+			httpcode = http.StatusMultiStatus
+		}
+		srvcode = services.TransactionError
+	}
+	response := services.ServiceResponse{
+		HttpCode: httpcode,
+		SrvCode:  srvcode,
+		Service:  "SpecScans",
+		Error:    result_err,
+		Results: services.ServiceResults{
+			NRecords: len(result_records),
+			Records:  result_records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Helper function to write the records matching a search in the format
+// requested by the "format", "time_format" and "units" URL parameters
+func searchResponse(c *gin.Context, stores ScanStores, service_query services.ServiceQuery, matching_records []UserRecord) {
+	format := c.DefaultQuery("format", "json")
+	if format == "spec" {
+		var buf bytes.Buffer
+		err := WriteSpecFiles(&buf, matching_records)
+		if err != nil {
+			resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
+			c.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+		return
+	}
+	var map_records []map[string]any
+	err := Decode(matching_records, &map_records)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	if c.Query("time_format") == "iso" {
+		FormatRecordTimes(map_records)
+	}
+	if c.Query("units") == "true" {
+		// Add the units of each record's motors (where known)
+		units, err := motorUnits(stores.Motors, matching_records)
+		if err != nil {
+			resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+			c.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+		for i, record := range matching_records {
+			record_units := make(map[string]any)
+			for mne := range record.Motors {
+				if units[record.Beamline][mne] != "" {
+					record_units[mne] = units[record.Beamline][mne]
+				}
+			}
+			map_records[i]["motor_units"] = record_units
+		}
+	}
+	if format == "json" {
+		response := services.ServiceResponse{
+			HttpCode:     http.StatusOK,
+			SrvCode:      services.OK,
+			Service:      "SpecScans",
+			ServiceQuery: service_query,
+			Results: services.ServiceResults{
+				NRecords: len(map_records),
+				Records:  map_records,
+			},
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	content_type, ok := TableContentTypes[format]
+	if !ok {
+		err := fmt.Errorf("unsupported format %q", format)
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParseError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	var buf bytes.Buffer
+	err = WriteTable(&buf, map_records, format)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	c.Data(http.StatusOK, content_type, buf.Bytes())
+}
+
+// Add a single record to the given stores and return its result record (with
+// its sid)
+func AddRecord(stores ScanStores, record UserRecord) (map[string]any, error) {
+	rec_ch := make(chan map[string]any, 1)
+	err_ch := make(chan error, 1)
+	addRecord(stores, record, rec_ch, err_ch)
+	select {
+	case new_record := <-rec_ch:
+		return new_record, nil
+	default:
+		return nil, <-err_ch
+	}
+}
+
+// Helper function to add a single record to the given stores
 // (to be called as a goroutine)
-func addRecord(record UserRecord, rec_ch chan map[string]any, err_ch chan error) {
-	_, err := validateRecord(record)
+func addRecord(stores ScanStores, record UserRecord, rec_ch chan map[string]any, err_ch chan error) {
+	// Check the record's fields against its DID, or give it a DID
+	err := PrepareDID(&record)
+	if err != nil {
+		err_ch <- err
+		return
+	}
+	// Start the record's status history
+	err = PrepareStatus(&record)
+	if err != nil {
+		err_ch <- err
+		return
+	}
+	if record.EmbargoUntil != 0 {
+		err_ch <- errors.New("Record may not set embargo_until, which is maintained by the embargo API")
+		return
+	}
+	if record.DeletedAt != 0 || record.DeletedBy != nil {
+		err_ch <- errors.New("Record may not set deleted_at or deleted_by, which are maintained by deletes and restores")
+		return
+	}
+	_, err = validateRecord(record)
+	if err != nil {
+		err_ch <- err
+		return
+	}
+	// Give variables the types they are declared with
+	var observed []VariableInfo
+	var stored map[string]float64
+	record.Variables, observed, stored, err = PrepareVariables(stores.Variables, record.Variables)
 	if err != nil {
 		err_ch <- err
 		return
 	}
 	// Decompose the user-submitted record into the portions will be submitted to
 	// the two separate dbs.
-	mongo_record, motor_record := DecomposeRecord(record)
+	mongo_record, motor_record, err := DecomposeRecord(record)
+	if err != nil {
+		err_ch <- err
+		return
+	}
 
 	// Insert the motor mnes & positions record
 	// (do this first since we can easily check the uniqueness of the new record's
 	//  scan ID with the SQL db)
-	_, err = InsertMotors(motor_record, MotorsDb)
+	_, err = stores.Motors.InsertMotors(motor_record)
 	if err != nil {
 		err_ch <- err
 		return
 	}
+	if len(stored) > 0 {
+		err = stores.Variables.SetVariableValues(motor_record.ScanId, stored)
+		if err != nil {
+			err_ch <- err
+			return
+		}
+	}
 
 	// If submitting the motor record was successful, submit the other portion of
 	// the record to mongodb.
@@ -370,19 +814,42 @@ func addRecord(record UserRecord, rec_ch chan map[string]any, err_ch chan error)
 		err_ch <- err
 		return
 	}
-	mongo.Insert(
-		srvConfig.Config.SpecScans.MongoDB.DBName,
-		srvConfig.Config.SpecScans.MongoDB.DBColl,
-		[]map[string]any{mongo_record_map})
+	err = stores.Docs.Insert(mongo_record_map)
+	if err != nil {
+		err_ch <- err
+		return
+	}
+	// The record is in, so a registry error is only logged
+	err = stores.Variables.ObserveVariables(observed)
+	if err != nil {
+		log.Printf("Error registering variables of record %s: %v", mongo_record.ScanId, err)
+	}
+
+	publishScanEvent(stores, ScanAdded, mongo_record.ScanId)
 
 	// Send SID of new record
 	result_record := map[string]any{"sid": mongo_record.ScanId}
 	rec_ch <- result_record
 }
 
-// Helper function to edit a single record in the database(s)
-// (to be called as a goroutine)
-func editRecord(edit map[string]any, rec_ch chan map[string]any, err_ch chan error) {
+// Edit a single record in the given stores on behalf of the given caller with
+// the given access and return the edited record
+func EditRecord(stores ScanStores, access RecordAccess, by Identity, edit map[string]any) (map[string]any, error) {
+	rec_ch := make(chan map[string]any, 1)
+	err_ch := make(chan error, 1)
+	editRecord(stores, access, by, edit, rec_ch, err_ch)
+	select {
+	case edited_record := <-rec_ch:
+		return edited_record, nil
+	default:
+		return nil, <-err_ch
+	}
+}
+
+// Helper function to edit a single record in the given stores on behalf of
+// the given caller, who may only edit the records they may read (to be called
+// as a goroutine)
+func editRecord(stores ScanStores, access RecordAccess, by Identity, edit map[string]any, rec_ch chan map[string]any, err_ch chan error) {
 	// Get unedited version of the record to edit as map[string]any
 	// (look it up by start_time or spec_file & scan_number, whichever is available)
 	query := map[string]any{}
@@ -403,19 +870,102 @@ func editRecord(edit map[string]any, rec_ch chan map[string]any, err_ch chan err
 	} else {
 		query["sid"] = sid
 	}
-	original_records, err := getMongoRecords(query, 0, 0)
+	original_records, err := getMongoRecords(stores.Docs, query, 0, 0)
+	if err != nil {
+		err_ch <- err
+		return
+	}
+	// Records the caller may not read are not found
+	access.IncludeDeleted = true
+	if len(original_records) == 1 && !access.Allows(CompleteRecord(original_records[0], MotorRecord{})) {
+		original_records = nil
+	}
+	if len(original_records) == 0 {
+		err_ch <- fmt.Errorf("[SpecScansService.main.editRecord] %w: %v", ErrScanNotFound, query)
+		return
+	}
 	if len(original_records) != 1 {
 		err_ch <- errors.New(fmt.Sprintf("Edit request matched %d existing records. Must match exactly 1.", len(original_records)))
 		return
 	}
+	// nor moved to BTRs whose records the caller may not read
+	if btr, ok := edit["btr"].(string); ok {
+		moved := original_records[0]
+		moved.Btr = btr
+		if !access.Allows(CompleteRecord(moved, MotorRecord{})) {
+			err_ch <- fmt.Errorf("Edit may not move scan %s to BTR %s", original_records[0].ScanId, btr)
+			return
+		}
+	}
+	if original_records[0].DeletedAt != 0 {
+		err_ch <- fmt.Errorf("Scan %s is deleted; restore it before editing it", original_records[0].ScanId)
+		return
+	}
 	var edited_record map[string]any
 	err = mapstructure.Decode(original_records[0], &edited_record)
 	if err != nil {
 		err_ch <- err
 		return
 	}
+	// A changed status follows the status lifecycle like a status transition
+	for _, key := range StatusKeys {
+		if _, ok := edit[key]; ok {
+			err_ch <- fmt.Errorf("Edit may not set %s, which is maintained by status transitions", key)
+			return
+		}
+	}
+	for _, key := range ProvenanceKeys {
+		if _, ok := edit[key]; ok {
+			err_ch <- fmt.Errorf("Edit may not set %s, which is maintained by the service", key)
+			return
+		}
+	}
+	for _, key := range EmbargoKeys {
+		if _, ok := edit[key]; ok {
+			err_ch <- fmt.Errorf("Edit may not set %s, which is maintained by the embargo API", key)
+			return
+		}
+	}
+	for _, key := range DeletedKeys {
+		if _, ok := edit[key]; ok {
+			err_ch <- fmt.Errorf("Edit may not set %s, which is maintained by deletes and restores", key)
+			return
+		}
+	}
+	now := epochTime(time.Now())
+	var status_update map[string]any
+	if status, ok := edit["status"]; ok && status != original_records[0].Status {
+		status, _ := status.(string)
+		status_update, err = statusUpdate(original_records[0], status, now)
+		if err != nil {
+			err_ch <- err
+			return
+		}
+		// The update only applies if the status is still the one checked
+		query["status"] = original_records[0].Status
+	}
+	// Edited variables replace the record's variables, and get the types they
+	// are declared with
+	var observed []VariableInfo
+	var stored map[string]float64
+	if variables, ok := edit["variables"].(map[string]any); ok {
+		edit["variables"], observed, stored, err = PrepareVariables(stores.Variables, variables)
+		if err != nil {
+			err_ch <- err
+			return
+		}
+	}
 	for k, v := range edit {
-		edited_record[k] = v
+		// Keys identifying the record are not edited (and their JSON-decoded
+		// values may not have the record's types)
+		if k != "sid" && k != "spec_file" && k != "scan_number" {
+			edited_record[k] = v
+		}
+	}
+	err = CheckDID(edited_record)
+	if err != nil {
+		err_ch <- err
+		return
 	}
 	_, err = validateRecord(edited_record)
 	if err != nil {
@@ -429,16 +979,57 @@ func editRecord(edit map[string]any, rec_ch chan map[string]any, err_ch chan err
 			update_spec["$set"].(map[string]any)[k] = v
 		}
 	}
-	err = mongo.UpsertRecord(
-		srvConfig.Config.SpecScans.MongoDB.DBName,
-		srvConfig.Config.SpecScans.MongoDB.DBColl,
-		query,
-		update_spec,
-	)
+	if status_update != nil {
+		for k, v := range status_update["$set"].(map[string]any) {
+			update_spec["$set"].(map[string]any)[k] = v
+			edited_record[k] = v
+		}
+		update_spec["$push"] = status_update["$push"]
+	}
+	for k, v := range updatedFields(by, now) {
+		update_spec["$set"].(map[string]any)[k] = v
+		edited_record[k] = v
+	}
+	err = stores.Docs.Update(query, update_spec)
+	if errors.Is(err, ErrScanNotFound) && status_update != nil {
+		// The record is still there if its status changed concurrently
+		n, count_err := stores.Docs.Count(map[string]any{"sid": original_records[0].ScanId})
+		if count_err == nil && n > 0 {
+			err = fmt.Errorf("[SpecScansService.main.editRecord] %w: status of %s changed concurrently", ErrIllegalTransition, original_records[0].ScanId)
+		}
+	}
+	if err != nil {
+		err_ch <- err
+		return
+	}
+	// The scan's motor positions are those of its beamline's motors
+	if beamline, ok := edit["beamline"].(string); ok && beamline != original_records[0].Beamline {
+		err = stores.Motors.SetScanBeamline(original_records[0].ScanId, beamline)
+		if err != nil {
+			err_ch <- err
+			return
+		}
+	}
+	err = stores.Motors.SetScanUpdated(original_records[0].ScanId, by, now)
 	if err != nil {
 		err_ch <- err
 		return
 	}
+	if _, ok := edit["variables"]; ok {
+		err = stores.Variables.SetVariableValues(original_records[0].ScanId, stored)
+		if err != nil {
+			err_ch <- err
+			return
+		}
+		err = stores.Variables.ObserveVariables(observed)
+		if err != nil {
+			log.Printf("Error registering variables of record %s: %v", original_records[0].ScanId, err)
+		}
+	}
+	publishScanEvent(stores, ScanEdited, original_records[0].ScanId)
+	if status_update != nil {
+		publishScanEvent(stores, ScanStatus, original_records[0].ScanId)
+	}
 	rec_ch <- edited_record
 }
 
@@ -457,9 +1048,9 @@ func validateRecord(record any) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("[SpecScansService.main.validateRecord] lexicon.ValidateRecord error: %w", err)
 	}
-	err = Schema.Validate(record_map)
+	err = validateSchema(record_map)
 	if err != nil {
-		return false, fmt.Errorf("[SpecScansService.main.validateRecord] Schema.Validate error: %w", err)
+		return false, fmt.Errorf("[SpecScansService.main.validateRecord] validateSchema error: %w", err)
 	}
 	return true, nil
 }
@@ -487,11 +1078,42 @@ func getServiceQueriesByDBType(q ql.QLManager, servicename string, query string)
 	return dbqueries, nil
 }
 
-// Get matching records from the mongodb only
-func getMongoRecords(query map[string]any, idx int, limit int) ([]MongoRecord, error) {
+// Return the beamlines a mongodb query is restricted to by its "beamline"
+// condition (a value, {"$eq": value} or {"$in": values}), or nil
+func queryBeamlines(query map[string]any) []string {
+	switch condition := query["beamline"].(type) {
+	case string:
+		return []string{condition}
+	case map[string]any:
+		if value, ok := condition["$eq"].(string); ok {
+			return []string{value}
+		}
+		if values, ok := condition["$in"].([]any); ok {
+			var beamlines []string
+			for _, value := range values {
+				beamline, ok := value.(string)
+				if !ok {
+					return nil
+				}
+				beamlines = append(beamlines, beamline)
+			}
+			return beamlines
+		}
+	}
+	return nil
+}
+
+// Get matching records from the given document store only
+func getMongoRecords(docs DocumentStore, query map[string]any, idx int, limit int) ([]MongoRecord, error) {
 	var mongo_records []MongoRecord
-	nrecords := mongo.Count(srvConfig.Config.SpecScans.MongoDB.DBName, srvConfig.Config.SpecScans.MongoDB.DBColl, query)
-	records := mongo.Get(srvConfig.Config.SpecScans.MongoDB.DBName, srvConfig.Config.SpecScans.MongoDB.DBColl, query, idx, limit)
+	nrecords, err := docs.Count(query)
+	if err != nil {
+		return mongo_records, fmt.Errorf("[SpecScansService.main.getMongoRecords] docs.Count error: %w", err)
+	}
+	records, err := docs.Get(query, idx, limit)
+	if err != nil {
+		return mongo_records, fmt.Errorf("[SpecScansService.main.getMongoRecords] docs.Get error: %w", err)
+	}
 	if Verbose > 0 {
 		log.Printf("spec %v nrecords %d return idx=%d limit=%d", query, nrecords, idx, limit)
 	}
@@ -507,8 +1129,13 @@ func getMongoRecords(query map[string]any, idx int, limit int) ([]MongoRecord, e
 	return mongo_records, nil
 }
 
-func getMotorRecords(query map[string]any) ([]MotorRecord, error) {
-	motor_records := QueryMotorsDb(query)
+// Get matching records from the given motor store only, resolving motors
+// within the given beamlines
+func getMotorRecords(motors MotorStore, query map[string]any, beamlines []string, include_deleted bool) ([]MotorRecord, error) {
+	motor_records, err := QueryMotorsDb(motors, query, beamlines, include_deleted)
+	if err != nil {
+		return motor_records, fmt.Errorf("[SpecScansService.main.getMotorRecords] QueryMotorsDb error: %w", err)
+	}
 	if Verbose > 0 {
 		log.Printf("query %v found %d records\n", query, len(motor_records))
 	}
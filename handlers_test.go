@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	schema "github.com/CHESSComputing/golib/beamlines"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupTestService configures the service to use in-memory storage and
+// returns a router with the service's handlers (without authorization).
+func SetupTestService(t *testing.T) *gin.Engine {
+	config := srvConfig.SrvConfig{}
+	config.QL.ServiceMapFile = "static/service_map_file.json"
+	config.DID.Separator = "/"
+	config.DID.Divider = "="
+	config.SpecScans.WebServer.StaticDir = "static"
+	config.Authz.ClientID = "test"
+	srvConfig.Config = &config
+
+	err := QLM.Init(config.QL.ServiceMapFile)
+	if err != nil {
+		t.Fatalf("Failed to initialize QLM: %v", err)
+	}
+	var smgr schema.SchemaManager
+	Schema, err = smgr.Load("static/schema.json")
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+
+	ScanDocs = NewMemoryDocumentStore()
+	ScanMotors = NewMemoryMotorStore()
+	ScanVariables = NewMemoryVariableStore()
+	ScanEmbargoes = NewMemoryEmbargoStore()
+	AuditDocs = NewMemoryDocumentStore()
+	WebhookDocs = NewMemoryDocumentStore()
+	WebhookDeliveryDocs = NewMemoryDocumentStore()
+	Sandbox = &ScanStores{
+		Docs:      NewMemoryDocumentStore(),
+		Motors:    NewMemoryMotorStore(),
+		Variables: NewMemoryVariableStore(),
+		Embargoes: NewMemoryEmbargoStore(),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	for _, route := range auditRoutes(validateRoutes(serviceRoutes())) {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+	return r
+}
+
+// testUserRecord returns a valid scan record for tests
+func testUserRecord(scan_number uint16, start_time float64, motors map[string]float64) UserRecord {
+	return UserRecord{
+		DatasetId:   "/beamline=3a/btr=test-123-a/cycle=2024-1/sample_name=sample",
+		Cycle:       "2024-1",
+		Beamline:    "3a",
+		Btr:         "test-123-a",
+		SpecFile:    "/nfs/chess/raw/2024-1/3a/test-123-a/sample",
+		ScanNumber:  scan_number,
+		StartTime:   start_time,
+		Command:     "ascan  samx 0 1 10 1",
+		Status:      "running",
+		Comments:    []string{"comment"},
+		Userlines:   []string{"userline"},
+		SpecVersion: "6.12.03",
+		Motors:      motors,
+		Variables:   map[string]any{"ring_current": 100.0},
+	}
+}
+
+// Helper to issue a test token with the given claims
+func testToken(t *testing.T, claims authz.CustomClaims) string {
+	token, err := authz.JWTAccessToken(srvConfig.Config.Authz.ClientID, time.Now().Add(time.Hour).Unix(), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Helper to send a request with a staff token to the test router and decode
+// the JSON response
+func serveTestRequest(t *testing.T, r *gin.Engine, method string, url string, body any) services.ServiceResponse {
+	token := testToken(t, authz.CustomClaims{User: "staff", Scope: "read write " + StaffScope})
+	return serveTokenRequest(t, r, method, url, token, body)
+}
+
+// Helper to send a request with the given token (none if empty) to the test
+// router and decode the JSON response
+func serveTokenRequest(t *testing.T, r *gin.Engine, method string, url string, token string, body any) services.ServiceResponse {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to marshal request body: %v", err)
+	}
+	req := httptest.NewRequest(method, url, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var response services.ServiceResponse
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response %s: %v", w.Body.String(), err)
+	}
+	return response
+}
+
+// Helper to search the test service
+func searchTestService(t *testing.T, r *gin.Engine, query string) []map[string]any {
+	request := services.ServiceRequest{
+		ServiceQuery: services.ServiceQuery{Query: query},
+	}
+	response := serveTestRequest(t, r, "POST", "/search", request)
+	if response.HttpCode != http.StatusOK {
+		t.Fatalf("Search for %s failed: %+v", query, response)
+	}
+	return response.Results.Records
+}
+
+// TestAddEditSearch exercises adding, editing and searching records end to end
+func TestAddEditSearch(t *testing.T) {
+	r := SetupTestService(t)
+
+	records := []UserRecord{
+		testUserRecord(1, 1709647200, map[string]float64{"samx": 1.5, "samz": -2.0}),
+		testUserRecord(2, 1709647260, map[string]float64{"samx": 3.0}),
+	}
+	response := serveTestRequest(t, r, "POST", "/add", records)
+	if response.SrvCode != services.OK || response.Results.NRecords != 2 {
+		t.Fatalf("Adding records failed: %+v", response)
+	}
+
+	// adding a record with the same scan id again must fail
+	response = serveTestRequest(t, r, "POST", "/add", records[0])
+	if response.SrvCode == services.OK {
+		t.Errorf("Adding a duplicate record succeeded: %+v", response)
+	}
+
+	tests := []struct {
+		query    string
+		expected []float64
+	}{
+		{query: `{"scan_number": 2}`, expected: []float64{2}},
+		{query: `{"motors.samx": {"$gt": 2.0}}`, expected: []float64{2}},
+		{query: `{"motors.samz": -2.0}`, expected: []float64{1}},
+		{query: `{"beamline": "3a", "motors.samx": 1.5}`, expected: []float64{1}},
+		{query: `{"start_time": "2024-03-05T14:01:00Z"}`, expected: []float64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			found := searchTestService(t, r, tt.query)
+			if len(found) != len(tt.expected) {
+				t.Fatalf("Search %s found %d records; want %d", tt.query, len(found), len(tt.expected))
+			}
+			for i, record := range found {
+				if record["scan_number"] != tt.expected[i] {
+					t.Errorf("Search %s found scan %v; want %v", tt.query, record["scan_number"], tt.expected[i])
+				}
+			}
+		})
+	}
+
+	edit := map[string]any{
+		"spec_file":   records[0].SpecFile,
+		"scan_number": 1,
+		"status":      "completed",
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", edit)
+	if response.SrvCode != services.OK {
+		t.Fatalf("Editing record failed: %+v", response)
+	}
+	found := searchTestService(t, r, `{"status": "completed"}`)
+	if len(found) != 1 || found[0]["scan_number"] != 1.0 {
+		t.Errorf("Edited record not found: %+v", found)
+	}
+	if motors, ok := found[0]["motors"].(map[string]any); !ok || motors["samx"] != 1.5 {
+		t.Errorf("Edited record lost its motor positions: %+v", found[0])
+	}
+}
+
+// TestMotorCatalog tests editing motor metadata and searching on aliases
+func TestMotorCatalog(t *testing.T) {
+	r := SetupTestService(t)
+	response := serveTestRequest(t, r, "POST", "/add", testUserRecord(1, 1709647200, map[string]float64{"samx": 1.5, "th": 3.0}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding record failed: %+v", response)
+	}
+
+	infos := []MotorInfo{
+		{Mne: "samx", Units: "mm", Description: "sample x", Beamline: "3a", Aliases: []string{"sample_x"}},
+		{Mne: "theta", Units: "deg", Beamline: "3a", Aliases: []string{"th"}},
+	}
+	response = serveTestRequest(t, r, "PUT", "/motors", infos)
+	if response.SrvCode == services.OK {
+		t.Errorf("Alias equal to an existing mnemonic was accepted: %+v", response)
+	}
+	infos[1].Aliases = []string{"tth"}
+	response = serveTestRequest(t, r, "PUT", "/motors", infos)
+	if response.SrvCode != services.OK || response.Results.NRecords != 2 {
+		t.Fatalf("Editing motors failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "PUT", "/motors", MotorInfo{Mne: "samz", Beamline: "3a", Aliases: []string{"sample_x"}})
+	if response.SrvCode == services.OK {
+		t.Errorf("Alias of another motor was accepted: %+v", response)
+	}
+	// Only staff manage the catalog
+	user := testToken(t, authz.CustomClaims{User: "user", Scope: "read write", Btrs: []string{"test-123-a"}})
+	response = serveTokenRequest(t, r, "PUT", "/motors", user, MotorInfo{Mne: "samx", Units: "um", Beamline: "3a"})
+	if response.HttpCode != http.StatusForbidden {
+		t.Errorf("Editing motors without the staff scope returned %+v", response)
+	}
+	response = serveTokenRequest(t, r, "PUT", "/variables", user, VariableInfo{Name: "mode", Type: VariableString})
+	if response.HttpCode != http.StatusForbidden {
+		t.Errorf("Declaring a variable without the staff scope returned %+v", response)
+	}
+	response = serveTestRequest(t, r, "GET", "/motors?mne=samx", nil)
+	if response.Results.NRecords != 1 || response.Results.Records[0]["units"] != "mm" {
+		t.Errorf("Getting motor samx returned %+v", response.Results.Records)
+	}
+
+	found := searchTestService(t, r, `{"motors.sample_x": 1.5}`)
+	if len(found) != 1 {
+		t.Fatalf("Search on a motor alias found %d records; want 1", len(found))
+	}
+
+	request := services.ServiceRequest{ServiceQuery: services.ServiceQuery{Query: `{"scan_number": 1}`}}
+	response = serveTestRequest(t, r, "POST", "/search?units=true", request)
+	if response.Results.NRecords != 1 {
+		t.Fatalf("Search with units found %d records; want 1", response.Results.NRecords)
+	}
+	units, ok := response.Results.Records[0]["motor_units"].(map[string]any)
+	if !ok || units["samx"] != "mm" || len(units) != 1 {
+		t.Errorf("Search with units returned motor_units %v", response.Results.Records[0]["motor_units"])
+	}
+}
+
+// TestVariableRegistry tests registering variables as records are ingested,
+// declaring them, and searching on numeric variables
+func TestVariableRegistry(t *testing.T) {
+	r := SetupTestService(t)
+	record := testUserRecord(1, 1709647200, map[string]float64{"samx": 1.5})
+	record.Variables = map[string]any{
+		"ring_current": map[string]any{"value": 100.0, "units": "mA"},
+		"sample_temp":  "295.5",
+		"mode":         "hybrid",
+	}
+	response := serveTestRequest(t, r, "POST", "/add", record)
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding record failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "GET", "/variables", nil)
+	types := make(map[string]string)
+	for _, info := range response.Results.Records {
+		types[info["name"].(string)] = info["type"].(string)
+	}
+	if len(types) != 3 || types["ring_current"] != VariableNumber || types["sample_temp"] != VariableString {
+		t.Errorf("Variable registry has types %v", types)
+	}
+	response = serveTestRequest(t, r, "GET", "/variables?name=ring_current", nil)
+	if response.Results.NRecords != 1 || response.Results.Records[0]["units"] != "mA" {
+		t.Errorf("Getting variable ring_current returned %+v", response.Results.Records)
+	}
+	found := searchTestService(t, r, `{"scan_number": 1}`)
+	if len(found) != 1 || found[0]["variables"].(map[string]any)["ring_current"] != 100.0 {
+		t.Errorf("Units were not removed from the value of ring_current: %+v", found)
+	}
+
+	response = serveTestRequest(t, r, "PUT", "/variables", VariableInfo{Name: "mode", Type: VariableString, Stored: true})
+	if response.SrvCode == services.OK {
+		t.Errorf("Stored string variable was accepted: %+v", response)
+	}
+	declaration := VariableInfo{Name: "sample_temp", Type: VariableNumber, Units: "K", Indexed: true, Stored: true}
+	response = serveTestRequest(t, r, "PUT", "/variables", declaration)
+	if response.SrvCode != services.OK || response.Results.NRecords != 1 || response.Results.Records[0]["declared"] != true {
+		t.Fatalf("Declaring sample_temp failed: %+v", response)
+	}
+	if indexes := ScanDocs.(*MemoryDocumentStore).indexes; len(indexes) != 1 || indexes[0] != "variables.sample_temp" {
+		t.Errorf("Declaring an indexed variable created indexes %v", indexes)
+	}
+
+	record = testUserRecord(2, 1709647300, map[string]float64{"samx": 2.5})
+	record.Variables = map[string]any{"ring_current": 50.0, "sample_temp": "300.25"}
+	response = serveTestRequest(t, r, "POST", "/add", record)
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding record failed: %+v", response)
+	}
+	sid := response.Results.Records[0]["sid"].(string)
+	if values := ScanVariables.(*MemoryVariableStore).values[sid]; values["sample_temp"] != 300.25 {
+		t.Errorf("Stored variable values of record 2 are %v", values)
+	}
+	record = testUserRecord(3, 1709647400, map[string]float64{"samx": 3.5})
+	record.Variables = map[string]any{"sample_temp": "hot"}
+	response = serveTestRequest(t, r, "POST", "/add", record)
+	if response.SrvCode == services.OK {
+		t.Errorf("Non-numeric value of a numeric variable was accepted: %+v", response)
+	}
+
+	for query, expected := range map[string]int{
+		`{"variables.sample_temp": "300..301"}`:          1,
+		`{"variables.sample_temp": 300.25}`:              1,
+		`{"variables.ring_current": {"$gt": "75"}}`:      1,
+		`{"variables.ring_current": "40.."}`:             2,
+		`{"variables.mode": "hybrid", "scan_number": 1}`: 1,
+	} {
+		if found := searchTestService(t, r, query); len(found) != expected {
+			t.Errorf("Search for %s found %d records; want %d", query, len(found), expected)
+		}
+	}
+}
+
+// Test that editing the beamline of a record moves its motor positions to the
+// motors of the new beamline
+func TestEditBeamline(t *testing.T) {
+	r := SetupTestService(t)
+	response := serveTestRequest(t, r, "POST", "/add", testUserRecord(1, 1709647200, map[string]float64{"samx": 1.5}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding record failed: %+v", response)
+	}
+	sid := response.Results.Records[0]["sid"].(string)
+	edit := map[string]any{
+		"sid":      sid,
+		"beamline": "1b",
+		"did":      "/beamline=1b/btr=test-123-a/cycle=2024-1/sample_name=sample",
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", edit)
+	if response.SrvCode != services.OK {
+		t.Fatalf("Editing the beamline failed: %+v", response)
+	}
+	for query, expected := range map[string]int{
+		`{"beamline": "1b", "motors.samx": 1.5}`: 1,
+		`{"beamline": "3a", "motors.samx": 1.5}`: 0,
+	} {
+		if found := searchTestService(t, r, query); len(found) != expected {
+			t.Errorf("Search %s found %d records; want %d", query, len(found), expected)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -15,6 +16,16 @@ func main() {
 	cfile := os.Getenv("FOXDEN_CONFIG")
 	var config string
 	flag.StringVar(&config, "config", cfile, "server config file, default $FOXDEN_CONFIG")
+	var migrate string
+	flag.StringVar(&migrate, "migrate", "", "migrate the motors database schema to the given version (or \"latest\") and exit")
+	var reconcile string
+	flag.StringVar(&reconcile, "reconcile", "", "check consistency of the scan document and motor stores and exit; mode is report, repair or quarantine")
+	flag.StringVar(&SidStrategy, "sid-strategy", SidTime, "strategy for the scan ids of new records: time, beamline-time, uuid7 or hash")
+	var migrateSids string
+	flag.StringVar(&migrateSids, "migrate-sids", "", "rewrite the scan ids of existing records in both stores with the given strategy and exit")
+	flag.IntVar(&GrpcPort, "grpc-port", 0, "port of the gRPC server next to the HTTP server (none if 0)")
+	var purgeSandbox bool
+	flag.BoolVar(&purgeSandbox, "purge-sandbox", false, "remove all records from the sandbox and exit")
 	flag.Parse()
 	if version {
 		fmt.Println("server version:", srvConfig.Info())
@@ -29,5 +40,53 @@ func main() {
 		log.SetFlags(log.Llongfile)
 	}
 
+	if err := ValidateSidStrategy(SidStrategy); err != nil {
+		log.Fatal(err)
+	}
+
+	if migrate != "" {
+		InitDocumentStores()
+		err := RunMigrations(migrate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if reconcile != "" {
+		InitDocumentStores()
+		InitMotorsDb()
+		report, err := Reconcile(reconcile, ScanDocs, ScanMotors, QuarantineDocs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	if migrateSids != "" {
+		InitDocumentStores()
+		InitMotorsDb()
+		migration, err := MigrateScanIds(migrateSids, ScanDocs, ScanMotors)
+		if err != nil {
+			log.Fatal(err)
+		}
+		data, _ := json.MarshalIndent(migration, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	if purgeSandbox {
+		InitDocumentStores()
+		InitSandbox()
+		stores, err := sandboxStores()
+		if err != nil {
+			log.Fatal(err)
+		}
+		purged, err := PurgeRecords(stores, map[string]any{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Purged %d sandbox records\n", purged)
+		return
+	}
 	Server()
 }
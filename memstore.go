@@ -0,0 +1,837 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryDocumentStore is a DocumentStore kept in memory. It supports the
+// subset of MongoDB query and update syntax used by this service, and is
+// meant for tests and for running the service without a MongoDB.
+type MemoryDocumentStore struct {
+	mu        sync.RWMutex
+	documents []map[string]any
+	indexes   []string
+}
+
+func NewMemoryDocumentStore() *MemoryDocumentStore {
+	return &MemoryDocumentStore{}
+}
+
+func (s *MemoryDocumentStore) Insert(records ...map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range records {
+		var document map[string]any
+		err := normalizeDocument(record, &document)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Insert] normalizeDocument error: %w", err)
+		}
+		s.documents = append(s.documents, document)
+	}
+	return nil
+}
+
+func (s *MemoryDocumentStore) Get(spec map[string]any, idx int, limit int) ([]map[string]any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	matches, err := s.match(spec)
+	if err != nil {
+		return nil, fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Get] match error: %w", err)
+	}
+	records := []map[string]any{}
+	for i, document := range matches {
+		if i < idx {
+			continue
+		}
+		if limit > 0 && len(records) == limit {
+			break
+		}
+		var record map[string]any
+		err = normalizeDocument(document, &record)
+		if err != nil {
+			return nil, fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Get] normalizeDocument error: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *MemoryDocumentStore) Count(spec map[string]any) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	matches, err := s.match(spec)
+	if err != nil {
+		return 0, fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Count] match error: %w", err)
+	}
+	return len(matches), nil
+}
+
+func (s *MemoryDocumentStore) Update(spec map[string]any, update map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matches, err := s.match(spec)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Update] match error: %w", err)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Update] %w: %v", ErrScanNotFound, spec)
+	}
+	var normalized_update map[string]any
+	err = normalizeDocument(update, &normalized_update)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Update] normalizeDocument error: %w", err)
+	}
+	err = applyUpdate(matches[0], normalized_update)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Update] applyUpdate error: %w", err)
+	}
+	return nil
+}
+
+func (s *MemoryDocumentStore) Remove(spec map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var normalized_spec map[string]any
+	err := normalizeDocument(spec, &normalized_spec)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Remove] normalizeDocument error: %w", err)
+	}
+	var kept []map[string]any
+	for _, document := range s.documents {
+		ok, err := matchDocument(document, normalized_spec)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.MemoryDocumentStore.Remove] matchDocument error: %w", err)
+		}
+		if !ok {
+			kept = append(kept, document)
+		}
+	}
+	s.documents = kept
+	return nil
+}
+
+// Return the stored documents (not copies) matching spec, in insertion order
+func (s *MemoryDocumentStore) match(spec map[string]any) ([]map[string]any, error) {
+	var normalized_spec map[string]any
+	err := normalizeDocument(spec, &normalized_spec)
+	if err != nil {
+		return nil, err
+	}
+	var matches []map[string]any
+	for _, document := range s.documents {
+		ok, err := matchDocument(document, normalized_spec)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, document)
+		}
+	}
+	return matches, nil
+}
+
+// Copy a document through JSON, so that all numbers become float64, lists
+// become []any and nested documents become map[string]any, like values
+// decoded from MongoDB.
+func normalizeDocument(document any, normalized *map[string]any) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, normalized)
+}
+
+// Report whether a normalized document matches a MongoDB query spec
+func matchDocument(document map[string]any, spec map[string]any) (bool, error) {
+	for key, condition := range spec {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(document, key, condition)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query operator %s", key)
+			}
+			value, exists := lookupPath(document, key)
+			ok, err = matchCondition(value, exists, condition)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(document map[string]any, op string, condition any) (bool, error) {
+	subqueries, ok := condition.([]any)
+	if !ok {
+		return false, fmt.Errorf("%s requires a list of queries", op)
+	}
+	for _, subquery := range subqueries {
+		subspec, ok := subquery.(map[string]any)
+		if !ok {
+			return false, fmt.Errorf("%s requires a list of queries", op)
+		}
+		matched, err := matchDocument(document, subspec)
+		if err != nil {
+			return false, err
+		}
+		if op == "$and" && !matched {
+			return false, nil
+		}
+		if op == "$or" && matched {
+			return true, nil
+		}
+		if op == "$nor" && matched {
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+// Report whether the value of a document field matches a query condition:
+// either a literal value or a map of query operators.
+func matchCondition(value any, exists bool, condition any) (bool, error) {
+	operators, ok := condition.(map[string]any)
+	if !ok || !hasOperators(operators) {
+		return exists && matchEqual(value, condition), nil
+	}
+	for op, operand := range operators {
+		var matched bool
+		switch op {
+		case "$eq":
+			matched = exists && matchEqual(value, operand)
+		case "$ne":
+			matched = !exists || !matchEqual(value, operand)
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = exists && matchCompare(value, op, operand)
+		case "$in", "$nin":
+			list, ok := operand.([]any)
+			if !ok {
+				return false, fmt.Errorf("%s requires a list", op)
+			}
+			matched = false
+			for _, item := range list {
+				if exists && matchEqual(value, item) {
+					matched = true
+					break
+				}
+			}
+			if op == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			want, ok := operand.(bool)
+			if !ok {
+				return false, errors.New("$exists requires a boolean")
+			}
+			matched = exists == want
+		case "$regex":
+			options, _ := operators["$options"].(string)
+			pattern, ok := operand.(string)
+			if !ok {
+				return false, errors.New("$regex requires a string")
+			}
+			if strings.Contains(options, "i") {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, err
+			}
+			matched = exists && matchRegex(value, re)
+		case "$options":
+			matched = true
+		case "$not":
+			sub, err := matchCondition(value, exists, operand)
+			if err != nil {
+				return false, err
+			}
+			matched = !sub
+		default:
+			return false, fmt.Errorf("unsupported query operator %s", op)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func hasOperators(condition map[string]any) bool {
+	for key := range condition {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// Equality as in MongoDB: a list field matches if it equals the value or
+// contains it
+func matchEqual(value any, target any) bool {
+	if reflect.DeepEqual(value, target) {
+		return true
+	}
+	if list, ok := value.([]any); ok {
+		for _, item := range list {
+			if reflect.DeepEqual(item, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchCompare(value any, op string, target any) bool {
+	if list, ok := value.([]any); ok {
+		for _, item := range list {
+			if matchCompare(item, op, target) {
+				return true
+			}
+		}
+		return false
+	}
+	var cmp int
+	switch v := value.(type) {
+	case float64:
+		t, ok := target.(float64)
+		if !ok {
+			return false
+		}
+		cmp = compareFloats(v, t)
+	case string:
+		t, ok := target.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(v, t)
+	default:
+		return false
+	}
+	switch op {
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	case "$lt":
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func matchRegex(value any, re *regexp.Regexp) bool {
+	switch v := value.(type) {
+	case string:
+		return re.MatchString(v)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Look up a possibly dotted key (e.g. "variables.ring_current") in a document.
+// As in MongoDB, a key in a list of documents (e.g. "status_history.status")
+// gives the list of its values in those documents.
+func lookupPath(document map[string]any, path string) (any, bool) {
+	var value any = document
+	for _, key := range strings.Split(path, ".") {
+		switch nested := value.(type) {
+		case map[string]any:
+			var ok bool
+			value, ok = nested[key]
+			if !ok {
+				return nil, false
+			}
+		case []any:
+			var values []any
+			for _, item := range nested {
+				if item_map, ok := item.(map[string]any); ok {
+					if item_value, ok := item_map[key]; ok {
+						values = append(values, item_value)
+					}
+				}
+			}
+			if len(values) == 0 {
+				return nil, false
+			}
+			value = values
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Apply a MongoDB update document ($set, $unset and $push operators) to a
+// normalized document in place
+func applyUpdate(document map[string]any, update map[string]any) error {
+	for op, fields := range update {
+		field_map, ok := fields.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s requires a document", op)
+		}
+		keys := make([]string, 0, len(field_map))
+		for key := range field_map {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			parent, last := parentDocument(document, key)
+			switch op {
+			case "$set":
+				parent[last] = field_map[key]
+			case "$unset":
+				delete(parent, last)
+			case "$push":
+				list, _ := parent[last].([]any)
+				parent[last] = append(list, field_map[key])
+			default:
+				return fmt.Errorf("unsupported update operator %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+// Return the (possibly newly created) document holding the last component
+// of a dotted key, along with that component
+func parentDocument(document map[string]any, path string) (map[string]any, string) {
+	keys := strings.Split(path, ".")
+	parent := document
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := parent[key].(map[string]any)
+		if !ok {
+			nested = make(map[string]any)
+			parent[key] = nested
+		}
+		parent = nested
+	}
+	return parent, keys[len(keys)-1]
+}
+
+// Indexes are only recorded, since documents are always scanned
+func (s *MemoryDocumentStore) EnsureIndex(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !inList(key, s.indexes) {
+		s.indexes = append(s.indexes, key)
+	}
+	return nil
+}
+
+// MemoryMotorStore is a MotorStore kept in memory, with the same query
+// semantics as the SQL motor positions database.
+type MemoryMotorStore struct {
+	mu        sync.RWMutex
+	nextId    int64
+	scans     map[string]int64
+	beamlines map[string]string
+	motors    map[string]map[string]float64
+	updated   map[string]scanUpdate
+	deleted   map[string]float64
+	info      map[motorKey]MotorInfo
+	aliases   map[motorKey]string
+}
+
+// Who last changed a scan's record, and when
+type scanUpdate struct {
+	By Identity
+	At float64
+}
+
+func NewMemoryMotorStore() *MemoryMotorStore {
+	return &MemoryMotorStore{
+		scans:     make(map[string]int64),
+		beamlines: make(map[string]string),
+		motors:    make(map[string]map[string]float64),
+		updated:   make(map[string]scanUpdate),
+		deleted:   make(map[string]float64),
+		info:      make(map[motorKey]MotorInfo),
+		aliases:   make(map[motorKey]string),
+	}
+}
+
+func (s *MemoryMotorStore) InsertMotors(r MotorRecord) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.scans[r.ScanId]; ok {
+		return -1, fmt.Errorf("[SpecScansService.main.MemoryMotorStore.InsertMotors] scan id %s already exists", r.ScanId)
+	}
+	s.nextId++
+	s.scans[r.ScanId] = s.nextId
+	s.beamlines[r.ScanId] = r.Beamline
+	motors := make(map[string]float64, len(r.Motors))
+	for mne, pos := range r.Motors {
+		motors[mne] = pos
+	}
+	s.motors[r.ScanId] = motors
+	s.updated[r.ScanId] = scanUpdate{By: r.CreatedBy, At: r.CreatedAt}
+	return s.nextId, nil
+}
+
+// Return the motor records of scans whose id is in query.Sids or which match
+// any of the motor position queries. As with the SQL database, scans without
+// any motor positions are never returned.
+func (s *MemoryMotorStore) QueryMotors(query MotorsDbQuery) ([]MotorRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var motor_records []MotorRecord
+	for _, sid := range s.sortedScanIds() {
+		motors := s.motors[sid]
+		if len(motors) == 0 || (query.ExcludeDeleted && s.deleted[sid] != 0) {
+			continue
+		}
+		if inList(sid, query.Sids) || matchPositionQueries(motors, s.beamlines[sid], query.MotorPositionQueries) {
+			record := MotorRecord{ScanId: sid, Beamline: s.beamlines[sid], Motors: make(map[string]float64, len(motors))}
+			for mne, pos := range motors {
+				record.Motors[mne] = pos
+			}
+			motor_records = append(motor_records, record)
+		}
+	}
+	return motor_records, nil
+}
+
+// Return the ids of all scans in insertion order
+func (s *MemoryMotorStore) sortedScanIds() []string {
+	var sids []string
+	for sid := range s.scans {
+		sids = append(sids, sid)
+	}
+	sort.Slice(sids, func(i, j int) bool { return s.scans[sids[i]] < s.scans[sids[j]] })
+	return sids
+}
+
+func (s *MemoryMotorStore) ForEachScan(fn func(sid string, beamline string) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sid := range s.sortedScanIds() {
+		if err := fn(sid, s.beamlines[sid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMotorStore) RemoveMotors(sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scans, sid)
+	delete(s.beamlines, sid)
+	delete(s.motors, sid)
+	delete(s.updated, sid)
+	delete(s.deleted, sid)
+	return nil
+}
+
+func (s *MemoryMotorStore) RenameScan(sid string, new_sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.scans[sid]; !ok {
+		return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.RenameScan] unknown scan id %s", sid)
+	}
+	if _, ok := s.scans[new_sid]; ok {
+		return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.RenameScan] scan id %s already exists", new_sid)
+	}
+	s.scans[new_sid], s.beamlines[new_sid], s.motors[new_sid] = s.scans[sid], s.beamlines[sid], s.motors[sid]
+	s.updated[new_sid], s.deleted[new_sid] = s.updated[sid], s.deleted[sid]
+	delete(s.scans, sid)
+	delete(s.beamlines, sid)
+	delete(s.motors, sid)
+	delete(s.updated, sid)
+	delete(s.deleted, sid)
+	return nil
+}
+
+func (s *MemoryMotorStore) SetScanBeamline(sid string, beamline string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.scans[sid]; !ok {
+		return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.SetScanBeamline] unknown scan id %s", sid)
+	}
+	s.beamlines[sid] = beamline
+	return nil
+}
+
+func (s *MemoryMotorStore) SetScanUpdated(sid string, by Identity, at float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.scans[sid]; ok {
+		s.updated[sid] = scanUpdate{By: by, At: at}
+	}
+	return nil
+}
+
+func (s *MemoryMotorStore) SetScanDeleted(sid string, at float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.scans[sid]; !ok {
+		return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.SetScanDeleted] scan id %s not found", sid)
+	}
+	if at == 0 {
+		delete(s.deleted, sid)
+	} else {
+		s.deleted[sid] = at
+	}
+	return nil
+}
+
+// Return the keys of all motors, sorted by beamline and mnemonic
+func (s *MemoryMotorStore) motorKeys() []motorKey {
+	known := make(map[motorKey]bool)
+	for key := range s.info {
+		known[key] = true
+	}
+	for sid, motors := range s.motors {
+		for mne := range motors {
+			known[motorKey{s.beamlines[sid], mne}] = true
+		}
+	}
+	var keys []motorKey
+	for key := range known {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Beamline != keys[j].Beamline {
+			return keys[i].Beamline < keys[j].Beamline
+		}
+		return keys[i].Mne < keys[j].Mne
+	})
+	return keys
+}
+
+// Report whether mne is the mnemonic of a motor of a beamline
+func (s *MemoryMotorStore) isMnemonic(beamline string, mne string) bool {
+	if _, ok := s.info[motorKey{beamline, mne}]; ok {
+		return true
+	}
+	for sid, motors := range s.motors {
+		if _, ok := motors[mne]; ok && s.beamlines[sid] == beamline {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryMotorStore) GetMotorInfo(beamlines []string, mnes []string) ([]MotorInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := []MotorInfo{}
+	for _, key := range s.motorKeys() {
+		if (len(beamlines) > 0 && !inList(key.Beamline, beamlines)) || (len(mnes) > 0 && !inList(key.Mne, mnes)) {
+			continue
+		}
+		info, ok := s.info[key]
+		if !ok {
+			info = MotorInfo{Mne: key.Mne, Beamline: key.Beamline}
+		}
+		info.Aliases = sortedAliases(info.Aliases)
+		if len(info.Aliases) == 0 {
+			info.Aliases = nil
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *MemoryMotorStore) SetMotorInfo(info MotorInfo) error {
+	err := info.Validate()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.SetMotorInfo] %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, alias := range info.Aliases {
+		if s.isMnemonic(info.Beamline, alias) {
+			return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.SetMotorInfo] alias %s of motor %s is a motor mnemonic", alias, info.Mne)
+		}
+		if mne, ok := s.aliases[motorKey{info.Beamline, alias}]; ok && mne != info.Mne {
+			return fmt.Errorf("[SpecScansService.main.MemoryMotorStore.SetMotorInfo] alias %s of motor %s is an alias of another motor", alias, info.Mne)
+		}
+	}
+	key := motorKey{info.Beamline, info.Mne}
+	for _, alias := range s.info[key].Aliases {
+		delete(s.aliases, motorKey{info.Beamline, alias})
+	}
+	for _, alias := range info.Aliases {
+		s.aliases[motorKey{info.Beamline, alias}] = info.Mne
+	}
+	info.Aliases = sortedAliases(info.Aliases)
+	s.info[key] = info
+	return nil
+}
+
+func (s *MemoryMotorStore) ResolveAliases(beamlines []string, names ...string) ([]MotorAlias, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var aliases []MotorAlias
+	for key, mne := range s.aliases {
+		if !inList(key.Mne, names) || (len(beamlines) > 0 && !inList(key.Beamline, beamlines)) ||
+			s.isMnemonic(key.Beamline, key.Mne) {
+			continue
+		}
+		aliases = append(aliases, MotorAlias{Alias: key.Mne, Beamline: key.Beamline, Mne: mne})
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		if aliases[i].Beamline != aliases[j].Beamline {
+			return aliases[i].Beamline < aliases[j].Beamline
+		}
+		return aliases[i].Alias < aliases[j].Alias
+	})
+	return aliases, nil
+}
+
+// Report whether any of the position queries matches the motors of a scan at
+// a beamline, following the conditions in query_motorsdb.sql (zero bounds are
+// treated as unset).
+func matchPositionQueries(motors map[string]float64, beamline string, queries []MotorPositionQuery) bool {
+	for _, q := range queries {
+		pos, ok := motors[q.Mne]
+		if !ok || (q.Beamlines != nil && !inList(beamline, q.Beamlines)) {
+			continue
+		}
+		if len(q.Exact) == 0 && q.Min == 0 && q.Max == 0 {
+			return true
+		}
+		if q.Min != 0 && q.Max != 0 && pos >= q.Min && pos <= q.Max {
+			return true
+		}
+		if q.Min != 0 && q.Max == 0 && pos > q.Min {
+			return true
+		}
+		if q.Min == 0 && q.Max != 0 && pos < q.Max {
+			return true
+		}
+		for _, exact := range q.Exact {
+			if pos == exact {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MemoryVariableStore is a VariableStore kept in memory
+type MemoryVariableStore struct {
+	mu     sync.RWMutex
+	info   map[string]VariableInfo
+	values map[string]map[string]float64
+}
+
+func NewMemoryVariableStore() *MemoryVariableStore {
+	return &MemoryVariableStore{
+		info:   make(map[string]VariableInfo),
+		values: make(map[string]map[string]float64),
+	}
+}
+
+func (s *MemoryVariableStore) GetVariableInfo(names []string) ([]VariableInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := []VariableInfo{}
+	for name, info := range s.info {
+		if len(names) == 0 || inList(name, names) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (s *MemoryVariableStore) ObserveVariables(observed []VariableInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range observed {
+		info, ok := s.info[o.Name]
+		if !ok {
+			info = VariableInfo{Name: o.Name}
+		}
+		s.info[o.Name] = observeVariable(info, o)
+	}
+	return nil
+}
+
+func (s *MemoryVariableStore) SetVariableInfo(info VariableInfo) error {
+	err := info.Validate()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MemoryVariableStore.SetVariableInfo] %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info.Declared = true
+	s.info[info.Name] = info
+	return nil
+}
+
+func (s *MemoryVariableStore) SetVariableValues(sid string, values map[string]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range values {
+		if _, ok := s.info[name]; !ok {
+			return fmt.Errorf("[SpecScansService.main.MemoryVariableStore.SetVariableValues] unknown variable %s", name)
+		}
+	}
+	s.values[sid] = make(map[string]float64, len(values))
+	for name, value := range values {
+		s.values[sid][name] = value
+	}
+	return nil
+}
+
+// MemoryEmbargoStore is an EmbargoStore kept in memory
+type MemoryEmbargoStore struct {
+	mu        sync.RWMutex
+	embargoes map[string]float64
+}
+
+func NewMemoryEmbargoStore() *MemoryEmbargoStore {
+	return &MemoryEmbargoStore{embargoes: make(map[string]float64)}
+}
+
+func (s *MemoryEmbargoStore) GetEmbargoes(btrs []string, after float64) ([]Embargo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	embargoes := []Embargo{}
+	for btr, until := range s.embargoes {
+		if until > after && (len(btrs) == 0 || inList(btr, btrs)) {
+			embargoes = append(embargoes, Embargo{Btr: btr, Until: until})
+		}
+	}
+	sort.Slice(embargoes, func(i, j int) bool { return embargoes[i].Btr < embargoes[j].Btr })
+	return embargoes, nil
+}
+
+func (s *MemoryEmbargoStore) SetEmbargo(embargo Embargo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if embargo.Until == 0 {
+		delete(s.embargoes, embargo.Btr)
+	} else {
+		s.embargoes[embargo.Btr] = embargo.Until
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Test the MongoDB query subset supported by MemoryDocumentStore
+func TestMemoryDocumentStore(t *testing.T) {
+	store := NewMemoryDocumentStore()
+	err := store.Insert(
+		map[string]any{"sid": "1", "scan_number": 1, "status": "completed", "comments": []string{"alpha"}, "variables": map[string]any{"T": 300.0}},
+		map[string]any{"sid": "2", "scan_number": 2, "status": "running", "comments": []string{"beta", "gamma"}},
+		map[string]any{"sid": "3", "scan_number": 3, "status": "aborted"},
+	)
+	if err != nil {
+		t.Fatalf("Insert error: %v", err)
+	}
+
+	tests := []struct {
+		spec     map[string]any
+		expected []string
+	}{
+		{spec: map[string]any{}, expected: []string{"1", "2", "3"}},
+		{spec: map[string]any{"scan_number": 2}, expected: []string{"2"}},
+		{spec: map[string]any{"scan_number": map[string]any{"$gte": 2, "$lt": 3}}, expected: []string{"2"}},
+		{spec: map[string]any{"sid": map[string]any{"$in": []string{"1", "3"}}}, expected: []string{"1", "3"}},
+		{spec: map[string]any{"status": map[string]any{"$ne": "running"}}, expected: []string{"1", "3"}},
+		{spec: map[string]any{"comments": "gamma"}, expected: []string{"2"}},
+		{spec: map[string]any{"comments": map[string]any{"$regex": "^ALP", "$options": "i"}}, expected: []string{"1"}},
+		{spec: map[string]any{"comments": map[string]any{"$exists": false}}, expected: []string{"3"}},
+		{spec: map[string]any{"variables.T": map[string]any{"$gt": 273.15}}, expected: []string{"1"}},
+		{
+			spec:     map[string]any{"$or": []map[string]any{{"scan_number": 1}, {"status": "aborted"}}},
+			expected: []string{"1", "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			records, err := store.Get(tt.spec, 0, 0)
+			if err != nil {
+				t.Fatalf("Get(%v) error: %v", tt.spec, err)
+			}
+			var sids []string
+			for _, record := range records {
+				sids = append(sids, record["sid"].(string))
+			}
+			if len(sids) != len(tt.expected) {
+				t.Fatalf("Get(%v) = %v; want %v", tt.spec, sids, tt.expected)
+			}
+			for i := range sids {
+				if sids[i] != tt.expected[i] {
+					t.Errorf("Get(%v) = %v; want %v", tt.spec, sids, tt.expected)
+				}
+			}
+		})
+	}
+
+	err = store.Update(map[string]any{"sid": "2"}, map[string]any{
+		"$set":  map[string]any{"status": "completed", "variables.T": 4.2},
+		"$push": map[string]any{"comments": "delta"},
+	})
+	if err != nil {
+		t.Fatalf("Update error: %v", err)
+	}
+	n, _ := store.Count(map[string]any{"status": "completed", "variables.T": 4.2, "comments": "delta"})
+	if n != 1 {
+		t.Errorf("Update was not applied")
+	}
+
+	err = store.Remove(map[string]any{"status": "completed"})
+	if err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	n, _ = store.Count(map[string]any{})
+	if n != 1 {
+		t.Errorf("Remove left %d records; want 1", n)
+	}
+
+	// Updates do not insert documents which match nothing, but report it
+	err = store.Update(map[string]any{"sid": "2"}, map[string]any{"$set": map[string]any{"status": "failed"}})
+	if !errors.Is(err, ErrScanNotFound) {
+		t.Fatalf("Update of a removed record returned %v; want ErrScanNotFound", err)
+	}
+	if n, _ = store.Count(map[string]any{}); n != 1 {
+		t.Errorf("Update of a removed record left %d records; want 1", n)
+	}
+	// Specs are matched like those of Get, whatever the types of their values
+	err = store.Remove(map[string]any{"scan_number": 3})
+	if err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	if n, _ = store.Count(map[string]any{}); n != 0 {
+		t.Errorf("Remove by scan_number left %d records; want 0", n)
+	}
+}
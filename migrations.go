@@ -0,0 +1,349 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	sqldb "github.com/CHESSComputing/golib/sqldb"
+)
+
+// Version of the motors database schema this code works with. Increase it
+// whenever a new migration is added under static/sql/migrations.
+const MotorsDbSchemaVersion = 7
+
+// Version of the migration scoping motors by beamline. Scans recorded before
+// it have no beamline in the motors database; migrating across it moves them
+// to the beamlines of their documents.
+const BeamlineMotorsSchemaVersion = 3
+
+// Migration is a numbered change to the motors database schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Pattern of migration file names, e.g. 0001_initial.up.sql
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Return the directory holding the migrations for a dialect
+func MigrationsDir(dialect SQLDialect) string {
+	return path.Join(srvConfig.Config.SpecScans.WebServer.StaticDir, "sql", "migrations", string(dialect))
+}
+
+// Load the migrations found in dir, sorted by version. Every migration must
+// have both an up and a down file.
+func LoadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("[SpecScansService.main.LoadMigrations] os.ReadDir error: %w", err)
+	}
+	migrations := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("[SpecScansService.main.LoadMigrations] os.ReadFile error: %w", err)
+		}
+		m, ok := migrations[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			migrations[version] = m
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	var sorted []Migration
+	for _, m := range migrations {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("[SpecScansService.main.LoadMigrations] migration %d_%s needs both up and down files", m.Version, m.Name)
+		}
+		sorted = append(sorted, *m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("[SpecScansService.main.LoadMigrations] missing migration %d", i+1)
+		}
+	}
+	return sorted, nil
+}
+
+// Return the current version of the motors database schema (0 for an empty
+// database) without changing the database. Databases whose tables were
+// created by hand before migrations existed are at version 1, which
+// MigrateMotorsDb records.
+func SchemaVersion(db *sql.DB, dialect SQLDialect) (int, error) {
+	exists, err := dialect.TableExists(db, "schema_version")
+	if err != nil {
+		return 0, fmt.Errorf("[SpecScansService.main.SchemaVersion] dialect.TableExists error: %w", err)
+	}
+	if exists {
+		var version sql.NullInt64
+		err = db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+		if err != nil {
+			return 0, fmt.Errorf("[SpecScansService.main.SchemaVersion] db.QueryRow error: %w", err)
+		}
+		if version.Valid {
+			return int(version.Int64), nil
+		}
+	}
+	exists, err = dialect.TableExists(db, "ScanIds")
+	if err != nil {
+		return 0, fmt.Errorf("[SpecScansService.main.SchemaVersion] dialect.TableExists error: %w", err)
+	}
+	if exists {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Create the table of schema versions unless it exists, and record
+// databases whose tables were created by hand before migrations existed as
+// being at version 1
+func initSchemaVersion(db *sql.DB, dialect SQLDialect) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL PRIMARY KEY, applied_at BIGINT NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.initSchemaVersion] db.Exec error: %w", err)
+	}
+	var version sql.NullInt64
+	err = db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.initSchemaVersion] db.QueryRow error: %w", err)
+	}
+	if version.Valid {
+		return nil
+	}
+	exists, err := dialect.TableExists(db, "ScanIds")
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.initSchemaVersion] dialect.TableExists error: %w", err)
+	}
+	if exists {
+		log.Println("Motors database tables exist without a schema version; recording them as version 1")
+		_, err = db.Exec(dialect.Rebind("INSERT INTO schema_version (version, applied_at) VALUES (?, ?)"), 1, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.initSchemaVersion] db.Exec error: %w", err)
+		}
+	}
+	return nil
+}
+
+// Migrate the motors database schema up or down to the target version, one
+// migration per transaction. A failed migration is rolled back on SQLite and
+// PostgreSQL, but MySQL commits each schema change implicitly, so there a
+// failed migration may leave the schema partly changed: its applied
+// statements must be reverted by hand before migrating again.
+func MigrateMotorsDb(db *sql.DB, dialect SQLDialect, migrations []Migration, target int) error {
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("[SpecScansService.main.MigrateMotorsDb] unknown schema version %d", target)
+	}
+	err := initSchemaVersion(db, dialect)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MigrateMotorsDb] initSchemaVersion error: %w", err)
+	}
+	current, err := SchemaVersion(db, dialect)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MigrateMotorsDb] SchemaVersion error: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("[SpecScansService.main.MigrateMotorsDb] database schema version %d is newer than the known migrations", current)
+	}
+	for current < target {
+		m := migrations[current]
+		log.Printf("Applying motors database migration %d_%s", m.Version, m.Name)
+		err = applyMigration(db, m.Up,
+			dialect.Rebind("INSERT INTO schema_version (version, applied_at) VALUES (?, ?)"), m.Version, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.MigrateMotorsDb] migration %d_%s up error: %w%s", m.Version, m.Name, err, partialMigrationHint(dialect))
+		}
+		current++
+	}
+	for current > target {
+		m := migrations[current-1]
+		log.Printf("Reverting motors database migration %d_%s", m.Version, m.Name)
+		err = applyMigration(db, m.Down,
+			dialect.Rebind("DELETE FROM schema_version WHERE version = ?"), m.Version)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.MigrateMotorsDb] migration %d_%s down error: %w%s", m.Version, m.Name, err, partialMigrationHint(dialect))
+		}
+		current--
+	}
+	return nil
+}
+
+// Return the note added to the errors of failed migrations in databases
+// which cannot roll back schema changes
+func partialMigrationHint(dialect SQLDialect) string {
+	if dialect != MySQLDialect {
+		return ""
+	}
+	return "; MySQL commits schema changes implicitly, so the statements of the migration applied before the error must be reverted by hand before migrating again"
+}
+
+// Run the statements of a migration script followed by the statement
+// recording the new schema version, in a single transaction. The transaction
+// only makes the migration all-or-nothing in databases with transactional
+// schema changes (SQLite and PostgreSQL, not MySQL).
+func applyMigration(db *sql.DB, script string, version_statement string, args ...any) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range splitStatements(script) {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("%w (statement: %s)", err, statement)
+		}
+	}
+	_, err = tx.Exec(version_statement, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Split an SQL script into its statements, dropping "--" comment lines
+func splitStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	var statements []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		statement = strings.TrimSpace(statement)
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// Check that the motors database schema is at the version this code works
+// with
+func CheckSchemaVersion(db *sql.DB, dialect SQLDialect) error {
+	version, err := SchemaVersion(db, dialect)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.CheckSchemaVersion] SchemaVersion error: %w", err)
+	}
+	if version != MotorsDbSchemaVersion {
+		return fmt.Errorf("[SpecScansService.main.CheckSchemaVersion] motors database schema is at version %d but version %d is required; run the service with -migrate", version, MotorsDbSchemaVersion)
+	}
+	return nil
+}
+
+// Migrate the motors database given in the configuration, and the sandbox
+// database if it is configured, to the target version, either a number or
+// "latest". The document stores must be set up, for the beamlines of scans
+// to be filled in when migrating across BeamlineMotorsSchemaVersion.
+func RunMigrations(target string) error {
+	err := migrateDbFile(srvConfig.Config.SpecScans.DBFile, target, ScanDocs)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.RunMigrations] %w", err)
+	}
+	sandbox_dbfile := SandboxDBFile(srvConfig.Config.SpecScans.DBFile)
+	if _, err := os.Stat(sandbox_dbfile); err == nil {
+		err = migrateDbFile(sandbox_dbfile, target, SandboxDocumentStore())
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.RunMigrations] sandbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// Migrate the motors database described by dbfile, whose scan documents are
+// in docs, to the target version
+func migrateDbFile(dbfile string, target string, docs DocumentStore) error {
+	dbtype, dburi, _ := sqldb.ParseDBFile(dbfile)
+	dialect, driver, err := ParseSQLDialect(dbtype)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.migrateDbFile] ParseSQLDialect error: %w", err)
+	}
+	migrations, err := LoadMigrations(MigrationsDir(dialect))
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.migrateDbFile] LoadMigrations error: %w", err)
+	}
+	version := len(migrations)
+	if target != "latest" {
+		version, err = strconv.Atoi(target)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.migrateDbFile] invalid schema version %q", target)
+		}
+	}
+	db, err := sqldb.InitDB(driver, dburi)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.migrateDbFile] sqldb.InitDB error: %w", err)
+	}
+	defer db.Close()
+	current, err := SchemaVersion(db, dialect)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.migrateDbFile] SchemaVersion error: %w", err)
+	}
+	err = MigrateMotorsDb(db, dialect, migrations, version)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.migrateDbFile] MigrateMotorsDb error: %w", err)
+	}
+	log.Printf("Motors database %s schema is at version %d", dbfile, version)
+	if current < BeamlineMotorsSchemaVersion && version >= BeamlineMotorsSchemaVersion {
+		moved, err := BackfillScanBeamlines(docs, NewSQLMotorStore(db, dialect))
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.migrateDbFile] BackfillScanBeamlines error: %w; run the service with -reconcile repair to finish moving scans to their beamlines", err)
+		}
+		log.Printf("Moved %d scans of %s to the motors of their beamlines", moved, dbfile)
+	}
+	return nil
+}
+
+// Move the scans of the motor store which have no beamline to the beamlines
+// of their documents, and return the number of scans moved
+func BackfillScanBeamlines(docs DocumentStore, motors MotorStore) (int, error) {
+	var sids []string
+	err := motors.ForEachScan(func(sid string, beamline string) error {
+		if beamline == "" {
+			sids = append(sids, sid)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("[SpecScansService.main.BackfillScanBeamlines] motors.ForEachScan error: %w", err)
+	}
+	moved := 0
+	for i := 0; i < len(sids); i += ReconcileBatchSize {
+		batch := sids[i:min(i+ReconcileBatchSize, len(sids))]
+		records, err := docs.Get(map[string]any{"sid": map[string]any{"$in": anyList(batch)}}, 0, 0)
+		if err != nil {
+			return moved, fmt.Errorf("[SpecScansService.main.BackfillScanBeamlines] docs.Get error: %w", err)
+		}
+		for _, record := range records {
+			sid, _ := record["sid"].(string)
+			beamline, _ := record["beamline"].(string)
+			if sid == "" || beamline == "" {
+				continue
+			}
+			if err = motors.SetScanBeamline(sid, beamline); err != nil {
+				return moved, fmt.Errorf("[SpecScansService.main.BackfillScanBeamlines] motors.SetScanBeamline error: %w", err)
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	srvConfig "github.com/CHESSComputing/golib/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Test migrating a SQLite motors database up, down and up again
+func TestMigrateMotorsDb(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory SQLite database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrations, err := LoadMigrations("static/sql/migrations/sqlite3")
+	if err != nil {
+		t.Fatalf("LoadMigrations error: %v", err)
+	}
+	if len(migrations) != MotorsDbSchemaVersion {
+		t.Fatalf("found %d migrations; MotorsDbSchemaVersion is %d", len(migrations), MotorsDbSchemaVersion)
+	}
+	if err = CheckSchemaVersion(db, SQLiteDialect); err == nil {
+		t.Error("CheckSchemaVersion should fail on an empty database")
+	}
+
+	for _, target := range []int{len(migrations), 0, len(migrations)} {
+		err = MigrateMotorsDb(db, SQLiteDialect, migrations, target)
+		if err != nil {
+			t.Fatalf("MigrateMotorsDb(%d) error: %v", target, err)
+		}
+		version, err := SchemaVersion(db, SQLiteDialect)
+		if err != nil {
+			t.Fatalf("SchemaVersion error: %v", err)
+		}
+		if version != target {
+			t.Errorf("SchemaVersion = %d after migrating to %d", version, target)
+		}
+	}
+	if err = CheckSchemaVersion(db, SQLiteDialect); err != nil {
+		t.Error(err)
+	}
+	_, err = InsertMotors(MotorRecord{ScanId: "sid_1", Motors: map[string]float64{"mne0": 1.23}}, db, SQLiteDialect)
+	if err != nil {
+		t.Errorf("InsertMotors error after migrating: %v", err)
+	}
+}
+
+// Test that a database created before migrations existed is adopted at
+// version 1, when migrating it
+func TestSchemaVersionExistingTables(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory SQLite database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE ScanIds (scan_id INTEGER PRIMARY KEY AUTOINCREMENT, sid VARCHAR(50) NOT NULL UNIQUE)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := SchemaVersion(db, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("SchemaVersion error: %v", err)
+	}
+	if version != 1 {
+		t.Errorf("SchemaVersion = %d; want 1", version)
+	}
+	// Checking the version does not write to the database, migrating does
+	if exists, _ := SQLiteDialect.TableExists(db, "schema_version"); exists {
+		t.Error("SchemaVersion created the schema_version table")
+	}
+	migrations, err := LoadMigrations("static/sql/migrations/sqlite3")
+	if err != nil {
+		t.Fatalf("LoadMigrations error: %v", err)
+	}
+	if err = MigrateMotorsDb(db, SQLiteDialect, migrations, 1); err != nil {
+		t.Fatalf("MigrateMotorsDb(1) error: %v", err)
+	}
+	var stamped int
+	if err = db.QueryRow("SELECT COUNT(*) FROM schema_version WHERE version = 1").Scan(&stamped); err != nil || stamped != 1 {
+		t.Errorf("migrating recorded version 1 %d times: %v", stamped, err)
+	}
+}
+
+// Test that scoping motors by beamline keeps the motors, aliases and
+// positions of an existing database
+func TestMigrateBeamlineMotors(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory SQLite database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	migrations, err := LoadMigrations("static/sql/migrations/sqlite3")
+	if err != nil {
+		t.Fatalf("LoadMigrations error: %v", err)
+	}
+	if err = MigrateMotorsDb(db, SQLiteDialect, migrations, 2); err != nil {
+		t.Fatalf("MigrateMotorsDb(2) error: %v", err)
+	}
+	for _, statement := range []string{
+		"INSERT INTO ScanIds (scan_id, sid) VALUES (1, 'sid_1')",
+		"INSERT INTO MotorMnes (motor_id, motor_mne, units, beamline) VALUES (1, 'samx', 'mm', '3a'), (2, 'th', NULL, NULL)",
+		"INSERT INTO MotorAliases (alias, motor_id) VALUES ('x', 1)",
+		"INSERT INTO MotorPositions (scan_id, motor_id, motor_position) VALUES (1, 1, 1.5), (1, 2, 3.0)",
+	} {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, target := range []int{3, 2, 3} {
+		if err = MigrateMotorsDb(db, SQLiteDialect, migrations, target); err != nil {
+			t.Fatalf("MigrateMotorsDb(%d) error: %v", target, err)
+		}
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM MotorPositions AS P JOIN MotorMnes AS M ON M.motor_id = P.motor_id").Scan(&count)
+		if count != 2 {
+			t.Errorf("%d motor positions are left after migrating to %d; want 2", count, target)
+		}
+	}
+	infos, err := GetMotorInfo(nil, nil, db, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("GetMotorInfo error: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Beamline != "" || infos[0].Mne != "th" ||
+		infos[1].Beamline != "3a" || infos[1].Units != "mm" || len(infos[1].Aliases) != 1 {
+		t.Errorf("GetMotorInfo returned %+v after migrating", infos)
+	}
+	aliases, err := ResolveAliases([]string{"3a"}, []string{"x"}, db, SQLiteDialect)
+	if err != nil || len(aliases) != 1 || aliases[0].Mne != "samx" {
+		t.Errorf("ResolveAliases returned %v, %v after migrating", aliases, err)
+	}
+
+	// Scans recorded before motors were scoped get the beamline of their
+	// document
+	docs := NewMemoryDocumentStore()
+	if err = docs.Insert(map[string]any{"sid": "sid_1", "beamline": "3a"}); err != nil {
+		t.Fatal(err)
+	}
+	config := srvConfig.SrvConfig{}
+	config.SpecScans.WebServer.StaticDir = "static"
+	srvConfig.Config = &config
+	motors := NewSQLMotorStore(db, SQLiteDialect)
+	moved, err := BackfillScanBeamlines(docs, motors)
+	if err != nil || moved != 1 {
+		t.Fatalf("BackfillScanBeamlines returned %d, %v; want 1", moved, err)
+	}
+	query := MotorsDbQuery{MotorPositionQueries: []MotorPositionQuery{{Mne: "th", Beamlines: []string{"3a"}}}}
+	if found, err := motors.QueryMotors(query); err != nil || len(found) != 1 || found[0].Beamline != "3a" {
+		t.Errorf("found %+v, %v at beamline 3a after filling in beamlines", found, err)
+	}
+	if moved, err = BackfillScanBeamlines(docs, motors); err != nil || moved != 0 {
+		t.Errorf("BackfillScanBeamlines moved %d scans again, %v", moved, err)
+	}
+}
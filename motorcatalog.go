@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MotorInfo is the catalog entry of a motor
+type MotorInfo struct {
+	Mne         string   `json:"mne" mapstructure:"mne"`
+	Units       string   `json:"units,omitempty" mapstructure:"units"`
+	Description string   `json:"description,omitempty" mapstructure:"description"`
+	Beamline    string   `json:"beamline,omitempty" mapstructure:"beamline"`
+	Aliases     []string `json:"aliases,omitempty" mapstructure:"aliases"`
+}
+
+// Check that a catalog entry names its motor and has usable aliases
+func (info MotorInfo) Validate() error {
+	if info.Mne == "" {
+		return errors.New("motor catalog entry must have a \"mne\"")
+	}
+	for _, alias := range info.Aliases {
+		if alias == "" || alias == info.Mne {
+			return fmt.Errorf("invalid alias %q of motor %s", alias, info.Mne)
+		}
+	}
+	return nil
+}
+
+// MotorAlias is an alternative name of a beamline's motor
+type MotorAlias struct {
+	Alias    string
+	Beamline string
+	Mne      string
+}
+
+// Add the canonical mnemonics of motor names in position queries which are
+// aliases. An alias only applies at its own beamline, so each alias adds a
+// position query restricted to that beamline.
+func expandMotorAliases(motors MotorStore, query *MotorsDbQuery) error {
+	var names []string
+	for _, q := range query.MotorPositionQueries {
+		names = append(names, q.Mne)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	aliases, err := motors.ResolveAliases(nil, names...)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.expandMotorAliases] motors.ResolveAliases error: %w", err)
+	}
+	var position_queries []MotorPositionQuery
+	for _, q := range query.MotorPositionQueries {
+		position_queries = append(position_queries, q)
+		for _, alias := range aliases {
+			if alias.Alias != q.Mne || (q.Beamlines != nil && !inList(alias.Beamline, q.Beamlines)) {
+				continue
+			}
+			expanded := q
+			expanded.Mne = alias.Mne
+			expanded.Beamlines = []string{alias.Beamline}
+			position_queries = append(position_queries, expanded)
+		}
+	}
+	query.MotorPositionQueries = position_queries
+	return nil
+}
+
+// Return the units of the motors of the given records, by beamline and
+// mnemonic
+func motorUnits(motors MotorStore, records []UserRecord) (map[string]map[string]string, error) {
+	units := make(map[string]map[string]string)
+	mnes := make(map[string][]string)
+	for _, record := range records {
+		if _, ok := units[record.Beamline]; !ok {
+			units[record.Beamline] = make(map[string]string)
+		}
+		for mne := range record.Motors {
+			if _, ok := units[record.Beamline][mne]; !ok {
+				units[record.Beamline][mne] = ""
+				mnes[record.Beamline] = append(mnes[record.Beamline], mne)
+			}
+		}
+	}
+	for beamline := range mnes {
+		infos, err := motors.GetMotorInfo([]string{beamline}, mnes[beamline])
+		if err != nil {
+			return units, fmt.Errorf("[SpecScansService.main.motorUnits] motors.GetMotorInfo error: %w", err)
+		}
+		for _, info := range infos {
+			units[beamline][info.Mne] = info.Units
+		}
+	}
+	return units, nil
+}
+
+// Return the SQL condition restricting column to the given values (or no
+// condition if there are none), and its arguments
+func inCondition(column string, values []string) (string, []any) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return column + " IN " + Placeholders(1, len(values)), args
+}
+
+// Join SQL conditions to a WHERE clause
+func whereClause(conditions ...string) string {
+	var nonempty []string
+	for _, condition := range conditions {
+		if condition != "" {
+			nonempty = append(nonempty, condition)
+		}
+	}
+	if len(nonempty) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(nonempty, " AND ")
+}
+
+// Get the catalog entries of the given motors of the given beamlines (of all
+// motors or beamlines if none are given) from the motors database, sorted by
+// beamline and mnemonic
+func GetMotorInfo(beamlines []string, mnes []string, db *sql.DB, dialect SQLDialect) ([]MotorInfo, error) {
+	infos := []MotorInfo{}
+	beamline_condition, beamline_args := inCondition("M.beamline", beamlines)
+	mne_condition, mne_args := inCondition("M.motor_mne", mnes)
+	args := append(beamline_args, mne_args...)
+	where := whereClause(beamline_condition, mne_condition)
+	statement := "SELECT M.beamline, M.motor_mne, M.units, M.description FROM MotorMnes AS M" + where +
+		" ORDER BY M.beamline, M.motor_mne"
+	rows, err := db.Query(dialect.Rebind(statement), args...)
+	if err != nil {
+		return infos, fmt.Errorf("[SpecScansService.main.GetMotorInfo] db.Query error: %w", err)
+	}
+	defer rows.Close()
+	index := make(map[motorKey]int)
+	for rows.Next() {
+		var info MotorInfo
+		var units, description sql.NullString
+		err = rows.Scan(&info.Beamline, &info.Mne, &units, &description)
+		if err != nil {
+			return infos, fmt.Errorf("[SpecScansService.main.GetMotorInfo] rows.Scan error: %w", err)
+		}
+		info.Units, info.Description = units.String, description.String
+		index[motorKey{info.Beamline, info.Mne}] = len(infos)
+		infos = append(infos, info)
+	}
+	rows.Close()
+
+	statement = "SELECT M.beamline, M.motor_mne, A.alias FROM MotorAliases AS A JOIN MotorMnes AS M ON A.motor_id = M.motor_id" +
+		where + " ORDER BY A.alias"
+	rows, err = db.Query(dialect.Rebind(statement), args...)
+	if err != nil {
+		return infos, fmt.Errorf("[SpecScansService.main.GetMotorInfo] db.Query error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var beamline, mne, alias string
+		err = rows.Scan(&beamline, &mne, &alias)
+		if err != nil {
+			return infos, fmt.Errorf("[SpecScansService.main.GetMotorInfo] rows.Scan error: %w", err)
+		}
+		if i, ok := index[motorKey{beamline, mne}]; ok {
+			infos[i].Aliases = append(infos[i].Aliases, alias)
+		}
+	}
+	return infos, nil
+}
+
+// Create or replace the catalog entry of a beamline's motor in the motors
+// database. An alias may not be the mnemonic or the alias of another motor of
+// the same beamline.
+func SetMotorInfo(info MotorInfo, db *sql.DB, dialect SQLDialect) error {
+	err := info.Validate()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetMotorInfo] %w", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetMotorInfo] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+
+	motor_ids, err := insertMotorMnes(tx, dialect, info.Beamline, []string{info.Mne})
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetMotorInfo] insertMotorMnes error: %w", err)
+	}
+	motor_id := motor_ids[info.Mne]
+	_, err = tx.Exec(dialect.Rebind("UPDATE MotorMnes SET units = ?, description = ? WHERE motor_id = ?"),
+		nullString(info.Units), nullString(info.Description), motor_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetMotorInfo] tx.Exec error: %w", err)
+	}
+
+	if len(info.Aliases) > 0 {
+		alias_condition, alias_args := inCondition("motor_mne", info.Aliases)
+		var conflict string
+		err = tx.QueryRow(dialect.Rebind("SELECT motor_mne FROM MotorMnes WHERE beamline = ? AND "+alias_condition),
+			append([]any{info.Beamline}, alias_args...)...).Scan(&conflict)
+		if err == nil {
+			return fmt.Errorf("[SpecScansService.main.SetMotorInfo] alias %s of motor %s is a motor mnemonic", conflict, info.Mne)
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("[SpecScansService.main.SetMotorInfo] tx.QueryRow error: %w", err)
+		}
+		alias_condition, _ = inCondition("alias", info.Aliases)
+		err = tx.QueryRow(dialect.Rebind("SELECT alias FROM MotorAliases WHERE beamline = ? AND motor_id <> ? AND "+alias_condition),
+			append([]any{info.Beamline, motor_id}, alias_args...)...).Scan(&conflict)
+		if err == nil {
+			return fmt.Errorf("[SpecScansService.main.SetMotorInfo] alias %s of motor %s is an alias of another motor", conflict, info.Mne)
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("[SpecScansService.main.SetMotorInfo] tx.QueryRow error: %w", err)
+		}
+	}
+	_, err = tx.Exec(dialect.Rebind("DELETE FROM MotorAliases WHERE motor_id = ?"), motor_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetMotorInfo] tx.Exec error: %w", err)
+	}
+	if len(info.Aliases) > 0 {
+		var args []any
+		for _, alias := range info.Aliases {
+			args = append(args, info.Beamline, alias, motor_id)
+		}
+		statement := "INSERT INTO MotorAliases (beamline, alias, motor_id) VALUES " + Placeholders(len(info.Aliases), 3)
+		_, err = tx.Exec(dialect.Rebind(statement), args...)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.SetMotorInfo] tx.Exec error: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetMotorInfo] tx.Commit error: %w", err)
+	}
+	getMotorIdCache(db).Store(info.Beamline, motor_ids)
+	return nil
+}
+
+// Return the motors which the given names are aliases of at the given
+// beamlines (at any beamline if none are given). Names which are mnemonics at
+// a beamline are never treated as aliases there.
+func ResolveAliases(beamlines []string, names []string, db *sql.DB, dialect SQLDialect) ([]MotorAlias, error) {
+	var aliases []MotorAlias
+	if len(names) == 0 {
+		return aliases, nil
+	}
+	alias_condition, args := inCondition("A.alias", names)
+	beamline_condition, beamline_args := inCondition("A.beamline", beamlines)
+	statement := "SELECT A.alias, A.beamline, M.motor_mne FROM MotorAliases AS A JOIN MotorMnes AS M ON A.motor_id = M.motor_id" +
+		whereClause(alias_condition, beamline_condition,
+			"NOT EXISTS (SELECT 1 FROM MotorMnes AS X WHERE X.beamline = A.beamline AND X.motor_mne = A.alias)") +
+		" ORDER BY A.beamline, A.alias"
+	rows, err := db.Query(dialect.Rebind(statement), append(args, beamline_args...)...)
+	if err != nil {
+		return aliases, fmt.Errorf("[SpecScansService.main.ResolveAliases] db.Query error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias MotorAlias
+		err = rows.Scan(&alias.Alias, &alias.Beamline, &alias.Mne)
+		if err != nil {
+			return aliases, fmt.Errorf("[SpecScansService.main.ResolveAliases] rows.Scan error: %w", err)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// Return a NULL for empty strings
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Sorted copy of a list of aliases
+func sortedAliases(aliases []string) []string {
+	sorted := append([]string{}, aliases...)
+	sort.Strings(sorted)
+	return sorted
+}
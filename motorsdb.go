@@ -8,7 +8,9 @@ import (
 	"log"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 
 	srvConfig "github.com/CHESSComputing/golib/config"
@@ -19,32 +21,114 @@ import (
 var MotorsDb *sql.DB
 
 type MotorRecord struct {
-	ScanId string
-	Motors map[string]float64
+	ScanId    string
+	Beamline  string
+	Motors    map[string]float64
+	CreatedBy Identity
+	CreatedAt float64
 }
 
+// MotorPositionQuery is a condition on the position of a motor. Motors are
+// identified by beamline and mnemonic; the condition applies to the motors of
+// the given beamlines only, or of all beamlines if Beamlines is nil.
 type MotorPositionQuery struct {
-	Mne   string
-	Exact []float64
-	Min   float64
-	Max   float64
+	Mne       string
+	Beamlines []string
+	Exact     []float64
+	Min       float64
+	Max       float64
 }
 type MotorsDbQuery struct {
 	Sids                 []string
 	MotorPositionQueries []MotorPositionQuery
+	// Leave out deleted scans
+	ExcludeDeleted bool
 }
 
 func InitMotorsDb() {
-	dbtype, dburi, dbowner := sqldb.ParseDBFile(srvConfig.Config.SpecScans.DBFile)
-	log.Printf("InitDB: type=%s owner=%s", dbtype, dbowner)
-	db, err := sqldb.InitDB(dbtype, dburi)
+	db, dialect, err := OpenMotorsDb(srvConfig.Config.SpecScans.DBFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 	MotorsDb = db
+	ScanMotors = NewSQLMotorStore(db, dialect)
+	ScanVariables = NewSQLVariableStore(db, dialect)
+	ScanEmbargoes = NewSQLEmbargoStore(db, dialect)
+}
+
+// Open the motors database described by dbfile and check its schema version
+func OpenMotorsDb(dbfile string) (*sql.DB, SQLDialect, error) {
+	dbtype, dburi, dbowner := sqldb.ParseDBFile(dbfile)
+	log.Printf("InitDB: type=%s owner=%s", dbtype, dbowner)
+	dialect, driver, err := ParseSQLDialect(dbtype)
+	if err != nil {
+		return nil, dialect, fmt.Errorf("[SpecScansService.main.OpenMotorsDb] ParseSQLDialect error: %w", err)
+	}
+	db, err := sqldb.InitDB(driver, dburi)
+	if err != nil {
+		return nil, dialect, fmt.Errorf("[SpecScansService.main.OpenMotorsDb] sqldb.InitDB error: %w", err)
+	}
+	err = CheckSchemaVersion(db, dialect)
+	if err != nil {
+		db.Close()
+		return nil, dialect, fmt.Errorf("[SpecScansService.main.OpenMotorsDb] CheckSchemaVersion error: %w", err)
+	}
+	return db, dialect, nil
+}
+
+// Maximum number of rows written by a single multi-row INSERT statement. This
+// keeps statements below the bound parameter limits of every dialect (999 for
+// older SQLite versions).
+const MotorInsertBatchSize = 300
+
+// MotorIdCache maps motors (beamline and mnemonic) to their motor_id in the
+// MotorMnes table. Rows of MotorMnes are never deleted and their keys never
+// change, so cached ids stay valid for the life of the process.
+type MotorIdCache struct {
+	mutex sync.RWMutex
+	ids   map[motorKey]int64
+}
+
+// Key identifying a motor
+type motorKey struct {
+	Beamline string
+	Mne      string
 }
 
-func InsertMotors(r MotorRecord, db *sql.DB) (int64, error) {
+// Process-wide mnemonic caches, one per database
+var motorIdCaches sync.Map
+
+func getMotorIdCache(db *sql.DB) *MotorIdCache {
+	cache, _ := motorIdCaches.LoadOrStore(db, &MotorIdCache{ids: make(map[motorKey]int64)})
+	return cache.(*MotorIdCache)
+}
+
+// Return the cached ids of the given mnemonics of a beamline and the
+// mnemonics not cached
+func (c *MotorIdCache) Lookup(beamline string, mnes []string) (map[string]int64, []string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	ids := make(map[string]int64, len(mnes))
+	var missing []string
+	for _, mne := range mnes {
+		if id, ok := c.ids[motorKey{beamline, mne}]; ok {
+			ids[mne] = id
+		} else {
+			missing = append(missing, mne)
+		}
+	}
+	return ids, missing
+}
+
+func (c *MotorIdCache) Store(beamline string, ids map[string]int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for mne, id := range ids {
+		c.ids[motorKey{beamline, mne}] = id
+	}
+}
+
+func InsertMotors(r MotorRecord, db *sql.DB, dialect SQLDialect) (int64, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return -1, fmt.Errorf("[SpecScansService.main.InsertMotors] db.Begin error: %w", err)
@@ -53,46 +137,245 @@ func InsertMotors(r MotorRecord, db *sql.DB) (int64, error) {
 
 	// Insert the given motor record to the three tables that compose the static
 	// motor positions database.
-	log.Printf("Inserting motor record: %v", r)
-	result, err := tx.Exec("INSERT INTO ScanIds (sid) VALUES (?)", r.ScanId)
+	if Verbose > 0 {
+		log.Printf("Inserting motor record: %v", r)
+	}
+	scan_id, err := dialect.InsertId(tx, "scan_id",
+		"INSERT INTO ScanIds (sid, beamline, created_by, created_client, created_at, updated_by, updated_client, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		r.ScanId, r.Beamline, r.CreatedBy.User, r.CreatedBy.Client, r.CreatedAt, r.CreatedBy.User, r.CreatedBy.Client, r.CreatedAt)
 	if err != nil {
 		log.Printf("Could not insert record to ScanIds table; error: %v", err)
-		return -1, fmt.Errorf("[SpecScansService.main.InsertMotors] tx.Exec error: %w", err)
+		return -1, fmt.Errorf("[SpecScansService.main.InsertMotors] dialect.InsertId error: %w", err)
+	}
+
+	// Mnemonics are handled in sorted order so that concurrent inserts lock
+	// MotorMnes rows in the same order. Motors are resolved within the
+	// record's beamline.
+	mnes := make([]string, 0, len(r.Motors))
+	for mne := range r.Motors {
+		mnes = append(mnes, mne)
 	}
-	scan_id, err := result.LastInsertId()
+	sort.Strings(mnes)
+	cache := getMotorIdCache(db)
+	motor_ids, missing := cache.Lookup(r.Beamline, mnes)
+	new_ids, err := insertMotorMnes(tx, dialect, r.Beamline, missing)
 	if err != nil {
-		log.Printf("Could not get ID of new record in ScanIds; error: %v", err)
-		return scan_id, fmt.Errorf("[SpecScansService.main.InsertMotors] result.LastInsertId error: %w", err)
+		log.Printf("Could not insert records to MotorMnes table; error: %v", err)
+		return -1, fmt.Errorf("[SpecScansService.main.InsertMotors] insertMotorMnes error: %w", err)
+	}
+	for mne, id := range new_ids {
+		motor_ids[mne] = id
+	}
+
+	for i := 0; i < len(mnes); i += MotorInsertBatchSize {
+		batch := mnes[i:min(i+MotorInsertBatchSize, len(mnes))]
+		args := make([]any, 0, 3*len(batch))
+		for _, mne := range batch {
+			args = append(args, scan_id, motor_ids[mne], r.Motors[mne])
+		}
+		statement := "INSERT INTO MotorPositions (scan_id, motor_id, motor_position) VALUES " + Placeholders(len(batch), 3)
+		_, err = tx.Exec(dialect.Rebind(statement), args...)
+		if err != nil {
+			log.Printf("Could not insert records to MotorPositions table; error: %v", err)
+			return -1, fmt.Errorf("[SpecScansService.main.InsertMotors] tx.Exec error: %w", err)
+		}
 	}
-	var motor_id int64
-	for mne, pos := range r.Motors {
-		result, err = tx.Exec("INSERT INTO MotorMnes (motor_mne) VALUES (?)", mne)
+	err = tx.Commit()
+	if err != nil {
+		return scan_id, fmt.Errorf("[SpecsScanService.main.InsertMotors] tx.Commit error: %w", err)
+	}
+	// Only cache ids of committed rows
+	cache.Store(r.Beamline, new_ids)
+	return scan_id, nil
+}
+
+// Insert the given mnemonics of a beamline to the MotorMnes table, ignoring
+// those already present, and return the motor_id of each
+func insertMotorMnes(tx *sql.Tx, dialect SQLDialect, beamline string, mnes []string) (map[string]int64, error) {
+	motor_ids := make(map[string]int64, len(mnes))
+	for i := 0; i < len(mnes); i += MotorInsertBatchSize {
+		batch := mnes[i:min(i+MotorInsertBatchSize, len(mnes))]
+		args := make([]any, 0, 2*len(batch))
+		for _, mne := range batch {
+			args = append(args, beamline, mne)
+		}
+		statement := dialect.InsertIgnore("MotorMnes", []string{"beamline", "motor_mne"}, len(batch))
+		_, err := tx.Exec(dialect.Rebind(statement), args...)
 		if err != nil {
-			err = tx.QueryRow("SELECT motor_id FROM MotorMnes WHERE motor_mne=?", mne).Scan(&motor_id)
+			return nil, err
+		}
+		args = []any{beamline}
+		for _, mne := range batch {
+			args = append(args, mne)
+		}
+		statement = "SELECT motor_id, motor_mne FROM MotorMnes WHERE beamline = ? AND motor_mne IN " + Placeholders(1, len(batch))
+		rows, err := tx.Query(dialect.Rebind(statement), args...)
+		if err != nil {
+			return nil, err
+		}
+		var motor_id int64
+		var motor_mne string
+		for rows.Next() {
+			err = rows.Scan(&motor_id, &motor_mne)
 			if err != nil {
-				log.Printf("Could not insert record to MotorMnes table; error: %v", err)
-				continue
+				rows.Close()
+				return nil, err
 			}
-		} else {
-			motor_id, err = result.LastInsertId()
+			motor_ids[motor_mne] = motor_id
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(motor_ids) != len(mnes) {
+		return nil, fmt.Errorf("found %d of %d motor mnemonics after inserting them", len(motor_ids), len(mnes))
+	}
+	return motor_ids, nil
+}
+
+// Remove a scan, its motor positions and its stored variable values from the
+// motors database. Motor mnemonics are kept since other scans may use them.
+func RemoveMotors(sid string, db *sql.DB, dialect SQLDialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.RemoveMotors] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"MotorPositions", "VariableValues"} {
+		_, err = tx.Exec(dialect.Rebind("DELETE FROM "+table+" WHERE scan_id IN (SELECT scan_id FROM ScanIds WHERE sid = ?)"), sid)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.RemoveMotors] tx.Exec error: %w", err)
+		}
+	}
+	_, err = tx.Exec(dialect.Rebind("DELETE FROM ScanIds WHERE sid = ?"), sid)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.RemoveMotors] tx.Exec error: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.RemoveMotors] tx.Commit error: %w", err)
+	}
+	return nil
+}
+
+// Record who last changed a scan's record, and when
+func SetScanUpdated(sid string, by Identity, at float64, db *sql.DB, dialect SQLDialect) error {
+	_, err := db.Exec(dialect.Rebind("UPDATE ScanIds SET updated_by = ?, updated_client = ?, updated_at = ? WHERE sid = ?"), by.User, by.Client, at, sid)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanUpdated] db.Exec error: %w", err)
+	}
+	return nil
+}
+
+// Mark a scan in the motors database as deleted at the given time, or as not
+// deleted if the time is 0
+func SetScanDeleted(sid string, at float64, db *sql.DB, dialect SQLDialect) error {
+	deleted_at := sql.NullFloat64{Float64: at, Valid: at != 0}
+	result, err := db.Exec(dialect.Rebind("UPDATE ScanIds SET deleted_at = ? WHERE sid = ?"), deleted_at, sid)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanDeleted] db.Exec error: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanDeleted] result.RowsAffected error: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("[SpecScansService.main.SetScanDeleted] scan id %s not found", sid)
+	}
+	return nil
+}
+
+// Change the id of a scan in the motors database
+func RenameScan(sid string, new_sid string, db *sql.DB, dialect SQLDialect) error {
+	result, err := db.Exec(dialect.Rebind("UPDATE ScanIds SET sid = ? WHERE sid = ?"), new_sid, sid)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.RenameScan] db.Exec error: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n != 1 {
+		return fmt.Errorf("[SpecScansService.main.RenameScan] scan id %s not found", sid)
+	}
+	return nil
+}
+
+// Call fn with the id and beamline of every scan in the motors database,
+// streaming the rows
+func forEachScan(db *sql.DB, fn func(sid string, beamline string) error) error {
+	rows, err := db.Query("SELECT sid, beamline FROM ScanIds ORDER BY scan_id")
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.forEachScan] db.Query error: %w", err)
+	}
+	defer rows.Close()
+	var sid, beamline string
+	for rows.Next() {
+		err = rows.Scan(&sid, &beamline)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.forEachScan] rows.Scan error: %w", err)
 		}
+		err = fn(sid, beamline)
 		if err != nil {
-			log.Printf("Could not get ID of new record in MotorMnes; error: %v", err)
-			continue
+			return err
 		}
-		result, err = tx.Exec("INSERT INTO MotorPositions (scan_id, motor_id, motor_position) VALUES (?, ?, ?)", scan_id, motor_id, pos)
+	}
+	return rows.Err()
+}
+
+// Move a scan to another beamline, pointing its motor positions at the
+// motors of that beamline (e.g. for scans recorded before motors were scoped
+// by beamline)
+func SetScanBeamline(sid string, beamline string, db *sql.DB, dialect SQLDialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanBeamline] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+	var scan_id int64
+	err = tx.QueryRow(dialect.Rebind("SELECT scan_id FROM ScanIds WHERE sid = ?"), sid).Scan(&scan_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanBeamline] tx.QueryRow error: %w", err)
+	}
+	rows, err := tx.Query(dialect.Rebind("SELECT M.motor_mne, M.motor_id FROM MotorPositions AS P JOIN MotorMnes AS M ON M.motor_id=P.motor_id WHERE P.scan_id = ?"), scan_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanBeamline] tx.Query error: %w", err)
+	}
+	old_ids := make(map[string]int64)
+	var mnes []string
+	for rows.Next() {
+		var mne string
+		var motor_id int64
+		if err = rows.Scan(&mne, &motor_id); err != nil {
+			rows.Close()
+			return fmt.Errorf("[SpecScansService.main.SetScanBeamline] rows.Scan error: %w", err)
+		}
+		old_ids[mne] = motor_id
+		mnes = append(mnes, mne)
+	}
+	rows.Close()
+	sort.Strings(mnes)
+	new_ids, err := insertMotorMnes(tx, dialect, beamline, mnes)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanBeamline] insertMotorMnes error: %w", err)
+	}
+	for _, mne := range mnes {
+		_, err = tx.Exec(dialect.Rebind("UPDATE MotorPositions SET motor_id = ? WHERE scan_id = ? AND motor_id = ?"),
+			new_ids[mne], scan_id, old_ids[mne])
 		if err != nil {
-			log.Printf("Could not insert record to MotorPositions table; error: %v", err)
+			return fmt.Errorf("[SpecScansService.main.SetScanBeamline] tx.Exec error: %w", err)
 		}
 	}
+	_, err = tx.Exec(dialect.Rebind("UPDATE ScanIds SET beamline = ? WHERE scan_id = ?"), beamline, scan_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetScanBeamline] tx.Exec error: %w", err)
+	}
 	err = tx.Commit()
 	if err != nil {
-		return scan_id, fmt.Errorf("[SpecsScanService.main.InsertMotors] tx.Commit error: %w", err)
+		return fmt.Errorf("[SpecScansService.main.SetScanBeamline] tx.Commit error: %w", err)
 	}
-	return scan_id, nil
+	getMotorIdCache(db).Store(beamline, new_ids)
+	return nil
 }
 
-func QueryMotorPosition(mne string, pos float64) []MotorRecord {
+func QueryMotorPosition(mne string, pos float64) ([]MotorRecord, error) {
 	query := MotorsDbQuery{
 		MotorPositionQueries: []MotorPositionQuery{
 			MotorPositionQuery{
@@ -101,23 +384,31 @@ func QueryMotorPosition(mne string, pos float64) []MotorRecord {
 			},
 		},
 	}
-	return queryMotorsDb(query)
+	return ScanMotors.QueryMotors(query)
 }
 
-func GetMotorRecords(sids ...string) ([]MotorRecord, error) {
+func GetMotorRecords(motors MotorStore, sids ...string) ([]MotorRecord, error) {
 	query := MotorsDbQuery{Sids: sids}
-	return queryMotorsDb(query), nil
+	return motors.QueryMotors(query)
 }
 
-func QueryMotorsDb(query map[string]any) []MotorRecord {
-	motorsdb_query := translateQuery(query)
+// Query the motors database. Motors named in query are resolved within the
+// given beamlines (e.g. those the search is restricted to), unless qualified
+// as "mne@beamline", or "mne@*" for the motors of every beamline.
+func QueryMotorsDb(motors MotorStore, query map[string]any, beamlines []string, include_deleted bool) ([]MotorRecord, error) {
+	motorsdb_query := translateQuery(query, beamlines)
+	motorsdb_query.ExcludeDeleted = !include_deleted
+	err := expandMotorAliases(motors, &motorsdb_query)
+	if err != nil {
+		return nil, fmt.Errorf("[SpecScansService.main.QueryMotorsDb] expandMotorAliases error: %w", err)
+	}
 	if Verbose > 0 {
 		log.Printf("motorsdb_query: %+v\n", motorsdb_query)
 	}
-	return queryMotorsDb(motorsdb_query)
+	return motors.QueryMotors(motorsdb_query)
 }
 
-func translateQuery(query map[string]any) MotorsDbQuery {
+func translateQuery(query map[string]any, beamlines []string) MotorsDbQuery {
 	var motorsdb_query MotorsDbQuery
 
 	// Consolidate values from user query keys like "motors" and "motors.*" so
@@ -134,7 +425,16 @@ func translateQuery(query map[string]any) MotorsDbQuery {
 		}
 	}
 	for _, v := range position_queries {
-		motorsdb_query.MotorPositionQueries = append(motorsdb_query.MotorPositionQueries, translatePositionQuery(v))
+		position_query := translatePositionQuery(v)
+		if mne, beamline, found := strings.Cut(position_query.Mne, "@"); found {
+			position_query.Mne = mne
+			if beamline != "*" {
+				position_query.Beamlines = []string{beamline}
+			}
+		} else {
+			position_query.Beamlines = beamlines
+		}
+		motorsdb_query.MotorPositionQueries = append(motorsdb_query.MotorPositionQueries, position_query)
 	}
 	return motorsdb_query
 }
@@ -176,33 +476,36 @@ func translatePositionQuery(query any) MotorPositionQuery {
 	return position_query
 }
 
-func queryMotorsDb(query MotorsDbQuery) []MotorRecord {
+func queryMotorsDb(query MotorsDbQuery, db *sql.DB, dialect SQLDialect) ([]MotorRecord, error) {
 	var motor_records []MotorRecord
-	statement, err := getSqlStatement("query_motorsdb.sql", query)
+	statement, args, err := getSqlStatement("query_motorsdb.sql", query)
 	if err != nil {
 		log.Printf("Could not get appropriate SQL query statement; error: %v", err)
-		return motor_records
+		return motor_records, fmt.Errorf("[SpecScansService.main.queryMotorsDb] getSqlStatement error: %w", err)
 	}
 	if Verbose > 1 {
-		log.Printf("Motors db query SQL statement: %s", statement)
+		log.Printf("Motors db query SQL statement: %s %v", statement, args)
 	}
-	rows, err := MotorsDb.Query(statement)
+	rows, err := db.Query(dialect.Rebind(statement), args...)
 	if err != nil {
 		log.Printf("Could not query motor positions database; error: %v", err)
-		return motor_records
+		return motor_records, fmt.Errorf("[SpecScansService.main.queryMotorsDb] db.Query error: %w", err)
 	}
-	return parseMotorRecords(rows)
+	defer rows.Close()
+	return parseMotorRecords(rows), nil
 }
 
 func parseMotorRecords(rows *sql.Rows) []MotorRecord {
 	// Helper for parsing non-grouped results of sql query
 	var motor_records []MotorRecord
 	record_map := make(map[string]map[string]float64) // map of scan ids: map of motor mne: position
+	beamlines := make(map[string]string)              // map of scan ids: beamline
 	var sid string
+	var beamline string
 	var motor_mne string
 	var motor_pos float64
 	for rows.Next() {
-		err := rows.Scan(&sid, &motor_mne, &motor_pos)
+		err := rows.Scan(&sid, &beamline, &motor_mne, &motor_pos)
 		if err != nil {
 			log.Printf("Could not parse row of results: %v\n", err)
 			continue
@@ -212,31 +515,40 @@ func parseMotorRecords(rows *sql.Rows) []MotorRecord {
 			record_map[sid] = make(map[string]float64)
 		}
 		record_map[sid][motor_mne] = motor_pos
+		beamlines[sid] = beamline
 	}
 	for sid := range record_map {
-		motor_records = append(motor_records, MotorRecord{ScanId: sid, Motors: record_map[sid]})
+		motor_records = append(motor_records, MotorRecord{ScanId: sid, Beamline: beamlines[sid], Motors: record_map[sid]})
 	}
 	return motor_records
 }
 
-func getSqlStatement(tmpl_file string, params MotorsDbQuery) (string, error) {
-	tmpl, err := template.New(tmpl_file).ParseFiles(path.Join(srvConfig.Config.SpecScans.WebServer.StaticDir, tmpl_file))
+// Render an SQL statement template with the given parameters. Values are not
+// written into the statement: the template's "arg" function binds them as
+// arguments, returned with the statement, in place of "?" placeholders.
+func getSqlStatement(tmpl_file string, params MotorsDbQuery) (string, []any, error) {
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return "?"
+	}
+	tmpl, err := template.New(tmpl_file).Funcs(template.FuncMap{"arg": arg}).ParseFiles(path.Join(srvConfig.Config.SpecScans.WebServer.StaticDir, tmpl_file))
 	if err != nil {
-		return "", fmt.Errorf("[SpecScansService.main.getSqlStatement] template.New error: %w", err)
+		return "", nil, fmt.Errorf("[SpecScansService.main.getSqlStatement] template.New error: %w", err)
 	}
 	statement := ""
 	buf := bytes.NewBufferString(statement)
 	err = tmpl.Execute(buf, params)
 	if err != nil {
-		return "", fmt.Errorf("[SpecScansService.main.getSqlStatement] tmpl.Execute error: %w", err)
+		return "", nil, fmt.Errorf("[SpecScansService.main.getSqlStatement] tmpl.Execute error: %w", err)
 	}
 	statement = buf.String()
 	if statement == "" {
-		return "", errors.New("Statement is empty")
+		return "", nil, errors.New("Statement is empty")
 	}
 	// Trim whitespace for certain sql dirver(s)
 	statement = strings.TrimSpace(statement)
 	re := regexp.MustCompile(`\s+`)
 	statement = string(re.ReplaceAll([]byte(statement), []byte(" ")))
-	return statement, nil
+	return statement, args, nil
 }
@@ -0,0 +1,711 @@
+package main
+
+// openapi module
+//
+// The service describes its API in an OpenAPI 3 document, generated from the
+// route table and the Go types of request and response bodies, so client
+// generators stay in step with UserRecord and the other types. The document
+// is served at /openapi.json, and requests are validated against it before
+// they reach their handlers.
+//
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	server "github.com/CHESSComputing/golib/server"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Version of the API described by the OpenAPI document
+const APIVersion = "1.0.0"
+
+// APISchema is an OpenAPI schema object
+type APISchema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 string                `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Properties           map[string]*APISchema `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	AdditionalProperties any                   `json:"additionalProperties,omitempty"`
+	Items                *APISchema            `json:"items,omitempty"`
+	OneOf                []*APISchema          `json:"oneOf,omitempty"`
+	AnyOf                []*APISchema          `json:"anyOf,omitempty"`
+	Enum                 []any                 `json:"enum,omitempty"`
+	Minimum              *float64              `json:"minimum,omitempty"`
+	Maximum              *float64              `json:"maximum,omitempty"`
+	Nullable             bool                  `json:"nullable,omitempty"`
+	ReadOnly             bool                  `json:"readOnly,omitempty"`
+}
+
+// APIParameter is an OpenAPI parameter object, of a URL parameter
+type APIParameter struct {
+	Name        string     `json:"name"`
+	In          string     `json:"in"`
+	Description string     `json:"description,omitempty"`
+	Required    bool       `json:"required,omitempty"`
+	Schema      *APISchema `json:"schema"`
+}
+
+// APIMediaType is an OpenAPI media type object
+type APIMediaType struct {
+	Schema *APISchema `json:"schema"`
+}
+
+// APIRequestBody is an OpenAPI request body object
+type APIRequestBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]APIMediaType `json:"content"`
+}
+
+// APIResponse is an OpenAPI response object
+type APIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]APIMediaType `json:"content,omitempty"`
+}
+
+// APIOperation is an OpenAPI operation object, of a route
+type APIOperation struct {
+	Summary     string                 `json:"summary"`
+	OperationId string                 `json:"operationId"`
+	Parameters  []APIParameter         `json:"parameters,omitempty"`
+	RequestBody *APIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]APIResponse `json:"responses"`
+	Security    []map[string][]string  `json:"security,omitempty"`
+}
+
+// OpenAPI is an OpenAPI document
+type OpenAPI struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]*APIOperation `json:"paths"`
+	Components struct {
+		Schemas         map[string]*APISchema     `json:"schemas"`
+		SecuritySchemes map[string]map[string]any `json:"securitySchemes"`
+	} `json:"components"`
+}
+
+// routeDoc documents a route of the service
+type routeDoc struct {
+	Summary  string
+	Params   []APIParameter
+	Body     any               // a value of the type of the request body, or its schema
+	BodyList bool              // whether the body may also be a list of such values
+	Response any               // a value of the type of JSON responses, by default a ServiceResponse
+	Content  map[string]string // other content types of responses, with their schema types
+}
+
+// Helper to document a query URL parameter of the given type
+func queryParam(name string, typ string, description string) APIParameter {
+	return APIParameter{Name: name, In: "query", Description: description, Schema: &APISchema{Type: typ}}
+}
+
+// Helper to document a repeatable query URL parameter with string values
+func queryListParam(name string, description string) APIParameter {
+	return APIParameter{Name: name, In: "query", Description: description, Schema: &APISchema{Type: "array", Items: &APISchema{Type: "string"}}}
+}
+
+// Helper to document a query URL parameter with the given values
+func queryEnumParam(name string, values []string, description string) APIParameter {
+	schema := &APISchema{Type: "string"}
+	for _, value := range values {
+		schema.Enum = append(schema.Enum, value)
+	}
+	return APIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// Helper to document a required query URL parameter
+func requiredParam(param APIParameter) APIParameter {
+	param.Required = true
+	return param
+}
+
+var sandboxParam = queryParam("sandbox", "boolean", "use the sandbox instead of production records")
+var includeDeletedParam = queryParam("include_deleted", "boolean", "include deleted scans")
+
+// Documentation of the routes of the service, by method and path
+var routeDocs = map[string]routeDoc{
+	"POST /add": {
+		Summary:  "Add scan records",
+		Params:   []APIParameter{sandboxParam},
+		Body:     UserRecord{},
+		BodyList: true,
+	},
+	"PUT /edit": {
+		Summary:  "Edit scan records, identified by sid or by spec_file and scan_number",
+		Params:   []APIParameter{sandboxParam},
+		Body:     &APISchema{Ref: schemaRef("RecordEdit")},
+		BodyList: true,
+	},
+	"POST /search": {
+		Summary: "Search scan records",
+		Params: []APIParameter{
+			sandboxParam,
+			includeDeletedParam,
+			queryEnumParam("format", searchFormats(), "format of the matching records"),
+			queryEnumParam("time_format", []string{"iso"}, "format times as ISO 8601 timestamps"),
+			queryParam("units", "boolean", "add the units of each record's motors"),
+		},
+		Body:    services.ServiceRequest{},
+		Content: map[string]string{"text/plain": "string", "text/csv": "string", "text/tab-separated-values": "string"},
+	},
+	"GET /motors": {
+		Summary: "Get motor catalog entries",
+		Params:  []APIParameter{sandboxParam, queryListParam("beamline", "beamlines of the entries"), queryListParam("mne", "mnemonics of the entries")},
+	},
+	"PUT /motors": {
+		Summary:  "Set motor catalog entries",
+		Params:   []APIParameter{sandboxParam},
+		Body:     MotorInfo{},
+		BodyList: true,
+	},
+	"GET /variables": {
+		Summary: "Get variable declarations",
+		Params:  []APIParameter{sandboxParam, queryListParam("name", "names of the variables")},
+	},
+	"PUT /variables": {
+		Summary:  "Declare variables",
+		Params:   []APIParameter{sandboxParam},
+		Body:     VariableInfo{},
+		BodyList: true,
+	},
+	"GET /status": {
+		Summary: "Get the status of scans",
+		Params:  []APIParameter{sandboxParam, includeDeletedParam, requiredParam(queryListParam("sid", "scan ids"))},
+	},
+	"POST /status": {
+		Summary: "Change the status of a scan",
+		Params:  []APIParameter{sandboxParam},
+		Body:    StatusTransition{},
+	},
+	"POST /reconcile": {
+		Summary:  "Check and repair the consistency of the document and motor stores",
+		Params:   []APIParameter{queryEnumParam("mode", ReconcileModes, "whether to report or repair inconsistencies")},
+		Response: ConsistencyReport{},
+	},
+	"DELETE /sandbox": {
+		Summary: "Purge sandbox records (of the caller's own BTRs unless staff)",
+		Params:  []APIParameter{queryListParam("beamline", "beamlines of the records"), queryListParam("btr", "BTRs of the records"), queryListParam("cycle", "cycles of the records")},
+	},
+	"GET /embargo": {
+		Summary: "Get active BTR embargoes",
+		Params:  []APIParameter{sandboxParam, queryListParam("btr", "BTRs of the embargoes")},
+	},
+	"PUT /embargo": {
+		Summary: "Set or lift the embargo of a BTR or a scan",
+		Params:  []APIParameter{sandboxParam},
+		Body:    Embargo{},
+	},
+	"DELETE /records": {
+		Summary: "Delete scans, which become tombstones",
+		Params:  []APIParameter{sandboxParam, requiredParam(queryListParam("sid", "scan ids"))},
+	},
+	"POST /restore": {
+		Summary: "Restore deleted scans",
+		Params:  []APIParameter{sandboxParam, requiredParam(queryListParam("sid", "scan ids"))},
+	},
+	"DELETE /tombstones": {
+		Summary: "Purge the tombstones of deleted scans",
+		Params:  []APIParameter{sandboxParam, queryListParam("sid", "scan ids"), queryListParam("btr", "BTRs of the scans"), queryParam("before", "number", "epoch time the scans were deleted before")},
+	},
+	"GET /audit": {
+		Summary: "Query the audit log of write requests",
+		Params: []APIParameter{
+			queryParam("actor", "string", "user who made the requests"),
+			queryParam("endpoint", "string", "endpoint of the requests"),
+			queryListParam("sid", "scan ids affected by the requests"),
+			queryParam("time", "string", "time of the requests, in the syntax of time queries"),
+			queryParam("idx", "integer", "index of the first entry"),
+			queryParam("limit", "integer", "maximum number of entries"),
+		},
+	},
+	"POST /graphql": {
+		Summary:  "Query scans, their datasets and motors with GraphQL; responds with the GraphQL result",
+		Params:   []APIParameter{sandboxParam},
+		Body:     GraphQLRequest{},
+		Response: map[string]any{},
+	},
+	"GET /subscribe": {
+		Summary: "Subscribe to the events of records matching a query, which are pushed as Server-Sent Events",
+		Params: []APIParameter{
+			sandboxParam,
+			queryParam("query", "string", "query in the syntax of POST /search (all records if none)"),
+		},
+		Content: map[string]string{"text/event-stream": "string"},
+	},
+	"GET /webhooks": {
+		Summary: "List the webhooks, without their secrets",
+	},
+	"PUT /webhooks": {
+		Summary: "Add a webhook, or replace the webhook with its id; its secret is write-only and stored as given",
+		Body:    Webhook{},
+	},
+	"DELETE /webhooks": {
+		Summary: "Remove a webhook",
+		Params:  []APIParameter{requiredParam(queryParam("id", "string", "id of the webhook"))},
+	},
+	"GET /webhooks/deliveries": {
+		Summary: "Query the delivery log of webhooks",
+		Params: []APIParameter{
+			queryParam("webhook", "string", "id of the webhook"),
+			queryParam("sid", "string", "scan id of the delivered events"),
+			queryEnumParam("event", ScanEventTypes, "type of the delivered events"),
+			queryEnumParam("status", []string{DeliverySucceeded, DeliveryFailed}, "outcome of the deliveries"),
+			queryParam("idx", "integer", "index of the first delivery"),
+			queryParam("limit", "integer", "maximum number of deliveries"),
+		},
+	},
+	"GET /openapi.json": {
+		Summary:  "Get this OpenAPI document",
+		Response: map[string]any{},
+	},
+}
+
+// Return the formats of search results
+func searchFormats() []string {
+	formats := []string{"json", "spec"}
+	for format := range TableContentTypes {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats[2:])
+	return formats
+}
+
+// Return the reference to a schema of the document's components
+func schemaRef(name string) string {
+	return "#/components/schemas/" + name
+}
+
+// Return the schema of a Go type, adding the schemas of named struct types to
+// the given components. The service's own struct types are closed, so
+// misspelled or renamed fields are rejected; FOXDEN types shared with other
+// services are left open.
+func typeSchema(t reflect.Type, components map[string]*APISchema) *APISchema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := typeSchema(t.Elem(), components)
+		if schema.Ref != "" {
+			// OpenAPI 3.0 ignores nullable next to $ref
+			return &APISchema{Nullable: true, AnyOf: []*APISchema{schema}}
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.String:
+		return &APISchema{Type: "string"}
+	case reflect.Bool:
+		return &APISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &APISchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		minimum := 0.0
+		schema := &APISchema{Type: "integer", Minimum: &minimum}
+		if t.Bits() < 64 {
+			maximum := float64(uint64(1)<<t.Bits() - 1)
+			schema.Maximum = &maximum
+		}
+		return schema
+	case reflect.Float32, reflect.Float64:
+		return &APISchema{Type: "number"}
+	case reflect.Slice:
+		// Go clients send nil slices and maps as null
+		return &APISchema{Type: "array", Items: typeSchema(t.Elem(), components), Nullable: true}
+	case reflect.Array:
+		return &APISchema{Type: "array", Items: typeSchema(t.Elem(), components)}
+	case reflect.Map:
+		schema := &APISchema{Type: "object", Nullable: true}
+		if t.Elem().Kind() != reflect.Interface {
+			schema.AdditionalProperties = typeSchema(t.Elem(), components)
+		}
+		return schema
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := components[name]; ok {
+			return &APISchema{Ref: schemaRef(name)}
+		}
+		schema := &APISchema{Type: "object", Properties: map[string]*APISchema{}}
+		// Reserve the name for recursive types
+		components[name] = schema
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || key == "-" {
+				continue
+			}
+			if key == "" {
+				key = field.Name
+			}
+			schema.Properties[key] = typeSchema(field.Type, components)
+		}
+		if t.PkgPath() == reflect.TypeOf(UserRecord{}).PkgPath() {
+			schema.AdditionalProperties = false
+		}
+		return &APISchema{Ref: schemaRef(name)}
+	}
+	return &APISchema{}
+}
+
+// Return the schema of a request or response body
+func bodySchema(body any, components map[string]*APISchema) *APISchema {
+	if schema, ok := body.(*APISchema); ok {
+		return schema
+	}
+	return typeSchema(reflect.TypeOf(body), components)
+}
+
+// Return the schema of record edits: any fields of a record, and either its
+// sid or its spec_file and scan_number to identify it
+func recordEditSchema(components map[string]*APISchema) *APISchema {
+	typeSchema(reflect.TypeOf(UserRecord{}), components)
+	record := components["UserRecord"]
+	return &APISchema{
+		Type:                 "object",
+		Description:          "Fields of a scan record to change",
+		Properties:           record.Properties,
+		AdditionalProperties: false,
+		AnyOf: []*APISchema{
+			{Required: []string{"sid"}},
+			{Required: []string{"spec_file", "scan_number"}},
+		},
+	}
+}
+
+// Return the OpenAPI document of the given routes
+func NewOpenAPI(routes []server.Route) (*OpenAPI, error) {
+	doc := &OpenAPI{OpenAPI: "3.0.3", Paths: map[string]map[string]*APIOperation{}}
+	doc.Info.Title = "SpecScans"
+	doc.Info.Description = "FOXDEN service for the metadata of SPEC scans"
+	doc.Info.Version = APIVersion
+	doc.Components.Schemas = map[string]*APISchema{}
+	doc.Components.SecuritySchemes = map[string]map[string]any{
+		"bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+	}
+	components := doc.Components.Schemas
+	components["RecordEdit"] = recordEditSchema(components)
+	// Fields maintained by the service are read only (a scan's end_time may
+	// be given when it is added)
+	record := components["UserRecord"]
+	for _, keys := range [][]string{StatusKeys, ProvenanceKeys, EmbargoKeys, DeletedKeys} {
+		for _, key := range keys {
+			if property, ok := record.Properties[key]; ok && key != "end_time" {
+				property.ReadOnly = true
+			}
+		}
+	}
+	for _, route := range routes {
+		route_doc, ok := routeDocs[route.Method+" "+route.Path]
+		if !ok {
+			return nil, fmt.Errorf("[SpecScansService.main.NewOpenAPI] route %s %s is not documented", route.Method, route.Path)
+		}
+		operation := &APIOperation{
+			Summary:     route_doc.Summary,
+			OperationId: operationId(route),
+			Parameters:  route_doc.Params,
+			Responses:   map[string]APIResponse{},
+		}
+		if route_doc.Body != nil {
+			schema := bodySchema(route_doc.Body, components)
+			if route_doc.BodyList {
+				schema = &APISchema{OneOf: []*APISchema{schema, {Type: "array", Items: schema}}}
+			}
+			operation.RequestBody = &APIRequestBody{Required: true, Content: map[string]APIMediaType{"application/json": {Schema: schema}}}
+		}
+		var response any = services.ServiceResponse{}
+		if route_doc.Response != nil {
+			response = route_doc.Response
+		}
+		content := map[string]APIMediaType{"application/json": {Schema: bodySchema(response, components)}}
+		for content_type, typ := range route_doc.Content {
+			content[content_type] = APIMediaType{Schema: &APISchema{Type: typ}}
+		}
+		operation.Responses["200"] = APIResponse{Description: "Service response, with the status of the request in its http_code", Content: content}
+		if route.Authorized {
+			operation.Security = []map[string][]string{{"bearerAuth": {}}}
+			scope := route.Scope
+			if scope == "" {
+				scope = "read"
+			}
+			operation.Summary += fmt.Sprintf(" (requires a token with the %s scope)", scope)
+			operation.Responses["401"] = APIResponse{Description: "Missing or invalid token"}
+			operation.Responses["403"] = APIResponse{Description: "Token without the required scope"}
+		}
+		if len(operation.Parameters) > 0 || operation.RequestBody != nil {
+			operation.Responses["400"] = APIResponse{
+				Description: "Request not valid against this document",
+				Content:     map[string]APIMediaType{"application/json": {Schema: bodySchema(services.ServiceResponse{}, components)}},
+			}
+		}
+		if doc.Paths[route.Path] == nil {
+			doc.Paths[route.Path] = map[string]*APIOperation{}
+		}
+		doc.Paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+	return doc, nil
+}
+
+// Return the id of the operation of a route, e.g. "putEmbargo"
+func operationId(route server.Route) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool { return !('a' <= r && r <= 'z') }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+var openAPI *OpenAPI
+var openAPIErr error
+var openAPIOnce sync.Once
+
+// Return the OpenAPI document of the service's routes
+func OpenAPIDocument() (*OpenAPI, error) {
+	openAPIOnce.Do(func() {
+		openAPI, openAPIErr = NewOpenAPI(serviceRoutes())
+	})
+	return openAPI, openAPIErr
+}
+
+// Handler for the OpenAPI document of the service
+func OpenAPIHandler(c *gin.Context) {
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ParseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// Return the schema a reference refers to
+func (doc *OpenAPI) resolve(schema *APISchema) *APISchema {
+	for schema.Ref != "" {
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, schemaRef(""))]
+	}
+	return schema
+}
+
+// Return the JSON type of a decoded JSON value
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// Check a decoded JSON value against a schema; path locates the value in
+// error messages
+func (doc *OpenAPI) validate(schema *APISchema, value any, path string) error {
+	schema = doc.resolve(schema)
+	if value == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.OneOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%s must not be null", path)
+	}
+	if len(schema.OneOf) > 0 {
+		var errs []error
+		for _, alternative := range schema.OneOf {
+			err := doc.validate(alternative, value, path)
+			if err == nil {
+				return nil
+			}
+			// Report the error of the alternative of the value's type
+			if doc.resolve(alternative).Type == jsonType(value) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+	if len(schema.AnyOf) > 0 {
+		var errs []error
+		for _, alternative := range schema.AnyOf {
+			err := doc.validate(alternative, value, path)
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+	typ := jsonType(value)
+	switch schema.Type {
+	case "":
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return fmt.Errorf("%s must be an integer, not %v", path, value)
+		}
+	default:
+		if typ != schema.Type {
+			return fmt.Errorf("%s must be of type %s, not %s", path, schema.Type, typ)
+		}
+	}
+	if number, ok := value.(float64); ok {
+		if schema.Minimum != nil && number < *schema.Minimum {
+			return fmt.Errorf("%s must be at least %v", path, *schema.Minimum)
+		}
+		if schema.Maximum != nil && number > *schema.Maximum {
+			return fmt.Errorf("%s must be at most %v", path, *schema.Maximum)
+		}
+	}
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		return fmt.Errorf("%s must be one of %v, not %v", path, schema.Enum, value)
+	}
+	if items, ok := value.([]any); ok && schema.Items != nil {
+		for i, item := range items {
+			if err := doc.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	if object, ok := value.(map[string]any); ok {
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				return fmt.Errorf("%s must have %q", path, key)
+			}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := schema.Properties[key]
+			if !ok {
+				switch additional := schema.AdditionalProperties.(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s has unknown field %q", path, key)
+					}
+					continue
+				case *APISchema:
+					property = additional
+				default:
+					continue
+				}
+			}
+			if err := doc.validate(property, object[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check the URL parameters and the body of a request against an operation
+func (doc *OpenAPI) validateRequest(operation *APIOperation, c *gin.Context) error {
+	query := c.Request.URL.Query()
+	for _, param := range operation.Parameters {
+		values := query[param.Name]
+		if len(values) == 0 {
+			if param.Required {
+				return fmt.Errorf("URL parameter %q is required", param.Name)
+			}
+			continue
+		}
+		schema := param.Schema
+		if schema.Type != "array" && len(values) > 1 {
+			return fmt.Errorf("URL parameter %q may only be given once", param.Name)
+		}
+		if schema.Items != nil {
+			schema = schema.Items
+		}
+		for _, value := range values {
+			var err error
+			var decoded any = value
+			switch schema.Type {
+			case "boolean":
+				decoded, err = strconv.ParseBool(value)
+			case "number", "integer":
+				decoded, err = strconv.ParseFloat(value, 64)
+			}
+			if err == nil {
+				err = doc.validate(schema, decoded, "URL parameter "+param.Name)
+			} else {
+				err = fmt.Errorf("URL parameter %s must be of type %s, not %q", param.Name, schema.Type, value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if operation.RequestBody == nil || c.Request.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if operation.RequestBody.Required {
+			return errors.New("request body is required")
+		}
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("request body is not valid JSON: %w", err)
+	}
+	return doc.validate(operation.RequestBody.Content["application/json"].Schema, value, "body")
+}
+
+// Return a handler serving requests valid against the given operation with
+// the given handler
+func Validated(doc *OpenAPI, operation *APIOperation, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := doc.validateRequest(operation, c); err != nil {
+			err = fmt.Errorf("invalid request to %s %s (see /openapi.json): %w", c.Request.Method, c.FullPath(), err)
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.ValidateError, err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, resp)
+			return
+		}
+		handler(c)
+	}
+}
+
+// Return the given routes, with their requests validated against the
+// service's OpenAPI document
+func validateRoutes(routes []server.Route) []server.Route {
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, route := range routes {
+		operation := doc.Paths[route.Path][strings.ToLower(route.Method)]
+		if operation != nil {
+			routes[i].Handler = Validated(doc, operation, route.Handler)
+		}
+	}
+	return routes
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test the OpenAPI document generated from the route table
+func TestOpenAPI(t *testing.T) {
+	r := SetupTestService(t)
+
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json returned %d: %s", w.Code, w.Body.String())
+	}
+	var doc OpenAPI
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("unable to decode the OpenAPI document: %v", err)
+	}
+	for _, route := range serviceRoutes() {
+		operation := doc.Paths[route.Path][strings.ToLower(route.Method)]
+		if operation == nil {
+			t.Errorf("route %s %s is missing from the OpenAPI document", route.Method, route.Path)
+			continue
+		}
+		if route.Authorized != (len(operation.Security) > 0) {
+			t.Errorf("security of route %s %s is %v", route.Method, route.Path, operation.Security)
+		}
+	}
+	record := doc.Components.Schemas["UserRecord"]
+	fields := reflect.TypeOf(UserRecord{})
+	for i := 0; i < fields.NumField(); i++ {
+		key, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+		if _, ok := record.Properties[key]; !ok {
+			t.Errorf("UserRecord field %s is missing from its schema", key)
+		}
+	}
+	if record.Properties["scan_number"].Type != "integer" || !record.Properties["created_by"].ReadOnly {
+		t.Errorf("UserRecord schema is %+v", record)
+	}
+	routes := serviceRoutes()
+	routes[0].Path = "/undocumented"
+	if _, err := NewOpenAPI(routes); err == nil {
+		t.Errorf("NewOpenAPI documented an undocumented route")
+	}
+}
+
+// Test that requests not valid against the OpenAPI document are rejected
+func TestRequestValidation(t *testing.T) {
+	r := SetupTestService(t)
+
+	record := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	var valid map[string]any
+	if err := normalizeDocument(record, &valid); err != nil {
+		t.Fatal(err)
+	}
+	with := func(key string, value any) map[string]any {
+		invalid := map[string]any{}
+		for k, v := range valid {
+			invalid[k] = v
+		}
+		invalid[key] = value
+		return invalid
+	}
+	tests := []struct {
+		method string
+		url    string
+		body   any
+		error  string
+	}{
+		{"POST", "/add", with("scan_number", "1"), "body.scan_number must be an integer"},
+		{"POST", "/add", with("scan_number", 1.5), "body.scan_number must be an integer"},
+		{"POST", "/add", with("scan_number", 70000), "body.scan_number must be at most 65535"},
+		{"POST", "/add", with("start", 1709647200), `body has unknown field "start"`},
+		{"POST", "/add", with("motors", map[string]any{"samx": "1"}), "body.motors.samx must be of type number"},
+		{"POST", "/add", []any{valid, with("comments", "comment")}, "body[1].comments must be of type array"},
+		{"POST", "/add", "record", "body must be of type object"},
+		{"POST", "/add?sandbox=maybe", valid, "URL parameter sandbox must be of type boolean"},
+		{"PUT", "/edit", map[string]any{"command": "dscan"}, `body must have "sid"`},
+		{"PUT", "/edit", map[string]any{"sid": 1, "command": "dscan"}, "body.sid must be of type string"},
+		{"POST", "/search?format=xml", services.ServiceRequest{}, "URL parameter format must be one of"},
+		{"POST", "/status", map[string]any{"sid": "1", "status": "completed", "at": 1}, `body has unknown field "at"`},
+		{"DELETE", "/records", nil, `URL parameter "sid" is required`},
+		{"GET", "/audit?limit=ten", nil, "URL parameter limit must be of type integer"},
+	}
+	for _, tt := range tests {
+		response := serveTestRequest(t, r, tt.method, tt.url, tt.body)
+		if response.HttpCode != http.StatusBadRequest || !strings.Contains(response.Error, tt.error) {
+			t.Errorf("%s %s with %v returned %d %q; want %d %q", tt.method, tt.url, tt.body, response.HttpCode, response.Error, http.StatusBadRequest, tt.error)
+		}
+	}
+	if records := searchTestService(t, r, "{}"); len(records) != 0 {
+		t.Errorf("invalid requests added records: %+v", records)
+	}
+	if response := serveTestRequest(t, r, "POST", "/add", []any{valid}); response.SrvCode != services.OK {
+		t.Errorf("adding a valid record failed: %+v", response)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+// Keys of the provenance of records: who added and last changed each record,
+// and when. Only the service sets them, from the identity of the caller.
+var ProvenanceKeys = []string{"created_by", "created_at", "updated_by", "updated_at"}
+
+// Return the identity to record in provenance fields, or nil for requests
+// without one
+func provenanceIdentity(by Identity) *Identity {
+	if by == (Identity{}) {
+		return nil
+	}
+	return &by
+}
+
+// Set the provenance of a new record, replacing any the client gave
+func SetCreated(record *UserRecord, by Identity, at float64) {
+	record.CreatedBy = provenanceIdentity(by)
+	record.CreatedAt = at
+	record.UpdatedBy = provenanceIdentity(by)
+	record.UpdatedAt = at
+}
+
+// Return the fields to $set in a record's document when it is changed
+func updatedFields(by Identity, at float64) map[string]any {
+	return map[string]any{"updated_by": provenanceIdentity(by), "updated_at": at}
+}
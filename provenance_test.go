@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test that adds and edits record who made them, whatever clients send
+func TestProvenance(t *testing.T) {
+	r := SetupTestService(t)
+	alice := testToken(t, authz.CustomClaims{User: "alice", Application: "spec", Scope: "write", Btrs: []string{"test-123-a"}})
+	bob := testToken(t, authz.CustomClaims{User: "bob", Scope: "write", Btrs: []string{"test-123-a"}})
+
+	record := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	record.CreatedBy = &Identity{User: "mallory"}
+	record.CreatedAt = 1
+	before := epochTime(time.Now())
+	response := serveTokenRequest(t, r, "POST", "/add", alice, record)
+	if response.SrvCode != services.OK {
+		t.Fatalf("adding a record failed: %+v", response)
+	}
+	sid := response.Results.Records[0]["sid"].(string)
+
+	records := searchTestService(t, r, `{"created_by.user": "alice", "created_by.client": "spec"}`)
+	if len(records) != 1 {
+		t.Fatalf("found %d records added by alice; want 1", len(records))
+	}
+	if created_at, _ := records[0]["created_at"].(float64); created_at < before || records[0]["updated_at"] != created_at {
+		t.Errorf("record added at %v was given created_at %v and updated_at %v", before, records[0]["created_at"], records[0]["updated_at"])
+	}
+	if found := searchTestService(t, r, `{"created_by.user": "mallory"}`); len(found) != 0 {
+		t.Errorf("a spoofed created_by was recorded")
+	}
+
+	response = serveTokenRequest(t, r, "PUT", "/edit", bob, map[string]any{"sid": sid, "command": "dscan samx 0 1 10 1"})
+	if response.SrvCode != services.OK {
+		t.Fatalf("editing a record failed: %+v", response)
+	}
+	records = searchTestService(t, r, `{"updated_by.user": "bob"}`)
+	if len(records) != 1 {
+		t.Fatalf("found %d records changed by bob; want 1", len(records))
+	}
+	created_by, _ := records[0]["created_by"].(map[string]any)
+	if created_by["user"] != "alice" || records[0]["updated_at"].(float64) < records[0]["created_at"].(float64) {
+		t.Errorf("edited record has provenance %+v", records[0])
+	}
+	updated := ScanMotors.(*MemoryMotorStore).updated[sid]
+	if updated.By.User != "bob" {
+		t.Errorf("motor store recorded update by %+v; want bob", updated.By)
+	}
+
+	for _, key := range ProvenanceKeys {
+		response = serveTokenRequest(t, r, "PUT", "/edit", bob, map[string]any{"sid": sid, key: "mallory"})
+		if response.SrvCode == services.OK {
+			t.Errorf("editing %s was accepted", key)
+		}
+	}
+
+	response = serveTokenRequest(t, r, "POST", "/status", alice, StatusTransition{ScanId: sid, Status: StatusCompleted})
+	if response.SrvCode != services.OK {
+		t.Fatalf("completing a scan failed: %+v", response)
+	}
+	if found := searchTestService(t, r, `{"updated_by.user": "alice"}`); len(found) != 1 {
+		t.Errorf("status transition did not record who made it")
+	}
+}
+
+// Test that the motors database records who added and changed scans
+func TestSetScanUpdated(t *testing.T) {
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	record := MotorRecord{ScanId: "sid1", Beamline: "3a", Motors: map[string]float64{"samx": 1},
+		CreatedBy: Identity{User: "alice", Client: "spec"}, CreatedAt: 100}
+	if _, err := InsertMotors(record, db, SQLiteDialect); err != nil {
+		t.Fatalf("InsertMotors error: %v", err)
+	}
+	if err := SetScanUpdated("sid1", Identity{User: "bob"}, 200, db, SQLiteDialect); err != nil {
+		t.Fatalf("SetScanUpdated error: %v", err)
+	}
+	var created_by, created_client, updated_by string
+	var created_at, updated_at float64
+	row := db.QueryRow("SELECT created_by, created_client, created_at, updated_by, updated_at FROM ScanIds WHERE sid = ?", "sid1")
+	if err := row.Scan(&created_by, &created_client, &created_at, &updated_by, &updated_at); err != nil {
+		t.Fatalf("Scan error: %v", err)
+	}
+	if created_by != "alice" || created_client != "spec" || created_at != 100 || updated_by != "bob" || updated_at != 200 {
+		t.Errorf("ScanIds has provenance %s %s %v %s %v", created_by, created_client, created_at, updated_by, updated_at)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Categories of inconsistencies between the scan document and motor stores
+const (
+	MissingMotors  = "missing_motors"  // documents whose scan id is not in the motor store
+	OrphanMotors   = "orphan_motors"   // motor store scans without a document
+	DuplicateScans = "duplicate_scans" // documents sharing a spec_file and scan_number
+	UnscopedMotors = "unscoped_motors" // scans whose motors are not those of the document's beamline
+)
+
+// Reconcile modes: report inconsistencies only, fix them in place where
+// possible, or move every offender to the quarantine store
+const (
+	ReconcileReport     = "report"
+	ReconcileRepair     = "repair"
+	ReconcileQuarantine = "quarantine"
+)
+
+var ReconcileModes = []string{ReconcileReport, ReconcileRepair, ReconcileQuarantine}
+
+// Number of documents read from the document store at a time
+var ReconcileBatchSize = 1000
+
+// var QuarantineDocs holds the records reconcile took out of the stores
+var QuarantineDocs DocumentStore
+
+// Inconsistency is a single problem found by reconcile, with the action
+// taken on it (if any)
+type Inconsistency struct {
+	Category    string `json:"category"`
+	ScanId      string `json:"sid"`
+	SpecFile    string `json:"spec_file,omitempty"`
+	ScanNumber  any    `json:"scan_number,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	Action      string `json:"action,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ConsistencyReport is the result of a reconcile run
+type ConsistencyReport struct {
+	Mode            string                     `json:"mode"`
+	Documents       int                        `json:"documents"`
+	MotorScans      int                        `json:"motor_scans"`
+	Inconsistencies map[string][]Inconsistency `json:"inconsistencies"`
+}
+
+// Number of inconsistencies found in all categories
+func (r ConsistencyReport) Total() int {
+	n := 0
+	for _, items := range r.Inconsistencies {
+		n += len(items)
+	}
+	return n
+}
+
+// Summary of a scan document kept while streaming the document store
+type scanDocument struct {
+	ScanId     string
+	SpecFile   string
+	ScanNumber any
+	Beamline   string
+}
+
+// Stream both stores, report every inconsistency between them by category
+// and, depending on mode, repair or quarantine the offenders.
+func Reconcile(mode string, docs DocumentStore, motors MotorStore, quarantine DocumentStore) (ConsistencyReport, error) {
+	report := ConsistencyReport{
+		Mode: mode,
+		Inconsistencies: map[string][]Inconsistency{
+			MissingMotors:  {},
+			OrphanMotors:   {},
+			DuplicateScans: {},
+			UnscopedMotors: {},
+		},
+	}
+	if !inList(mode, ReconcileModes) {
+		return report, fmt.Errorf("[SpecScansService.main.Reconcile] unknown mode %q, must be one of %v", mode, ReconcileModes)
+	}
+	if mode != ReconcileReport && quarantine == nil {
+		return report, fmt.Errorf("[SpecScansService.main.Reconcile] no quarantine store is configured")
+	}
+
+	var motor_sids []string
+	has_motors := make(map[string]bool)
+	motor_beamlines := make(map[string]string)
+	err := motors.ForEachScan(func(sid string, beamline string) error {
+		motor_sids = append(motor_sids, sid)
+		has_motors[sid] = true
+		motor_beamlines[sid] = beamline
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("[SpecScansService.main.Reconcile] motors.ForEachScan error: %w", err)
+	}
+	report.MotorScans = len(motor_sids)
+
+	has_document := make(map[string]bool)
+	var missing []scanDocument
+	var unscoped []scanDocument
+	var scan_keys []string
+	scans := make(map[string][]scanDocument)
+	for idx := 0; ; idx += ReconcileBatchSize {
+		records, err := docs.Get(map[string]any{}, idx, ReconcileBatchSize)
+		if err != nil {
+			return report, fmt.Errorf("[SpecScansService.main.Reconcile] docs.Get error: %w", err)
+		}
+		for _, record := range records {
+			doc := scanDocument{
+				ScanId:     fmt.Sprint(record["sid"]),
+				SpecFile:   fmt.Sprint(record["spec_file"]),
+				ScanNumber: record["scan_number"],
+			}
+			doc.Beamline, _ = record["beamline"].(string)
+			has_document[doc.ScanId] = true
+			if !has_motors[doc.ScanId] {
+				missing = append(missing, doc)
+			} else if motor_beamlines[doc.ScanId] != doc.Beamline {
+				unscoped = append(unscoped, doc)
+			}
+			key := fmt.Sprintf("%s#%v", doc.SpecFile, doc.ScanNumber)
+			if _, ok := scans[key]; !ok {
+				scan_keys = append(scan_keys, key)
+			}
+			scans[key] = append(scans[key], doc)
+		}
+		report.Documents += len(records)
+		if len(records) < ReconcileBatchSize {
+			break
+		}
+	}
+
+	// Duplicates are handled first so that their extra documents are not
+	// repaired as well
+	quarantined := make(map[string]bool)
+	for _, key := range scan_keys {
+		if len(scans[key]) < 2 {
+			continue
+		}
+		items := reconcileDuplicates(mode, scans[key], docs, motors, quarantine, has_motors)
+		for _, item := range items {
+			if item.Action == "quarantined" {
+				quarantined[item.ScanId] = true
+			}
+		}
+		report.Inconsistencies[DuplicateScans] = append(report.Inconsistencies[DuplicateScans], items...)
+	}
+	for _, doc := range missing {
+		item := Inconsistency{Category: MissingMotors, ScanId: doc.ScanId, SpecFile: doc.SpecFile, ScanNumber: doc.ScanNumber}
+		var err error
+		switch {
+		case mode == ReconcileReport:
+		case quarantined[doc.ScanId]:
+			item.Action = "quarantined as a duplicate"
+		case mode == ReconcileRepair:
+			// The motor positions are lost, but registering the scan id (at
+			// the document's beamline) keeps it from being reused
+			_, err = motors.InsertMotors(MotorRecord{ScanId: doc.ScanId, Beamline: doc.Beamline})
+			item.Action = "registered scan id without motor positions"
+		case mode == ReconcileQuarantine:
+			err = quarantineScan(MissingMotors, doc.ScanId, docs, nil, quarantine)
+			item.Action = "quarantined"
+		}
+		recordAction(&item, err)
+		report.Inconsistencies[MissingMotors] = append(report.Inconsistencies[MissingMotors], item)
+	}
+	for _, doc := range unscoped {
+		item := Inconsistency{Category: UnscopedMotors, ScanId: doc.ScanId, SpecFile: doc.SpecFile, ScanNumber: doc.ScanNumber}
+		var err error
+		switch {
+		case mode == ReconcileReport:
+		case quarantined[doc.ScanId]:
+			item.Action = "quarantined as a duplicate"
+		default:
+			// Scoping motors loses nothing, so quarantine mode repairs too
+			err = motors.SetScanBeamline(doc.ScanId, doc.Beamline)
+			item.Action = "moved to the motors of beamline " + doc.Beamline
+		}
+		recordAction(&item, err)
+		report.Inconsistencies[UnscopedMotors] = append(report.Inconsistencies[UnscopedMotors], item)
+	}
+	for _, sid := range motor_sids {
+		if has_document[sid] {
+			continue
+		}
+		item := Inconsistency{Category: OrphanMotors, ScanId: sid}
+		var err error
+		if mode != ReconcileReport {
+			// Skip scans whose document was added since the store was read
+			// (addRecord inserts motor positions first)
+			if n, err := docs.Count(map[string]any{"sid": sid}); err == nil && n > 0 {
+				item.Action = "skipped, document has been added since"
+				report.Inconsistencies[OrphanMotors] = append(report.Inconsistencies[OrphanMotors], item)
+				continue
+			}
+		}
+		switch mode {
+		case ReconcileRepair:
+			err = motors.RemoveMotors(sid)
+			item.Action = "removed"
+		case ReconcileQuarantine:
+			err = quarantineScan(OrphanMotors, sid, nil, motors, quarantine)
+			item.Action = "quarantined"
+		}
+		recordAction(&item, err)
+		report.Inconsistencies[OrphanMotors] = append(report.Inconsistencies[OrphanMotors], item)
+	}
+	log.Printf("Reconcile (%s): %d documents, %d motor scans, %d inconsistencies",
+		mode, report.Documents, report.MotorScans, report.Total())
+	return report, nil
+}
+
+// Report the documents of one spec_file and scan_number beyond the one to
+// keep and, unless only reporting, quarantine them. The document kept is the
+// one with motor positions and the earliest start_time.
+func reconcileDuplicates(mode string, group []scanDocument, docs DocumentStore, motors MotorStore, quarantine DocumentStore, has_motors map[string]bool) []Inconsistency {
+	spec := map[string]any{"spec_file": group[0].SpecFile, "scan_number": group[0].ScanNumber}
+	records, err := docs.Get(spec, 0, 0)
+	if err == nil && len(records) < 2 {
+		// resolved since the store was read
+		return nil
+	}
+	if err != nil {
+		records = nil
+		for _, doc := range group {
+			records = append(records, map[string]any{"sid": doc.ScanId})
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		sid_i, sid_j := fmt.Sprint(records[i]["sid"]), fmt.Sprint(records[j]["sid"])
+		if has_motors[sid_i] != has_motors[sid_j] {
+			return has_motors[sid_i]
+		}
+		time_i, _ := records[i]["start_time"].(float64)
+		time_j, _ := records[j]["start_time"].(float64)
+		if time_i != time_j {
+			return time_i < time_j
+		}
+		return sid_i < sid_j
+	})
+	kept := records[0]
+	kept_sid := fmt.Sprint(kept["sid"])
+	var items []Inconsistency
+	quarantined := true
+	shares_sid := false
+	for _, record := range records[1:] {
+		sid := fmt.Sprint(record["sid"])
+		item := Inconsistency{
+			Category:    DuplicateScans,
+			ScanId:      sid,
+			SpecFile:    group[0].SpecFile,
+			ScanNumber:  group[0].ScanNumber,
+			DuplicateOf: kept_sid,
+		}
+		if err != nil {
+			recordAction(&item, err)
+			items = append(items, item)
+			continue
+		}
+		if mode != ReconcileReport {
+			// Duplicates cannot be repaired in place, so both modes take the
+			// extra documents out of the store
+			var qerr error
+			var motor_records []MotorRecord
+			if sid != kept_sid && has_motors[sid] {
+				motor_records, qerr = motors.QueryMotors(MotorsDbQuery{Sids: []string{sid}})
+			}
+			if qerr == nil {
+				qerr = quarantine.Insert(quarantineRecord(DuplicateScans, sid, record, motor_records))
+			}
+			if qerr == nil && sid != kept_sid && has_motors[sid] {
+				qerr = motors.RemoveMotors(sid)
+			}
+			item.Action = "quarantined"
+			recordAction(&item, qerr)
+			quarantined = quarantined && qerr == nil
+			shares_sid = shares_sid || sid == kept_sid
+		}
+		items = append(items, item)
+	}
+	if mode == ReconcileReport || err != nil {
+		return items
+	}
+	if !quarantined {
+		// Documents are only removed once all of them have a copy in the
+		// quarantine store
+		for i := range items {
+			if items[i].Error == "" {
+				items[i].Action = "copied to quarantine, not removed"
+			}
+		}
+		return items
+	}
+	scan_spec := func(sid string) map[string]any {
+		return map[string]any{"spec_file": spec["spec_file"], "scan_number": spec["scan_number"], "sid": sid}
+	}
+	for i := range items {
+		if items[i].ScanId != kept_sid {
+			recordAction(&items[i], docs.Remove(scan_spec(items[i].ScanId)))
+		}
+	}
+	if shares_sid {
+		// Extra documents sharing the kept document's scan id cannot be told
+		// apart from it, so they are removed together and the kept document
+		// put back, with a copy in the quarantine store until it is
+		backup := quarantineRecord(DuplicateScans, kept_sid, kept, nil)
+		backup["kept"] = true
+		err = quarantine.Insert(backup)
+		if err == nil {
+			err = docs.Remove(scan_spec(kept_sid))
+		}
+		if err == nil {
+			err = docs.Insert(kept)
+		}
+		if err == nil {
+			err = quarantine.Remove(map[string]any{"category": DuplicateScans, "sid": kept_sid, "kept": true})
+		}
+		for i := range items {
+			if items[i].ScanId == kept_sid {
+				recordAction(&items[i], err)
+			}
+		}
+	}
+	return items
+}
+
+// Move a scan's document (if docs is given) or motor positions (if motors is
+// given) to the quarantine store
+func quarantineScan(category string, sid string, docs DocumentStore, motors MotorStore, quarantine DocumentStore) error {
+	spec := map[string]any{"sid": sid}
+	var documents []map[string]any
+	var motor_records []MotorRecord
+	var err error
+	if docs != nil {
+		documents, err = docs.Get(spec, 0, 0)
+	} else {
+		motor_records, err = motors.QueryMotors(MotorsDbQuery{Sids: []string{sid}})
+	}
+	if err != nil {
+		return err
+	}
+	if len(documents) == 0 {
+		documents = append(documents, nil)
+	}
+	for _, document := range documents {
+		err = quarantine.Insert(quarantineRecord(category, sid, document, motor_records))
+		if err != nil {
+			return err
+		}
+	}
+	if docs != nil {
+		return docs.Remove(spec)
+	}
+	return motors.RemoveMotors(sid)
+}
+
+// Return the quarantine store record of a document and/or motor positions
+func quarantineRecord(category string, sid string, document map[string]any, motor_records []MotorRecord) map[string]any {
+	record := map[string]any{
+		"category":       category,
+		"sid":            sid,
+		"quarantined_at": time.Now().Unix(),
+	}
+	if document != nil {
+		copied := make(map[string]any, len(document))
+		for k, v := range document {
+			if k != "_id" {
+				copied[k] = v
+			}
+		}
+		record["document"] = copied
+	}
+	if len(motor_records) > 0 {
+		record["motors"] = motor_records[0].Motors
+	}
+	return record
+}
+
+func recordAction(item *Inconsistency, err error) {
+	if err != nil {
+		item.Action = "failed"
+		item.Error = err.Error()
+	}
+}
+
+// Handler for checking (and optionally repairing) the consistency of the
+// document and motor stores; only available to admins
+func ReconcileHandler(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	mode := c.DefaultQuery("mode", ReconcileReport)
+	if !inList(mode, ReconcileModes) {
+		err := fmt.Errorf("unknown mode %q, must be one of %v", mode, ReconcileModes)
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	stores := auditedStores(ProductionStores(), requestAuditTrail(c))
+	report, err := Reconcile(mode, stores.Docs, stores.Motors, QuarantineDocs)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DatabaseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+)
+
+// Helper to set up stores with one consistent scan (sid 1), a document
+// without motors (2), motors without a document (3) and two documents of the
+// same scan (4 and 5)
+func setupInconsistentStores(t *testing.T) (*MemoryDocumentStore, *MemoryMotorStore) {
+	docs := NewMemoryDocumentStore()
+	motors := NewMemoryMotorStore()
+	err := docs.Insert(
+		map[string]any{"sid": "1", "spec_file": "/data/a", "scan_number": 1, "start_time": 1.0},
+		map[string]any{"sid": "2", "spec_file": "/data/a", "scan_number": 2, "start_time": 2.0, "beamline": "3a"},
+		map[string]any{"sid": "4", "spec_file": "/data/b", "scan_number": 1, "start_time": 4.0},
+		map[string]any{"sid": "5", "spec_file": "/data/b", "scan_number": 1, "start_time": 5.0},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sid := range []string{"1", "3", "4", "5"} {
+		if _, err := motors.InsertMotors(MotorRecord{ScanId: sid, Motors: map[string]float64{"samx": 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return docs, motors
+}
+
+// Helper to get the scan ids reported in each category
+func reportedSids(report ConsistencyReport) map[string][]string {
+	sids := make(map[string][]string)
+	for category, items := range report.Inconsistencies {
+		for _, item := range items {
+			sids[category] = append(sids[category], item.ScanId)
+		}
+	}
+	return sids
+}
+
+// Test reporting, repairing and quarantining inconsistencies between the
+// document and motor stores
+func TestReconcile(t *testing.T) {
+	ReconcileBatchSize = 2
+	defer func() { ReconcileBatchSize = 1000 }()
+
+	docs, motors := setupInconsistentStores(t)
+	report, err := Reconcile(ReconcileReport, docs, motors, nil)
+	if err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	if report.Documents != 4 || report.MotorScans != 4 {
+		t.Errorf("Reconcile read %d documents and %d motor scans; want 4 and 4", report.Documents, report.MotorScans)
+	}
+	sids := reportedSids(report)
+	if len(sids[MissingMotors]) != 1 || sids[MissingMotors][0] != "2" ||
+		len(sids[OrphanMotors]) != 1 || sids[OrphanMotors][0] != "3" ||
+		len(sids[DuplicateScans]) != 1 || sids[DuplicateScans][0] != "5" {
+		t.Errorf("Reconcile reported %v", sids)
+	}
+	if report.Inconsistencies[DuplicateScans][0].DuplicateOf != "4" {
+		t.Errorf("duplicate reported as a duplicate of %s; want 4", report.Inconsistencies[DuplicateScans][0].DuplicateOf)
+	}
+
+	// Repair
+	report, err = Reconcile(ReconcileRepair, docs, motors, NewMemoryDocumentStore())
+	if err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	for category, items := range report.Inconsistencies {
+		for _, item := range items {
+			if item.Error != "" {
+				t.Errorf("%s %s: %s", category, item.ScanId, item.Error)
+			}
+		}
+	}
+	report, _ = Reconcile(ReconcileReport, docs, motors, nil)
+	if report.Total() != 0 {
+		t.Errorf("Reconcile found %v after repairing", reportedSids(report))
+	}
+
+	// Quarantine
+	docs, motors = setupInconsistentStores(t)
+	quarantine := NewMemoryDocumentStore()
+	_, err = Reconcile(ReconcileQuarantine, docs, motors, quarantine)
+	if err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	report, _ = Reconcile(ReconcileReport, docs, motors, nil)
+	if report.Total() != 0 || report.Documents != 2 || report.MotorScans != 2 {
+		t.Errorf("Reconcile found %v in %d documents and %d motor scans after quarantining",
+			reportedSids(report), report.Documents, report.MotorScans)
+	}
+	for category, sid := range map[string]string{MissingMotors: "2", OrphanMotors: "3", DuplicateScans: "5"} {
+		if n, _ := quarantine.Count(map[string]any{"category": category, "sid": sid}); n != 1 {
+			t.Errorf("%s scan %s was not quarantined", category, sid)
+		}
+	}
+	if n, _ := quarantine.Count(map[string]any{"sid": "5", "motors.samx": 1}); n != 1 {
+		t.Errorf("motor positions of the duplicate were not quarantined")
+	}
+}
+
+// failingDocumentStore is a document store failing to insert documents
+type failingDocumentStore struct {
+	DocumentStore
+}
+
+func (s failingDocumentStore) Insert(records ...map[string]any) error {
+	return errors.New("insert failed")
+}
+
+// Test that duplicates are only removed once they are copied to quarantine,
+// and that duplicates sharing the kept document's scan id keep one document
+func TestReconcileDuplicates(t *testing.T) {
+	docs, motors := setupInconsistentStores(t)
+	report, err := Reconcile(ReconcileQuarantine, docs, motors, failingDocumentStore{NewMemoryDocumentStore()})
+	if err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	if items := report.Inconsistencies[DuplicateScans]; len(items) != 1 || items[0].Action != "failed" {
+		t.Errorf("Reconcile reported duplicates %+v", items)
+	}
+	if n, _ := docs.Count(map[string]any{"spec_file": "/data/b"}); n != 2 {
+		t.Errorf("%d documents of the duplicated scan are left after failing to quarantine them; want 2", n)
+	}
+
+	docs = NewMemoryDocumentStore()
+	motors = NewMemoryMotorStore()
+	err = docs.Insert(
+		map[string]any{"sid": "1", "spec_file": "/data/a", "scan_number": 1, "start_time": 1.0},
+		map[string]any{"sid": "1", "spec_file": "/data/a", "scan_number": 1, "start_time": 2.0},
+		map[string]any{"sid": "2", "spec_file": "/data/a", "scan_number": 1, "start_time": 3.0},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	motors.InsertMotors(MotorRecord{ScanId: "1", Motors: map[string]float64{"samx": 1}})
+	quarantine := NewMemoryDocumentStore()
+	report, err = Reconcile(ReconcileRepair, docs, motors, quarantine)
+	if err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	if sids := reportedSids(report); len(sids[DuplicateScans]) != 2 {
+		t.Errorf("Reconcile reported %v", sids)
+	}
+	kept, _ := docs.Get(map[string]any{}, 0, 0)
+	if len(kept) != 1 || kept[0]["sid"] != "1" || kept[0]["start_time"] != 1.0 {
+		t.Errorf("documents left after repairing duplicates: %v", kept)
+	}
+	if n, _ := quarantine.Count(map[string]any{"category": DuplicateScans}); n != 2 {
+		t.Errorf("%d duplicates were quarantined; want 2", n)
+	}
+}
+
+// Test that only admins may use the reconcile endpoint
+func TestReconcileHandler(t *testing.T) {
+	r := SetupTestService(t)
+	srvConfig.Config.Authz.ClientID = "test"
+	srvConfig.Config.AccessRules.AdminGroup = "foxdenadmins"
+	QuarantineDocs = NewMemoryDocumentStore()
+
+	tests := []struct {
+		groups   []string
+		expected int
+	}{
+		{groups: []string{"users"}, expected: http.StatusForbidden},
+		{groups: []string{"users", "foxdenadmins"}, expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		token, err := authz.JWTAccessToken("test", time.Now().Add(time.Hour).Unix(),
+			authz.CustomClaims{User: "user", Scope: "write", Groups: tt.groups})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/reconcile?mode=report", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.expected {
+			t.Errorf("reconcile by groups %v returned %d; want %d: %s", tt.groups, w.Code, tt.expected, w.Body.String())
+		}
+	}
+}
+
+// Test moving the motors of scans recorded before motors were scoped by
+// beamline to the beamline of their documents
+func TestReconcileUnscopedMotors(t *testing.T) {
+	docs := NewMemoryDocumentStore()
+	motors := NewMemoryMotorStore()
+	err := docs.Insert(
+		map[string]any{"sid": "1", "spec_file": "/data/a", "scan_number": 1, "beamline": "3a"},
+		map[string]any{"sid": "2", "spec_file": "/data/a", "scan_number": 2, "beamline": "3a"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	motors.InsertMotors(MotorRecord{ScanId: "1", Motors: map[string]float64{"samx": 1}})
+	motors.InsertMotors(MotorRecord{ScanId: "2", Beamline: "3a", Motors: map[string]float64{"samx": 1}})
+
+	report, err := Reconcile(ReconcileReport, docs, motors, nil)
+	if err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	sids := reportedSids(report)
+	if report.Total() != 1 || len(sids[UnscopedMotors]) != 1 || sids[UnscopedMotors][0] != "1" {
+		t.Errorf("Reconcile reported %v", sids)
+	}
+	if _, err = Reconcile(ReconcileQuarantine, docs, motors, NewMemoryDocumentStore()); err != nil {
+		t.Fatalf("Reconcile error: %v", err)
+	}
+	found, _ := motors.QueryMotors(MotorsDbQuery{MotorPositionQueries: []MotorPositionQuery{{Mne: "samx", Beamlines: []string{"3a"}}}})
+	if len(found) != 2 {
+		t.Errorf("found motors of %d scans at beamline 3a after reconciling; want 2", len(found))
+	}
+	if n, _ := docs.Count(map[string]any{}); n != 2 {
+		t.Errorf("%d documents are left after reconciling; want 2", n)
+	}
+}
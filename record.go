@@ -3,17 +3,19 @@ package main
 import (
 	"fmt"
 	"log"
-	"strconv"
-	"time"
+	"sync"
 
 	schema "github.com/CHESSComputing/golib/beamlines"
 	srvConfig "github.com/CHESSComputing/golib/config"
-	mongo "github.com/CHESSComputing/golib/mongo"
 	mapstructure "github.com/mitchellh/mapstructure"
 )
 
 var Schema *schema.Schema
 
+// var schemaMutex serializes the uses of Schema, which reloads its fields
+// from the schema cache every time it validates a record
+var schemaMutex sync.Mutex
+
 type UserRecord struct {
 	ScanId      string             `json:"sid,omitempty" mapstructure:"sid,omitempty"`
 	DatasetId   string             `json:"did" mapstructure:"did"`
@@ -25,11 +27,22 @@ type UserRecord struct {
 	StartTime   float64            `json:"start_time" mapstructure:"start_time"`
 	Command     string             `json:"command" mapstructure:"command"`
 	Status      string             `json:"status" mapstructure:"status"`
+	EndTime     float64            `json:"end_time,omitempty" mapstructure:"end_time,omitempty"`
+	Duration    float64            `json:"duration,omitempty" mapstructure:"duration,omitempty"`
 	Comments    []string           `json:"comments" mapstructure:"comments"`
 	Userlines   []string           `json:"userlines" mapstructure:"userlines"`
 	SpecVersion string             `json:"spec_version" mapstructure:"spec_version"`
 	Motors      map[string]float64 `json:"motors" mapstructure:"motors"`
 	Variables   map[string]any     `json:"variables" mapstructure:"variables"`
+
+	StatusHistory []StatusChange `json:"status_history,omitempty" mapstructure:"status_history,omitempty"`
+	CreatedBy     *Identity      `json:"created_by,omitempty" mapstructure:"created_by,omitempty"`
+	CreatedAt     float64        `json:"created_at,omitempty" mapstructure:"created_at,omitempty"`
+	UpdatedBy     *Identity      `json:"updated_by,omitempty" mapstructure:"updated_by,omitempty"`
+	UpdatedAt     float64        `json:"updated_at,omitempty" mapstructure:"updated_at,omitempty"`
+	EmbargoUntil  float64        `json:"embargo_until,omitempty" mapstructure:"embargo_until,omitempty"`
+	DeletedAt     float64        `json:"deleted_at,omitempty" mapstructure:"deleted_at,omitempty"`
+	DeletedBy     *Identity      `json:"deleted_by,omitempty" mapstructure:"deleted_by,omitempty"`
 }
 
 type MongoRecord struct {
@@ -43,10 +56,21 @@ type MongoRecord struct {
 	StartTime   float64        `json:"start_time" mapstructure:"start_time"`
 	Command     string         `json:"command" mapstructure:"command"`
 	Status      string         `json:"status" mapstructure:"status"`
+	EndTime     float64        `json:"end_time,omitempty" mapstructure:"end_time,omitempty"`
+	Duration    float64        `json:"duration,omitempty" mapstructure:"duration,omitempty"`
 	Comments    []string       `json:"comments" mapstructure:"comments"`
 	Userlines   []string       `json:"userlines" mapstructure:"userlines"`
 	SpecVersion string         `json:"spec_version" mapstructure:"spec_version"`
 	Variables   map[string]any `json:"variables" mapstructure:"variables"`
+
+	StatusHistory []StatusChange `json:"status_history,omitempty" mapstructure:"status_history,omitempty"`
+	CreatedBy     *Identity      `json:"created_by,omitempty" mapstructure:"created_by,omitempty"`
+	CreatedAt     float64        `json:"created_at,omitempty" mapstructure:"created_at,omitempty"`
+	UpdatedBy     *Identity      `json:"updated_by,omitempty" mapstructure:"updated_by,omitempty"`
+	UpdatedAt     float64        `json:"updated_at,omitempty" mapstructure:"updated_at,omitempty"`
+	EmbargoUntil  float64        `json:"embargo_until,omitempty" mapstructure:"embargo_until,omitempty"`
+	DeletedAt     float64        `json:"deleted_at,omitempty" mapstructure:"deleted_at,omitempty"`
+	DeletedBy     *Identity      `json:"deleted_by,omitempty" mapstructure:"deleted_by,omitempty"`
 }
 
 func InitSchemaManager() {
@@ -60,19 +84,18 @@ func InitSchemaManager() {
 	log.Printf("schema: %v", Schema)
 }
 
+// Validate a record against Schema; safe to call from concurrent goroutines
+func validateSchema(record map[string]any) error {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+	return Schema.Validate(record)
+}
+
 // Decompose a user-submitted scan record into two portions: the portion to
 // reside in the MongoDB, and the motor positions (which will reside in the SQL
-// db).
-func DecomposeRecord(user_record UserRecord) (MongoRecord, MotorRecord) {
-	var scan_id string
-	if user_record.ScanId == "test" {
-		// This is a test record, so force a unique scan id
-		scan_id = strconv.Itoa(int(time.Now().UnixNano()))
-	} else {
-		scan_id = strconv.Itoa(int(user_record.StartTime * 1e9))
-	}
+// db). The record gets a new scan id generated with SidStrategy.
+func DecomposeRecord(user_record UserRecord) (MongoRecord, MotorRecord, error) {
 	mongo_record := MongoRecord{
-		ScanId:      scan_id,
 		DatasetId:   user_record.DatasetId,
 		Cycle:       user_record.Cycle,
 		Beamline:    user_record.Beamline,
@@ -82,16 +105,37 @@ func DecomposeRecord(user_record UserRecord) (MongoRecord, MotorRecord) {
 		StartTime:   user_record.StartTime,
 		Command:     user_record.Command,
 		Status:      user_record.Status,
+		EndTime:     user_record.EndTime,
+		Duration:    user_record.Duration,
 		Comments:    user_record.Comments,
 		Userlines:   user_record.Userlines,
 		SpecVersion: user_record.SpecVersion,
 		Variables:   user_record.Variables,
+
+		StatusHistory: user_record.StatusHistory,
+		CreatedBy:     user_record.CreatedBy,
+		CreatedAt:     user_record.CreatedAt,
+		UpdatedBy:     user_record.UpdatedBy,
+		UpdatedAt:     user_record.UpdatedAt,
+		EmbargoUntil:  user_record.EmbargoUntil,
+		DeletedAt:     user_record.DeletedAt,
+		DeletedBy:     user_record.DeletedBy,
+	}
+	scan_id, err := NewScanId(SidStrategy, mongo_record)
+	if err != nil {
+		return mongo_record, MotorRecord{}, fmt.Errorf("[SpecScansService.main.DecomposeRecord] NewScanId error: %w", err)
 	}
+	mongo_record.ScanId = scan_id
 	motor_record := MotorRecord{
-		ScanId: scan_id,
-		Motors: user_record.Motors,
+		ScanId:    mongo_record.ScanId,
+		Beamline:  user_record.Beamline,
+		Motors:    user_record.Motors,
+		CreatedAt: user_record.CreatedAt,
+	}
+	if user_record.CreatedBy != nil {
+		motor_record.CreatedBy = *user_record.CreatedBy
 	}
-	return mongo_record, motor_record
+	return mongo_record, motor_record, nil
 }
 
 // Combine a partial scan record with its motor positions, return the completed record
@@ -107,17 +151,29 @@ func CompleteRecord(mongo_record MongoRecord, motor_record MotorRecord) UserReco
 		StartTime:   mongo_record.StartTime,
 		Command:     mongo_record.Command,
 		Status:      mongo_record.Status,
+		EndTime:     mongo_record.EndTime,
+		Duration:    mongo_record.Duration,
 		Comments:    mongo_record.Comments,
 		Userlines:   mongo_record.Userlines,
 		SpecVersion: mongo_record.SpecVersion,
 		Motors:      motor_record.Motors,
 		Variables:   mongo_record.Variables,
+
+		StatusHistory: mongo_record.StatusHistory,
+		CreatedBy:     mongo_record.CreatedBy,
+		CreatedAt:     mongo_record.CreatedAt,
+		UpdatedBy:     mongo_record.UpdatedBy,
+		UpdatedAt:     mongo_record.UpdatedAt,
+		EmbargoUntil:  mongo_record.EmbargoUntil,
+		DeletedAt:     mongo_record.DeletedAt,
+		DeletedBy:     mongo_record.DeletedBy,
 	}
 	return record
 }
 
-// Return the completed UserRecords corresponding to the MongoRecords provided
-func CompleteMongoRecords(mongo_records ...MongoRecord) ([]UserRecord, error) {
+// Return the completed UserRecords corresponding to the MongoRecords provided,
+// with motor positions from the given store
+func CompleteMongoRecords(motors MotorStore, mongo_records ...MongoRecord) ([]UserRecord, error) {
 	var user_records []UserRecord
 	if len(mongo_records) == 0 {
 		return user_records, nil
@@ -126,7 +182,7 @@ func CompleteMongoRecords(mongo_records ...MongoRecord) ([]UserRecord, error) {
 	for _, mongo_record := range mongo_records {
 		sids = append(sids, mongo_record.ScanId)
 	}
-	motor_records, err := GetMotorRecords(sids...)
+	motor_records, err := GetMotorRecords(motors, sids...)
 	if err != nil {
 		return user_records, fmt.Errorf("[SpecScansService.main.CompleteMongoRecords] GetMotorRecords error: %w", err)
 	}
@@ -140,8 +196,9 @@ func CompleteMongoRecords(mongo_records ...MongoRecord) ([]UserRecord, error) {
 	return user_records, nil
 }
 
-// Return the completed UserRecords correcponding to the MotorRecords provided
-func CompleteMotorRecords(motor_records ...MotorRecord) ([]UserRecord, error) {
+// Return the completed UserRecords correcponding to the MotorRecords provided,
+// with documents from the given store
+func CompleteMotorRecords(docs DocumentStore, motor_records ...MotorRecord) ([]UserRecord, error) {
 	var user_records []UserRecord
 	if len(motor_records) == 0 {
 		return user_records, nil
@@ -151,7 +208,10 @@ func CompleteMotorRecords(motor_records ...MotorRecord) ([]UserRecord, error) {
 		sids = append(sids, motor_record.ScanId)
 	}
 	mongo_query := map[string]any{"sid": map[string]any{"$in": sids}}
-	mongo_records := mongo.Get(srvConfig.Config.SpecScans.MongoDB.DBName, srvConfig.Config.SpecScans.MongoDB.DBColl, mongo_query, 0, 0)
+	mongo_records, err := docs.Get(mongo_query, 0, 0)
+	if err != nil {
+		return user_records, fmt.Errorf("[SpecScansService.main.CompleteMotorRecords] docs.Get error: %w", err)
+	}
 	for _, mongo_record_map := range mongo_records {
 		var mongo_record MongoRecord
 		err := mapstructure.Decode(mongo_record_map, &mongo_record)
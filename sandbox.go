@@ -0,0 +1,227 @@
+package main
+
+// sandbox module
+//
+// Test records go to the sandbox, a namespace of scan records kept apart from
+// the production records: sandbox documents are in their own MongoDB
+// collection and sandbox motor positions in their own SQL database, so normal
+// searches never see them and they can be purged in bulk. Records with the
+// deprecated "test" sid of older clients go to the sandbox as well, and are
+// rejected if there is no sandbox. Sandbox records are read with the same
+// access rules as production records, and only staff may purge the records
+// of other BTRs than their own.
+//
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Scope of tokens whose requests always use the sandbox
+const SandboxScope = "sandbox"
+
+// var Sandbox holds the stores of sandbox records, or is nil if the sandbox
+// is not configured
+var Sandbox *ScanStores
+
+// Error of requests for the sandbox when it is not configured
+var ErrNoSandbox = errors.New("the sandbox is not configured")
+
+// Return the name of the file describing the sandbox SQL database (in the
+// format of the DBFile of the production database)
+func SandboxDBFile(dbfile string) string {
+	return dbfile + ".sandbox"
+}
+
+// InitSandbox sets up the sandbox stores if the sandbox SQL database is
+// configured; the sandbox is disabled otherwise
+func InitSandbox() {
+	dbfile := SandboxDBFile(srvConfig.Config.SpecScans.DBFile)
+	if _, err := os.Stat(dbfile); err != nil {
+		log.Printf("Sandbox is disabled: %s not found", dbfile)
+		return
+	}
+	db, dialect, err := OpenMotorsDb(dbfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Sandbox = &ScanStores{
+		Docs:      SandboxDocumentStore(),
+		Motors:    NewSQLMotorStore(db, dialect),
+		Variables: NewSQLVariableStore(db, dialect),
+		Embargoes: NewSQLEmbargoStore(db, dialect),
+	}
+}
+
+// Return the store of sandbox documents, in its own MongoDB collection (or in
+// memory, like production documents)
+func SandboxDocumentStore() DocumentStore {
+	if srvConfig.Config.SpecScans.MongoDB.DBUri == "memory" {
+		return NewMemoryDocumentStore()
+	}
+	dbname := srvConfig.Config.SpecScans.MongoDB.DBName
+	dbcoll := srvConfig.Config.SpecScans.MongoDB.DBColl
+	return NewMongoDocumentStore(dbname, dbcoll+"_sandbox")
+}
+
+// Return whether a request uses the sandbox: requests with a true "sandbox"
+// URL parameter do, and so do all requests made with a token having the
+// sandbox scope
+func isSandboxRequest(c *gin.Context) (bool, error) {
+	if value := c.Query("sandbox"); value != "" {
+		sandbox, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid sandbox parameter %q", value)
+		}
+		if sandbox {
+			return true, nil
+		}
+	}
+	claims, err := requestClaims(c)
+	if err != nil {
+		// Requests without a valid token are not sandbox requests; whether
+		// they are allowed at all is up to the authorization middleware
+		return false, nil
+	}
+	return isSandboxClaims(claims), nil
+}
+
+// Check whether the given claims are those of a token with the sandbox scope
+func isSandboxClaims(claims *authz.Claims) bool {
+	return inList(SandboxScope, strings.Fields(claims.CustomClaims.Scope))
+}
+
+// Return the stores of the sandbox
+func sandboxStores() (ScanStores, error) {
+	if Sandbox == nil {
+		return ScanStores{}, ErrNoSandbox
+	}
+	stores := *Sandbox
+	stores.Sandbox = true
+	return stores, nil
+}
+
+// Return the stores used by a request, those of the sandbox or of production,
+// recording the changes made through them in the request's audit trail
+func requestStores(c *gin.Context) (ScanStores, error) {
+	sandbox, err := isSandboxRequest(c)
+	if err != nil {
+		return ScanStores{}, err
+	}
+	return scanStores(sandbox, requestAuditTrail(c))
+}
+
+// Return the stores of the sandbox used by a request
+func requestSandboxStores(c *gin.Context) (ScanStores, error) {
+	return scanStores(true, requestAuditTrail(c))
+}
+
+// Return the stores of the sandbox or of production, recording the changes
+// made through them in the given audit trail
+func scanStores(sandbox bool, trail *AuditTrail) (ScanStores, error) {
+	if sandbox {
+		stores, err := sandboxStores()
+		if err != nil {
+			return stores, err
+		}
+		return auditedStores(stores, trail), nil
+	}
+	return auditedStores(ProductionStores(), trail), nil
+}
+
+// Abort a request whose stores could not be determined
+func abortStoresError(c *gin.Context, err error) {
+	resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+	c.AbortWithStatusJSON(http.StatusBadRequest, resp)
+}
+
+// Remove the records matching spec (all records if spec is empty) from both
+// stores of a namespace and return the number of documents removed. Purging
+// all records also removes motor positions which have no document.
+func PurgeRecords(stores ScanStores, spec map[string]any) (int, error) {
+	var sids []string
+	if len(spec) == 0 {
+		err := stores.Motors.ForEachScan(func(sid string, beamline string) error {
+			sids = append(sids, sid)
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("[SpecScansService.main.PurgeRecords] stores.Motors.ForEachScan error: %w", err)
+		}
+	}
+	records, err := stores.Docs.Get(spec, 0, 0)
+	if err != nil {
+		return 0, fmt.Errorf("[SpecScansService.main.PurgeRecords] stores.Docs.Get error: %w", err)
+	}
+	for _, record := range records {
+		if sid, ok := record["sid"].(string); ok {
+			sids = append(sids, sid)
+		}
+	}
+	// Motors go first, so a failure leaves documents without motors to be
+	// purged again rather than motors which no search finds
+	for _, sid := range sids {
+		if err = stores.Motors.RemoveMotors(sid); err != nil {
+			return 0, fmt.Errorf("[SpecScansService.main.PurgeRecords] stores.Motors.RemoveMotors error: %w", err)
+		}
+	}
+	if err = stores.Docs.Remove(spec); err != nil {
+		return 0, fmt.Errorf("[SpecScansService.main.PurgeRecords] stores.Docs.Remove error: %w", err)
+	}
+	return len(records), nil
+}
+
+// Handler for purging sandbox records, those of the beamlines, btrs and
+// cycles given by URL parameters or all of them. Callers other than staff
+// and admins only purge the records of the BTRs of their token.
+func PurgeSandboxHandler(c *gin.Context) {
+	claims, err := requestClaims(c)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	stores, err := requestSandboxStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	spec := map[string]any{}
+	for _, key := range []string{"beamline", "btr", "cycle"} {
+		var values []any
+		for _, value := range c.QueryArray(key) {
+			values = append(values, value)
+		}
+		if len(values) > 0 {
+			spec[key] = map[string]any{"$in": values}
+		}
+	}
+	if !isStaff(claims) && !isAdmin(claims) {
+		access := RecordAccess{Btrs: claims.CustomClaims.Btrs, IncludeDeleted: true}
+		spec = access.Restrict(spec)
+	}
+	purged, err := PurgeRecords(stores, spec)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DatabaseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	log.Printf("Purged %d sandbox records matching %v", purged, spec)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: purged,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
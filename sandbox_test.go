@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper to search the test service, in the sandbox or not
+func searchTestNamespace(t *testing.T, r *gin.Engine, url string, query string) int {
+	request := services.ServiceRequest{ServiceQuery: services.ServiceQuery{Query: query}}
+	response := serveTestRequest(t, r, "POST", url, request)
+	if response.HttpCode != http.StatusOK {
+		t.Fatalf("Search %s for %s failed: %+v", url, query, response)
+	}
+	return len(response.Results.Records)
+}
+
+// Test that sandbox records are kept apart from production records
+func TestSandbox(t *testing.T) {
+	r := SetupTestService(t)
+
+	response := serveTestRequest(t, r, "POST", "/add", testUserRecord(1, 1709647200, map[string]float64{"samx": 1}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding a production record failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "POST", "/add?sandbox=true", testUserRecord(2, 1709647260, map[string]float64{"samx": 2}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding a sandbox record failed: %+v", response)
+	}
+	// Records with the legacy "test" sid go to the sandbox too
+	legacy := testUserRecord(3, 1709647320, map[string]float64{"samx": 3})
+	legacy.ScanId = "test"
+	response = serveTestRequest(t, r, "POST", "/add", legacy)
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding a test record failed: %+v", response)
+	}
+
+	if n := searchTestNamespace(t, r, "/search", "{}"); n != 1 {
+		t.Errorf("search found %d production records; want 1", n)
+	}
+	if n := searchTestNamespace(t, r, "/search?sandbox=true", "{}"); n != 2 {
+		t.Errorf("search found %d sandbox records; want 2", n)
+	}
+	if n := searchTestNamespace(t, r, "/search?sandbox=true", `{"motors.samx": 1}`); n != 0 {
+		t.Errorf("sandbox search found %d production records by motor position", n)
+	}
+	if n := searchTestNamespace(t, r, "/search?sandbox=true", `{"motors.samx": 3}`); n != 1 {
+		t.Errorf("sandbox search found %d records by motor position; want 1", n)
+	}
+
+	// Sandbox records are read with the same access rules as production
+	// records, and callers other than staff only purge those of their BTRs
+	other := testUserRecord(5, 1709647440, map[string]float64{"samx": 5})
+	other.Btr = "other-456-b"
+	other.DatasetId = "/beamline=3a/btr=other-456-b/cycle=2024-1/sample_name=sample"
+	response = serveTestRequest(t, r, "POST", "/add?sandbox=true", other)
+	if response.SrvCode != services.OK {
+		t.Fatalf("Adding a sandbox record of another BTR failed: %+v", response)
+	}
+	user := testToken(t, authz.CustomClaims{User: "user", Scope: "read write", Btrs: []string{"other-456-b"}})
+	request := services.ServiceRequest{ServiceQuery: services.ServiceQuery{Query: "{}"}}
+	response = serveTokenRequest(t, r, "POST", "/search?sandbox=true", user, request)
+	if len(response.Results.Records) != 1 || response.Results.Records[0]["btr"] != "other-456-b" {
+		t.Errorf("sandbox search of a BTR member returned %+v", response)
+	}
+	response = serveTokenRequest(t, r, "DELETE", "/sandbox", user, nil)
+	if response.SrvCode != services.OK || response.Results.NRecords != 1 {
+		t.Errorf("purging the sandbox as a BTR member returned %+v", response)
+	}
+	if n := searchTestNamespace(t, r, "/search?sandbox=true", "{}"); n != 2 {
+		t.Errorf("search found %d sandbox records after a BTR member purged theirs; want 2", n)
+	}
+
+	// Purging the sandbox leaves production records alone
+	response = serveTestRequest(t, r, "DELETE", "/sandbox?beamline=1b", nil)
+	if response.SrvCode != services.OK || response.Results.NRecords != 0 {
+		t.Errorf("purging sandbox records of another beamline returned %+v", response)
+	}
+	response = serveTestRequest(t, r, "DELETE", "/sandbox", nil)
+	if response.SrvCode != services.OK || response.Results.NRecords != 2 {
+		t.Errorf("purging the sandbox returned %+v", response)
+	}
+	if n := searchTestNamespace(t, r, "/search?sandbox=true", "{}"); n != 0 {
+		t.Errorf("search found %d sandbox records after purging", n)
+	}
+	if n := searchTestNamespace(t, r, "/search", "{}"); n != 1 {
+		t.Errorf("search found %d production records after purging the sandbox; want 1", n)
+	}
+
+	// Without a sandbox, requests for it fail instead of using production
+	Sandbox = nil
+	response = serveTestRequest(t, r, "POST", "/add?sandbox=true", testUserRecord(4, 1709647380, nil))
+	if response.HttpCode != http.StatusBadRequest {
+		t.Errorf("adding a sandbox record without a sandbox returned %+v", response)
+	}
+	response = serveTestRequest(t, r, "POST", "/add", legacy)
+	if response.HttpCode != http.StatusBadRequest {
+		t.Errorf("adding a test record without a sandbox returned %+v", response)
+	}
+	if n := searchTestNamespace(t, r, "/search", "{}"); n != 1 {
+		t.Errorf("search found %d production records after adding a test record without a sandbox; want 1", n)
+	}
+}
+
+// Test that requests made with a sandbox token always use the sandbox
+func TestSandboxScope(t *testing.T) {
+	r := SetupTestService(t)
+	srvConfig.Config.Authz.ClientID = "test"
+
+	token, err := authz.JWTAccessToken("test", time.Now().Add(time.Hour).Unix(),
+		authz.CustomClaims{User: "user", Scope: "read write sandbox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(testUserRecord(1, 1709647200, map[string]float64{"samx": 1}))
+	// Asking not to use the sandbox does not override the token's scope
+	req := httptest.NewRequest("POST", "/add?sandbox=false", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("adding a record with a sandbox token returned %d: %s", w.Code, w.Body.String())
+	}
+	if n, _ := ScanDocs.Count(map[string]any{}); n != 0 {
+		t.Errorf("record added with a sandbox token went to production")
+	}
+	if n, _ := Sandbox.Docs.Count(map[string]any{}); n != 1 {
+		t.Errorf("record added with a sandbox token is not in the sandbox")
+	}
+}
@@ -20,28 +20,80 @@ var _httpReadRequest *services.HttpRequest
 var Verbose int
 var QLM ql.QLManager
 
-// helper function to setup our router
-func setupRouter() *gin.Engine {
-	routes := []server.Route{
+// Return the routes of the service
+func serviceRoutes() []server.Route {
+	return []server.Route{
 		{Method: "POST", Path: "/add", Handler: AddHandler, Authorized: true, Scope: "write"},
 		{Method: "PUT", Path: "/edit", Handler: EditHandler, Authorized: true, Scope: "write"},
 		{Method: "POST", Path: "/search", Handler: SearchHandler, Authorized: true},
+		{Method: "GET", Path: "/motors", Handler: MotorsHandler, Authorized: true},
+		{Method: "PUT", Path: "/motors", Handler: EditMotorsHandler, Authorized: true, Scope: "write"},
+		{Method: "GET", Path: "/variables", Handler: VariablesHandler, Authorized: true},
+		{Method: "PUT", Path: "/variables", Handler: EditVariablesHandler, Authorized: true, Scope: "write"},
+		{Method: "GET", Path: "/status", Handler: StatusHandler, Authorized: true},
+		{Method: "POST", Path: "/status", Handler: TransitionStatusHandler, Authorized: true, Scope: "write"},
+		{Method: "POST", Path: "/reconcile", Handler: ReconcileHandler, Authorized: true, Scope: "write"},
+		{Method: "DELETE", Path: "/sandbox", Handler: PurgeSandboxHandler, Authorized: true, Scope: "write"},
+		{Method: "GET", Path: "/embargo", Handler: EmbargoesHandler, Authorized: true},
+		{Method: "PUT", Path: "/embargo", Handler: EmbargoHandler, Authorized: true, Scope: "write"},
+		{Method: "DELETE", Path: "/records", Handler: DeleteHandler, Authorized: true, Scope: "delete"},
+		{Method: "POST", Path: "/restore", Handler: RestoreHandler, Authorized: true, Scope: "delete"},
+		{Method: "DELETE", Path: "/tombstones", Handler: PurgeDeletedHandler, Authorized: true, Scope: "delete"},
+		{Method: "GET", Path: "/audit", Handler: AuditHandler, Authorized: true},
+		{Method: "POST", Path: "/graphql", Handler: GraphQLHandler, Authorized: true},
+		{Method: "GET", Path: "/subscribe", Handler: SubscribeHandler, Authorized: true},
+		{Method: "GET", Path: "/webhooks", Handler: WebhooksHandler, Authorized: true},
+		{Method: "PUT", Path: "/webhooks", Handler: EditWebhookHandler, Authorized: true, Scope: "write"},
+		{Method: "DELETE", Path: "/webhooks", Handler: DeleteWebhookHandler, Authorized: true, Scope: "write"},
+		{Method: "GET", Path: "/webhooks/deliveries", Handler: WebhookDeliveriesHandler, Authorized: true},
+		{Method: "GET", Path: "/openapi.json", Handler: OpenAPIHandler},
 	}
+}
+
+// helper function to setup our router
+func setupRouter() *gin.Engine {
+	routes := auditRoutes(validateRoutes(serviceRoutes()))
 	r := server.Router(routes, nil, "static", srvConfig.Config.SpecScans.WebServer) // FIX temporary config
 	return r
 }
 
+// InitDocumentStores sets up the stores of scan documents, of records
+// quarantined by reconcile, of the audit log and of webhooks and their
+// deliveries (in MongoDB, or in memory e.g. for local development)
+func InitDocumentStores() {
+	if srvConfig.Config.SpecScans.MongoDB.DBUri == "memory" {
+		log.Println("WARNING: scan documents are kept in memory only")
+		ScanDocs = NewMemoryDocumentStore()
+		QuarantineDocs = NewMemoryDocumentStore()
+		AuditDocs = NewMemoryDocumentStore()
+		WebhookDocs = NewMemoryDocumentStore()
+		WebhookDeliveryDocs = NewMemoryDocumentStore()
+		return
+	}
+	mongo.InitMongoDB(srvConfig.Config.SpecScans.MongoDB.DBUri)
+	dbname := srvConfig.Config.SpecScans.MongoDB.DBName
+	dbcoll := srvConfig.Config.SpecScans.MongoDB.DBColl
+	ScanDocs = NewMongoDocumentStore(dbname, dbcoll)
+	QuarantineDocs = NewMongoDocumentStore(dbname, dbcoll+"_quarantine")
+	AuditDocs = NewMongoDocumentStore(dbname, dbcoll+"_audit")
+	WebhookDocs = NewMongoDocumentStore(dbname, dbcoll+"_webhooks")
+	WebhookDeliveryDocs = NewMongoDocumentStore(dbname, dbcoll+"_webhook_deliveries")
+}
+
 // Server defines our HTTP server
 func Server() {
 	Verbose = srvConfig.Config.SpecScans.WebServer.Verbose // FIX temporary config
 	_httpReadRequest = services.NewHttpRequest("read", Verbose)
 
 	// Setup mongodb connection
-	mongo.InitMongoDB(srvConfig.Config.SpecScans.MongoDB.DBUri)
+	InitDocumentStores()
 
 	// Setup motorsdb connection
 	InitMotorsDb()
 
+	// Setup the stores of sandbox records
+	InitSandbox()
+
 	// local SpecScans schema
 	InitSchemaManager()
 
@@ -54,5 +106,12 @@ func Server() {
 	// setup web router and start the service
 	r := setupRouter()
 	webServer := srvConfig.Config.SpecScans.WebServer // FIX temporary config
+	if GrpcPort > 0 {
+		go func() {
+			if err := StartGrpcServer(GrpcPort, webServer); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 	server.StartServer(r, webServer)
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Strategies for generating the scan ids (sids) of new records
+const (
+	SidTime         = "time"          // start time in nanoseconds, as an integer (the original scheme)
+	SidBeamlineTime = "beamline-time" // beamline and start time in microseconds
+	SidUUIDv7       = "uuid7"         // UUIDv7 with the start time as its timestamp
+	SidHash         = "hash"          // hash of spec_file, scan_number and start_time
+)
+
+// Known sid strategies
+var SidStrategies = []string{SidTime, SidBeamlineTime, SidUUIDv7, SidHash}
+
+// var SidStrategy is the strategy used for the sids of new records
+var SidStrategy = SidTime
+
+// Number of documents read at a time when migrating sids
+var SidMigrationBatchSize = 1000
+
+// Check that a sid strategy is known
+func ValidateSidStrategy(strategy string) error {
+	if !inList(strategy, SidStrategies) {
+		return fmt.Errorf("unknown sid strategy %q; must be one of %v", strategy, SidStrategies)
+	}
+	return nil
+}
+
+// Return a sid for a scan record with the given strategy. Only the uuid7
+// strategy gives different sids for the same record.
+func NewScanId(strategy string, record MongoRecord) (string, error) {
+	switch strategy {
+	case SidTime:
+		return strconv.Itoa(int(record.StartTime * 1e9)), nil
+	case SidBeamlineTime:
+		// Microseconds of epoch times are exact in a float64, unlike
+		// nanoseconds
+		if record.Beamline == "" {
+			return "", errors.New("beamline-time sids require a beamline")
+		}
+		return fmt.Sprintf("%s-%d", record.Beamline, int64(math.Round(record.StartTime*1e6))), nil
+	case SidUUIDv7:
+		return uuidv7(int64(math.Round(record.StartTime * 1e3))), nil
+	case SidHash:
+		content := fmt.Sprintf("%s\n%d\n%s", record.SpecFile, record.ScanNumber, strconv.FormatFloat(record.StartTime, 'f', -1, 64))
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:16]), nil
+	}
+	return "", ValidateSidStrategy(strategy)
+}
+
+// Check whether a sid is one the given strategy could have given the record:
+// for the uuid7 strategy, any UUIDv7 with the record's start time as its
+// timestamp, and for the other strategies, the sid they give
+func ScanIdMatches(strategy string, sid string, record MongoRecord) bool {
+	if strategy != SidUUIDv7 {
+		new_sid, err := NewScanId(strategy, record)
+		return err == nil && new_sid == sid
+	}
+	millis, ok := uuidv7Millis(sid)
+	return ok && millis == int64(math.Round(record.StartTime*1e3))
+}
+
+// Return the timestamp in milliseconds of a UUIDv7, and whether sid is one
+func uuidv7Millis(sid string) (int64, bool) {
+	if len(sid) != 36 || sid[8] != '-' || sid[13] != '-' || sid[18] != '-' || sid[23] != '-' {
+		return 0, false
+	}
+	u, err := hex.DecodeString(sid[0:8] + sid[9:13] + sid[14:18] + sid[19:23] + sid[24:36])
+	if err != nil || u[6]&0xf0 != 0x70 || u[8]&0xc0 != 0x80 {
+		return 0, false
+	}
+	var timestamp [8]byte
+	copy(timestamp[2:], u[:6])
+	return int64(binary.BigEndian.Uint64(timestamp[:])), true
+}
+
+// Return a UUIDv7 (RFC 9562) with the given timestamp in milliseconds
+func uuidv7(millis int64) string {
+	var u [16]byte
+	rand.Read(u[6:])
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(millis))
+	copy(u[:6], timestamp[2:])
+	u[6] = 0x70 | u[6]&0x0f // version 7
+	u[8] = 0x80 | u[8]&0x3f // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// SidMigration is the result of rewriting the sids of existing records
+type SidMigration struct {
+	Strategy  string            `json:"strategy"`
+	Documents int               `json:"documents"`
+	Renamed   map[string]string `json:"renamed"`          // new sid of each renamed record
+	Errors    map[string]string `json:"errors,omitempty"` // records left as they were
+}
+
+// Rewrite the sids of all records in the document and motor stores with the
+// given strategy, except those whose sids already follow it. Each record is
+// renamed in the motor store first and then in the document store, and the
+// motor store is reverted if the document store fails, so both stores keep
+// the same sids. Records whose new sid is already
+// in use are left as they were and reported as errors.
+func MigrateScanIds(strategy string, docs DocumentStore, motors MotorStore) (SidMigration, error) {
+	migration := SidMigration{Strategy: strategy, Renamed: map[string]string{}, Errors: map[string]string{}}
+	if err := ValidateSidStrategy(strategy); err != nil {
+		return migration, fmt.Errorf("[SpecScansService.main.MigrateScanIds] %w", err)
+	}
+	in_use := make(map[string]bool)
+	has_motors := make(map[string]bool)
+	err := motors.ForEachScan(func(sid string, beamline string) error {
+		in_use[sid] = true
+		has_motors[sid] = true
+		return nil
+	})
+	if err != nil {
+		return migration, fmt.Errorf("[SpecScansService.main.MigrateScanIds] motors.ForEachScan error: %w", err)
+	}
+
+	// Plan all renames before changing anything, since paging through
+	// documents while renaming them could skip or repeat documents
+	var old_sids []string
+	new_sids := make(map[string]string)
+	for idx := 0; ; idx += SidMigrationBatchSize {
+		records, err := docs.Get(map[string]any{}, idx, SidMigrationBatchSize)
+		if err != nil {
+			return migration, fmt.Errorf("[SpecScansService.main.MigrateScanIds] docs.Get error: %w", err)
+		}
+		for _, record := range records {
+			migration.Documents++
+			var mongo_record MongoRecord
+			if err = Decode(record, &mongo_record); err != nil {
+				return migration, fmt.Errorf("[SpecScansService.main.MigrateScanIds] Decode error: %w", err)
+			}
+			sid := mongo_record.ScanId
+			in_use[sid] = true
+			// Records already following the strategy keep their sids, which
+			// the random part of UUIDv7s would otherwise change on every run
+			if ScanIdMatches(strategy, sid, mongo_record) {
+				continue
+			}
+			new_sid, err := NewScanId(strategy, mongo_record)
+			if err != nil {
+				migration.Errors[sid] = err.Error()
+				continue
+			}
+			if new_sid != sid {
+				old_sids = append(old_sids, sid)
+				new_sids[sid] = new_sid
+			}
+		}
+		if len(records) < SidMigrationBatchSize {
+			break
+		}
+	}
+
+	for _, sid := range old_sids {
+		new_sid := new_sids[sid]
+		if in_use[new_sid] {
+			migration.Errors[sid] = fmt.Sprintf("new sid %s is already in use", new_sid)
+			continue
+		}
+		in_use[new_sid] = true
+		if has_motors[sid] {
+			if err = motors.RenameScan(sid, new_sid); err != nil {
+				migration.Errors[sid] = err.Error()
+				continue
+			}
+		}
+		err = docs.Update(map[string]any{"sid": sid}, map[string]any{"$set": map[string]any{"sid": new_sid}})
+		if err != nil {
+			migration.Errors[sid] = err.Error()
+			if has_motors[sid] {
+				if revert_err := motors.RenameScan(new_sid, sid); revert_err != nil {
+					return migration, fmt.Errorf("[SpecScansService.main.MigrateScanIds] motors of %s were renamed to %s but its document was not, and reverting failed: %w", sid, new_sid, revert_err)
+				}
+			}
+			continue
+		}
+		migration.Renamed[sid] = new_sid
+	}
+	return migration, nil
+}
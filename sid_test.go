@@ -0,0 +1,148 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// Test generating scan ids with each strategy
+func TestNewScanId(t *testing.T) {
+	record := MongoRecord{Beamline: "3a", SpecFile: "/data/a", ScanNumber: 1, StartTime: 1709647200.123456}
+
+	sid, err := NewScanId(SidTime, record)
+	if err != nil || sid != strconv.Itoa(int(record.StartTime*1e9)) {
+		t.Errorf("time sid is %s, %v", sid, err)
+	}
+	sid, err = NewScanId(SidBeamlineTime, record)
+	if err != nil || sid != "3a-1709647200123456" {
+		t.Errorf("beamline-time sid is %s, %v; want 3a-1709647200123456", sid, err)
+	}
+	if _, err = NewScanId(SidBeamlineTime, MongoRecord{StartTime: record.StartTime}); err == nil {
+		t.Error("beamline-time sid of a record without a beamline should fail")
+	}
+
+	sid, err = NewScanId(SidUUIDv7, record)
+	uuid_pattern := regexp.MustCompile(`^018e0e[0-9a-f]{2}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if err != nil || !uuid_pattern.MatchString(sid) {
+		t.Errorf("uuid7 sid is %s, %v", sid, err)
+	}
+	if other, _ := NewScanId(SidUUIDv7, record); other == sid {
+		t.Error("uuid7 sids of the same record should differ")
+	}
+	if !ScanIdMatches(SidUUIDv7, sid, record) {
+		t.Errorf("uuid7 sid %s does not match its record", sid)
+	}
+	later := record
+	later.StartTime += 1
+	if ScanIdMatches(SidUUIDv7, sid, later) || ScanIdMatches(SidUUIDv7, strconv.Itoa(int(record.StartTime*1e9)), record) {
+		t.Errorf("uuid7 sid %s matches a record of another start time, or a time sid matches", sid)
+	}
+
+	sid, err = NewScanId(SidHash, record)
+	if err != nil || len(sid) != 32 {
+		t.Errorf("hash sid is %s, %v", sid, err)
+	}
+	if same, _ := NewScanId(SidHash, record); same != sid {
+		t.Error("hash sids of the same record should be equal")
+	}
+	record.ScanNumber = 2
+	if other, _ := NewScanId(SidHash, record); other == sid {
+		t.Error("hash sids of different scans should differ")
+	}
+
+	if _, err = NewScanId("random", record); err == nil {
+		t.Error("unknown sid strategy should fail")
+	}
+}
+
+// Test rewriting the sids of existing records in both stores
+func TestMigrateScanIds(t *testing.T) {
+	SidMigrationBatchSize = 2
+	defer func() { SidMigrationBatchSize = 1000 }()
+
+	docs := NewMemoryDocumentStore()
+	motors := NewMemoryMotorStore()
+	records := []MongoRecord{
+		{Beamline: "3a", SpecFile: "/data/a", ScanNumber: 1, StartTime: 1709647200.5},
+		{Beamline: "3a", SpecFile: "/data/a", ScanNumber: 2, StartTime: 1709647300.5},
+		{Beamline: "1b", SpecFile: "/data/b", ScanNumber: 1, StartTime: 1709647400.5},
+	}
+	for i, record := range records {
+		record.ScanId, _ = NewScanId(SidTime, record)
+		docs.Insert(map[string]any{"sid": record.ScanId, "beamline": record.Beamline, "spec_file": record.SpecFile,
+			"scan_number": record.ScanNumber, "start_time": record.StartTime})
+		if i < 2 {
+			// the last record has no motors
+			motors.InsertMotors(MotorRecord{ScanId: record.ScanId, Beamline: record.Beamline, Motors: map[string]float64{"samx": float64(i)}})
+		}
+	}
+	// A record already using the new sid of the second record
+	taken, _ := NewScanId(SidBeamlineTime, records[1])
+	docs.Insert(map[string]any{"sid": taken, "spec_file": "/data/c", "scan_number": 1, "start_time": 1709647500.5})
+
+	migration, err := MigrateScanIds(SidBeamlineTime, docs, motors)
+	if err != nil {
+		t.Fatalf("MigrateScanIds error: %v", err)
+	}
+	old_sid, _ := NewScanId(SidTime, records[1])
+	if migration.Documents != 4 || len(migration.Renamed) != 2 || len(migration.Errors) != 2 || migration.Errors[old_sid] == "" {
+		t.Errorf("MigrateScanIds returned %+v", migration)
+	}
+	for i, record := range records {
+		sid, _ := NewScanId(SidBeamlineTime, record)
+		if i == 1 {
+			sid = old_sid
+		}
+		if n, _ := docs.Count(map[string]any{"sid": sid}); n != 1 {
+			t.Errorf("document of record %d does not have sid %s", i, sid)
+		}
+		found, _ := motors.QueryMotors(MotorsDbQuery{Sids: []string{sid}})
+		if i < 2 && (len(found) != 1 || found[0].Motors["samx"] != float64(i)) {
+			t.Errorf("motors of record %d do not have sid %s: %+v", i, sid, found)
+		}
+	}
+	if report, _ := Reconcile(ReconcileReport, docs, motors, nil); len(report.Inconsistencies[OrphanMotors]) != 0 {
+		t.Errorf("migrating sids orphaned motors: %+v", report.Inconsistencies[OrphanMotors])
+	}
+
+	// Migrating again changes nothing
+	migration, err = MigrateScanIds(SidBeamlineTime, docs, motors)
+	if err != nil || len(migration.Renamed) != 0 {
+		t.Errorf("second MigrateScanIds returned %+v, %v", migration, err)
+	}
+
+	// nor does migrating to random UUIDv7s again
+	migration, err = MigrateScanIds(SidUUIDv7, docs, motors)
+	if err != nil || len(migration.Renamed) != 4 {
+		t.Errorf("MigrateScanIds to uuid7 returned %+v, %v", migration, err)
+	}
+	migration, err = MigrateScanIds(SidUUIDv7, docs, motors)
+	if err != nil || len(migration.Renamed) != 0 {
+		t.Errorf("second MigrateScanIds to uuid7 returned %+v, %v", migration, err)
+	}
+}
+
+// Test renaming scans in the SQL motors database
+func TestRenameScan(t *testing.T) {
+	db := SetupTestDB(t)
+	defer db.Close()
+	store := NewSQLMotorStore(db, SQLiteDialect)
+	if _, err := store.InsertMotors(MotorRecord{ScanId: "sid_1", Motors: map[string]float64{"samx": 1}}); err != nil {
+		t.Fatalf("InsertMotors error: %v", err)
+	}
+	if err := store.RenameScan("sid_1", "3a-1"); err != nil {
+		t.Fatalf("RenameScan error: %v", err)
+	}
+	if err := store.RenameScan("sid_1", "3a-2"); err == nil {
+		t.Error("RenameScan of an unknown scan should fail")
+	}
+	var sids []string
+	store.ForEachScan(func(sid string, beamline string) error {
+		sids = append(sids, sid)
+		return nil
+	})
+	if len(sids) != 1 || sids[0] != "3a-1" {
+		t.Errorf("scans are %v after renaming", sids)
+	}
+}
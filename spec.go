@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Number of motors listed on each #O, #o and #P line, as written by SPEC
+const SpecMotorsPerLine = 8
+
+// Write the SPEC file header text of a single scan: the #S line with scan
+// number and command, the #D date of the scan start, its #C comments and #U
+// userlines, and #O/#o/#P blocks of motor names, mnemonics and positions.
+func WriteSpecScanHeader(w io.Writer, record UserRecord) error {
+	mnes := specMotorMnes(record.Motors)
+	lines := append(specScanLines(record), specMotorNameLines(mnes)...)
+	lines = append(lines, specPositionLines(mnes, record.Motors)...)
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.WriteSpecScanHeader] io.WriteString error: %w", err)
+	}
+	return nil
+}
+
+// Write the header text of whole SPEC files: records are grouped by spec_file,
+// and the scans of each file follow its #F/#E/#D file header in order of scan
+// number and start time. Like SPEC, the file header lists the motors (#O/#o)
+// whose positions the #P lines of the scans give, and a new #E/#D/#O header
+// starts when the motors of a scan differ from those of the previous one.
+func WriteSpecFiles(w io.Writer, records []UserRecord) error {
+	files := make(map[string][]UserRecord)
+	var spec_files []string
+	for _, record := range records {
+		if _, ok := files[record.SpecFile]; !ok {
+			spec_files = append(spec_files, record.SpecFile)
+		}
+		files[record.SpecFile] = append(files[record.SpecFile], record)
+	}
+	sort.Strings(spec_files)
+	var lines []string
+	for i, spec_file := range spec_files {
+		scans := files[spec_file]
+		sort.SliceStable(scans, func(i, j int) bool {
+			if scans[i].ScanNumber != scans[j].ScanNumber {
+				return scans[i].ScanNumber < scans[j].ScanNumber
+			}
+			return scans[i].StartTime < scans[j].StartTime
+		})
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, fmt.Sprintf("#F %s", spec_file))
+		var header_mnes []string
+		for j, scan := range scans {
+			mnes := specMotorMnes(scan.Motors)
+			if j == 0 || !slices.Equal(mnes, header_mnes) {
+				if j > 0 {
+					lines = append(lines, "")
+				}
+				lines = append(lines, fmt.Sprintf("#E %d", int64(scan.StartTime)), fmt.Sprintf("#D %s", specDate(scan.StartTime)))
+				lines = append(lines, specMotorNameLines(mnes)...)
+				header_mnes = mnes
+			}
+			lines = append(lines, "")
+			lines = append(lines, specScanLines(scan)...)
+			lines = append(lines, specPositionLines(mnes, scan.Motors)...)
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.WriteSpecFiles] io.WriteString error: %w", err)
+	}
+	return nil
+}
+
+// Return the #S, #D, #C and #U lines of a scan
+func specScanLines(record UserRecord) []string {
+	lines := []string{
+		fmt.Sprintf("#S %d  %s", record.ScanNumber, record.Command),
+		fmt.Sprintf("#D %s", specDate(record.StartTime)),
+	}
+	for _, comment := range record.Comments {
+		lines = append(lines, fmt.Sprintf("#C %s", comment))
+	}
+	for _, userline := range record.Userlines {
+		lines = append(lines, fmt.Sprintf("#U %s", userline))
+	}
+	return lines
+}
+
+// Return the mnemonics of a map of motor positions in sorted order, since the
+// map does not record the original order
+func specMotorMnes(motors map[string]float64) []string {
+	var mnes []string
+	for mne := range motors {
+		mnes = append(mnes, mne)
+	}
+	sort.Strings(mnes)
+	return mnes
+}
+
+// Return the #O and #o lines listing the given motors. Records do not keep
+// motor names, so the mnemonics stand in for them on the #O lines, which SPEC
+// separates by two spaces as names may contain single spaces; #o lines list
+// the mnemonics separated by single spaces.
+func specMotorNameLines(mnes []string) []string {
+	var name_lines, mne_lines []string
+	for i := 0; i < len(mnes); i += SpecMotorsPerLine {
+		end := min(i+SpecMotorsPerLine, len(mnes))
+		n := i / SpecMotorsPerLine
+		name_lines = append(name_lines, fmt.Sprintf("#O%d %s", n, strings.Join(mnes[i:end], "  ")))
+		mne_lines = append(mne_lines, fmt.Sprintf("#o%d %s", n, strings.Join(mnes[i:end], " ")))
+	}
+	return append(name_lines, mne_lines...)
+}
+
+// Return the #P lines of the positions of the given motors
+func specPositionLines(mnes []string, motors map[string]float64) []string {
+	var lines []string
+	for i := 0; i < len(mnes); i += SpecMotorsPerLine {
+		end := min(i+SpecMotorsPerLine, len(mnes))
+		var positions []string
+		for _, mne := range mnes[i:end] {
+			positions = append(positions, fmt.Sprintf("%g", motors[mne]))
+		}
+		lines = append(lines, fmt.Sprintf("#P%d %s", i/SpecMotorsPerLine, strings.Join(positions, " ")))
+	}
+	return lines
+}
+
+// Format an epoch time like the #D lines of SPEC files
+func specDate(epoch float64) string {
+	return fromEpochTime(epoch).Format(time.ANSIC)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test reconstruction of SPEC file headers from scan records
+func TestWriteSpecFiles(t *testing.T) {
+	motors := map[string]float64{}
+	for _, mne := range []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "m8"} {
+		motors[mne] = 1.5
+	}
+	records := []UserRecord{
+		{
+			SpecFile:   "/data/sample",
+			ScanNumber: 2,
+			StartTime:  1709647260,
+			Command:    "ascan  samx 0 1 10 1",
+			Motors:     map[string]float64{"samx": 0.25},
+		},
+		{
+			SpecFile:   "/data/sample",
+			ScanNumber: 1,
+			StartTime:  1709647200,
+			Command:    "timescan 1",
+			Comments:   []string{"first scan"},
+			Userlines:  []string{"user line"},
+			Motors:     motors,
+		},
+		{
+			SpecFile:   "/data/sample",
+			ScanNumber: 3,
+			StartTime:  1709647320,
+			Command:    "ascan  samx 1 2 10 1",
+			Motors:     map[string]float64{"samx": 1},
+		},
+	}
+	date0 := time.Unix(1709647200, 0).Format(time.ANSIC)
+	date1 := time.Unix(1709647260, 0).Format(time.ANSIC)
+	date2 := time.Unix(1709647320, 0).Format(time.ANSIC)
+	// The file header lists the motors of the scans after it, and a new one
+	// starts when the motors change
+	expected := strings.Join([]string{
+		"#F /data/sample",
+		"#E 1709647200",
+		"#D " + date0,
+		"#O0 m0  m1  m2  m3  m4  m5  m6  m7",
+		"#O1 m8",
+		"#o0 m0 m1 m2 m3 m4 m5 m6 m7",
+		"#o1 m8",
+		"",
+		"#S 1  timescan 1",
+		"#D " + date0,
+		"#C first scan",
+		"#U user line",
+		"#P0 1.5 1.5 1.5 1.5 1.5 1.5 1.5 1.5",
+		"#P1 1.5",
+		"",
+		"#E 1709647260",
+		"#D " + date1,
+		"#O0 samx",
+		"#o0 samx",
+		"",
+		"#S 2  ascan  samx 0 1 10 1",
+		"#D " + date1,
+		"#P0 0.25",
+		"",
+		"#S 3  ascan  samx 1 2 10 1",
+		"#D " + date2,
+		"#P0 1",
+		"",
+	}, "\n")
+	var buf bytes.Buffer
+	err := WriteSpecFiles(&buf, records)
+	if err != nil {
+		t.Fatalf("WriteSpecFiles error: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("WriteSpecFiles wrote\n%s\nwant\n%s", buf.String(), expected)
+	}
+}
+
+// Test that the header of a single scan labels its motor positions
+func TestWriteSpecScanHeader(t *testing.T) {
+	record := UserRecord{ScanNumber: 2, StartTime: 1709647260, Command: "ascan  samx 0 1 10 1", Motors: map[string]float64{"samx": 0.25, "samz": 2}}
+	expected := strings.Join([]string{
+		"#S 2  ascan  samx 0 1 10 1",
+		"#D " + time.Unix(1709647260, 0).Format(time.ANSIC),
+		"#O0 samx  samz",
+		"#o0 samx samz",
+		"#P0 0.25 2",
+		"",
+	}, "\n")
+	var buf bytes.Buffer
+	if err := WriteSpecScanHeader(&buf, record); err != nil {
+		t.Fatalf("WriteSpecScanHeader error: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("WriteSpecScanHeader wrote\n%s\nwant\n%s", buf.String(), expected)
+	}
+}
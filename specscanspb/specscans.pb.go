@@ -0,0 +1,786 @@
+// gRPC interface of the SpecScans service, next to its HTTP API. Calls are
+// authorized by a FOXDEN token in the "authorization" metadata ("Bearer
+// <token>"), and use the sandbox when the "sandbox" metadata is "true".
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: specscans.proto
+
+package specscanspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Who made a change to a scan record
+type Identity struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	Client        string                 `protobuf:"bytes,2,opt,name=client,proto3" json:"client,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Identity) Reset() {
+	*x = Identity{}
+	mi := &file_specscans_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Identity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Identity) ProtoMessage() {}
+
+func (x *Identity) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Identity.ProtoReflect.Descriptor instead.
+func (*Identity) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Identity) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *Identity) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+// A change of the status of a scan
+type StatusChange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Time          float64                `protobuf:"fixed64,2,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusChange) Reset() {
+	*x = StatusChange{}
+	mi := &file_specscans_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusChange) ProtoMessage() {}
+
+func (x *StatusChange) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusChange.ProtoReflect.Descriptor instead.
+func (*StatusChange) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StatusChange) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *StatusChange) GetTime() float64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+// A scan record; times are epoch times in seconds
+type Scan struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Sid         string                 `protobuf:"bytes,1,opt,name=sid,proto3" json:"sid,omitempty"`
+	Did         string                 `protobuf:"bytes,2,opt,name=did,proto3" json:"did,omitempty"`
+	Cycle       string                 `protobuf:"bytes,3,opt,name=cycle,proto3" json:"cycle,omitempty"`
+	Beamline    string                 `protobuf:"bytes,4,opt,name=beamline,proto3" json:"beamline,omitempty"`
+	Btr         string                 `protobuf:"bytes,5,opt,name=btr,proto3" json:"btr,omitempty"`
+	SpecFile    string                 `protobuf:"bytes,6,opt,name=spec_file,json=specFile,proto3" json:"spec_file,omitempty"`
+	ScanNumber  uint32                 `protobuf:"varint,7,opt,name=scan_number,json=scanNumber,proto3" json:"scan_number,omitempty"`
+	StartTime   float64                `protobuf:"fixed64,8,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	Command     string                 `protobuf:"bytes,9,opt,name=command,proto3" json:"command,omitempty"`
+	Status      string                 `protobuf:"bytes,10,opt,name=status,proto3" json:"status,omitempty"`
+	EndTime     float64                `protobuf:"fixed64,11,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	Duration    float64                `protobuf:"fixed64,12,opt,name=duration,proto3" json:"duration,omitempty"`
+	Comments    []string               `protobuf:"bytes,13,rep,name=comments,proto3" json:"comments,omitempty"`
+	Userlines   []string               `protobuf:"bytes,14,rep,name=userlines,proto3" json:"userlines,omitempty"`
+	SpecVersion string                 `protobuf:"bytes,15,opt,name=spec_version,json=specVersion,proto3" json:"spec_version,omitempty"`
+	Motors      map[string]float64     `protobuf:"bytes,16,rep,name=motors,proto3" json:"motors,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	Variables   *structpb.Struct       `protobuf:"bytes,17,opt,name=variables,proto3" json:"variables,omitempty"`
+	// Fields maintained by the service
+	StatusHistory []*StatusChange `protobuf:"bytes,18,rep,name=status_history,json=statusHistory,proto3" json:"status_history,omitempty"`
+	CreatedBy     *Identity       `protobuf:"bytes,19,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	CreatedAt     float64         `protobuf:"fixed64,20,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedBy     *Identity       `protobuf:"bytes,21,opt,name=updated_by,json=updatedBy,proto3" json:"updated_by,omitempty"`
+	UpdatedAt     float64         `protobuf:"fixed64,22,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	EmbargoUntil  float64         `protobuf:"fixed64,23,opt,name=embargo_until,json=embargoUntil,proto3" json:"embargo_until,omitempty"`
+	DeletedAt     float64         `protobuf:"fixed64,24,opt,name=deleted_at,json=deletedAt,proto3" json:"deleted_at,omitempty"`
+	DeletedBy     *Identity       `protobuf:"bytes,25,opt,name=deleted_by,json=deletedBy,proto3" json:"deleted_by,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Scan) Reset() {
+	*x = Scan{}
+	mi := &file_specscans_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Scan) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Scan) ProtoMessage() {}
+
+func (x *Scan) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Scan.ProtoReflect.Descriptor instead.
+func (*Scan) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Scan) GetSid() string {
+	if x != nil {
+		return x.Sid
+	}
+	return ""
+}
+
+func (x *Scan) GetDid() string {
+	if x != nil {
+		return x.Did
+	}
+	return ""
+}
+
+func (x *Scan) GetCycle() string {
+	if x != nil {
+		return x.Cycle
+	}
+	return ""
+}
+
+func (x *Scan) GetBeamline() string {
+	if x != nil {
+		return x.Beamline
+	}
+	return ""
+}
+
+func (x *Scan) GetBtr() string {
+	if x != nil {
+		return x.Btr
+	}
+	return ""
+}
+
+func (x *Scan) GetSpecFile() string {
+	if x != nil {
+		return x.SpecFile
+	}
+	return ""
+}
+
+func (x *Scan) GetScanNumber() uint32 {
+	if x != nil {
+		return x.ScanNumber
+	}
+	return 0
+}
+
+func (x *Scan) GetStartTime() float64 {
+	if x != nil {
+		return x.StartTime
+	}
+	return 0
+}
+
+func (x *Scan) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *Scan) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Scan) GetEndTime() float64 {
+	if x != nil {
+		return x.EndTime
+	}
+	return 0
+}
+
+func (x *Scan) GetDuration() float64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *Scan) GetComments() []string {
+	if x != nil {
+		return x.Comments
+	}
+	return nil
+}
+
+func (x *Scan) GetUserlines() []string {
+	if x != nil {
+		return x.Userlines
+	}
+	return nil
+}
+
+func (x *Scan) GetSpecVersion() string {
+	if x != nil {
+		return x.SpecVersion
+	}
+	return ""
+}
+
+func (x *Scan) GetMotors() map[string]float64 {
+	if x != nil {
+		return x.Motors
+	}
+	return nil
+}
+
+func (x *Scan) GetVariables() *structpb.Struct {
+	if x != nil {
+		return x.Variables
+	}
+	return nil
+}
+
+func (x *Scan) GetStatusHistory() []*StatusChange {
+	if x != nil {
+		return x.StatusHistory
+	}
+	return nil
+}
+
+func (x *Scan) GetCreatedBy() *Identity {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return nil
+}
+
+func (x *Scan) GetCreatedAt() float64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+func (x *Scan) GetUpdatedBy() *Identity {
+	if x != nil {
+		return x.UpdatedBy
+	}
+	return nil
+}
+
+func (x *Scan) GetUpdatedAt() float64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+func (x *Scan) GetEmbargoUntil() float64 {
+	if x != nil {
+		return x.EmbargoUntil
+	}
+	return 0
+}
+
+func (x *Scan) GetDeletedAt() float64 {
+	if x != nil {
+		return x.DeletedAt
+	}
+	return 0
+}
+
+func (x *Scan) GetDeletedBy() *Identity {
+	if x != nil {
+		return x.DeletedBy
+	}
+	return nil
+}
+
+// The outcome of adding a scan record, the index-th of the stream
+type AddResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Sid           string                 `protobuf:"bytes,2,opt,name=sid,proto3" json:"sid,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddResult) Reset() {
+	*x = AddResult{}
+	mi := &file_specscans_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddResult) ProtoMessage() {}
+
+func (x *AddResult) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddResult.ProtoReflect.Descriptor instead.
+func (*AddResult) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AddResult) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *AddResult) GetSid() string {
+	if x != nil {
+		return x.Sid
+	}
+	return ""
+}
+
+func (x *AddResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type AddScansResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Added         int32                  `protobuf:"varint,1,opt,name=added,proto3" json:"added,omitempty"`
+	Failed        int32                  `protobuf:"varint,2,opt,name=failed,proto3" json:"failed,omitempty"`
+	Results       []*AddResult           `protobuf:"bytes,3,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddScansResponse) Reset() {
+	*x = AddScansResponse{}
+	mi := &file_specscans_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddScansResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddScansResponse) ProtoMessage() {}
+
+func (x *AddScansResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddScansResponse.ProtoReflect.Descriptor instead.
+func (*AddScansResponse) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AddScansResponse) GetAdded() int32 {
+	if x != nil {
+		return x.Added
+	}
+	return 0
+}
+
+func (x *AddScansResponse) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *AddScansResponse) GetResults() []*AddResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// An edit of a scan record, identified by its sid or by its spec_file and
+// scan_number
+type EditScanRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Sid        string                 `protobuf:"bytes,1,opt,name=sid,proto3" json:"sid,omitempty"`
+	SpecFile   string                 `protobuf:"bytes,2,opt,name=spec_file,json=specFile,proto3" json:"spec_file,omitempty"`
+	ScanNumber uint32                 `protobuf:"varint,3,opt,name=scan_number,json=scanNumber,proto3" json:"scan_number,omitempty"`
+	// Fields of the record to change, as in the edits of the HTTP API
+	Fields        *structpb.Struct `protobuf:"bytes,4,opt,name=fields,proto3" json:"fields,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EditScanRequest) Reset() {
+	*x = EditScanRequest{}
+	mi := &file_specscans_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EditScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EditScanRequest) ProtoMessage() {}
+
+func (x *EditScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EditScanRequest.ProtoReflect.Descriptor instead.
+func (*EditScanRequest) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *EditScanRequest) GetSid() string {
+	if x != nil {
+		return x.Sid
+	}
+	return ""
+}
+
+func (x *EditScanRequest) GetSpecFile() string {
+	if x != nil {
+		return x.SpecFile
+	}
+	return ""
+}
+
+func (x *EditScanRequest) GetScanNumber() uint32 {
+	if x != nil {
+		return x.ScanNumber
+	}
+	return 0
+}
+
+func (x *EditScanRequest) GetFields() *structpb.Struct {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+// A search of scan records, with a query as in the HTTP API (a query string or
+// a spec)
+type SearchScansRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Query          string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Spec           *structpb.Struct       `protobuf:"bytes,2,opt,name=spec,proto3" json:"spec,omitempty"`
+	Idx            int32                  `protobuf:"varint,3,opt,name=idx,proto3" json:"idx,omitempty"`
+	Limit          int32                  `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	IncludeDeleted bool                   `protobuf:"varint,5,opt,name=include_deleted,json=includeDeleted,proto3" json:"include_deleted,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *SearchScansRequest) Reset() {
+	*x = SearchScansRequest{}
+	mi := &file_specscans_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchScansRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchScansRequest) ProtoMessage() {}
+
+func (x *SearchScansRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_specscans_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchScansRequest.ProtoReflect.Descriptor instead.
+func (*SearchScansRequest) Descriptor() ([]byte, []int) {
+	return file_specscans_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SearchScansRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchScansRequest) GetSpec() *structpb.Struct {
+	if x != nil {
+		return x.Spec
+	}
+	return nil
+}
+
+func (x *SearchScansRequest) GetIdx() int32 {
+	if x != nil {
+		return x.Idx
+	}
+	return 0
+}
+
+func (x *SearchScansRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SearchScansRequest) GetIncludeDeleted() bool {
+	if x != nil {
+		return x.IncludeDeleted
+	}
+	return false
+}
+
+var File_specscans_proto protoreflect.FileDescriptor
+
+const file_specscans_proto_rawDesc = "" +
+	"\n" +
+	"\x0fspecscans.proto\x12\fspecscans.v1\x1a\x1cgoogle/protobuf/struct.proto\"6\n" +
+	"\bIdentity\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\x12\x16\n" +
+	"\x06client\x18\x02 \x01(\tR\x06client\":\n" +
+	"\fStatusChange\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x12\n" +
+	"\x04time\x18\x02 \x01(\x01R\x04time\"\xa5\a\n" +
+	"\x04Scan\x12\x10\n" +
+	"\x03sid\x18\x01 \x01(\tR\x03sid\x12\x10\n" +
+	"\x03did\x18\x02 \x01(\tR\x03did\x12\x14\n" +
+	"\x05cycle\x18\x03 \x01(\tR\x05cycle\x12\x1a\n" +
+	"\bbeamline\x18\x04 \x01(\tR\bbeamline\x12\x10\n" +
+	"\x03btr\x18\x05 \x01(\tR\x03btr\x12\x1b\n" +
+	"\tspec_file\x18\x06 \x01(\tR\bspecFile\x12\x1f\n" +
+	"\vscan_number\x18\a \x01(\rR\n" +
+	"scanNumber\x12\x1d\n" +
+	"\n" +
+	"start_time\x18\b \x01(\x01R\tstartTime\x12\x18\n" +
+	"\acommand\x18\t \x01(\tR\acommand\x12\x16\n" +
+	"\x06status\x18\n" +
+	" \x01(\tR\x06status\x12\x19\n" +
+	"\bend_time\x18\v \x01(\x01R\aendTime\x12\x1a\n" +
+	"\bduration\x18\f \x01(\x01R\bduration\x12\x1a\n" +
+	"\bcomments\x18\r \x03(\tR\bcomments\x12\x1c\n" +
+	"\tuserlines\x18\x0e \x03(\tR\tuserlines\x12!\n" +
+	"\fspec_version\x18\x0f \x01(\tR\vspecVersion\x126\n" +
+	"\x06motors\x18\x10 \x03(\v2\x1e.specscans.v1.Scan.MotorsEntryR\x06motors\x125\n" +
+	"\tvariables\x18\x11 \x01(\v2\x17.google.protobuf.StructR\tvariables\x12A\n" +
+	"\x0estatus_history\x18\x12 \x03(\v2\x1a.specscans.v1.StatusChangeR\rstatusHistory\x125\n" +
+	"\n" +
+	"created_by\x18\x13 \x01(\v2\x16.specscans.v1.IdentityR\tcreatedBy\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x14 \x01(\x01R\tcreatedAt\x125\n" +
+	"\n" +
+	"updated_by\x18\x15 \x01(\v2\x16.specscans.v1.IdentityR\tupdatedBy\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\x16 \x01(\x01R\tupdatedAt\x12#\n" +
+	"\rembargo_until\x18\x17 \x01(\x01R\fembargoUntil\x12\x1d\n" +
+	"\n" +
+	"deleted_at\x18\x18 \x01(\x01R\tdeletedAt\x125\n" +
+	"\n" +
+	"deleted_by\x18\x19 \x01(\v2\x16.specscans.v1.IdentityR\tdeletedBy\x1a9\n" +
+	"\vMotorsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"I\n" +
+	"\tAddResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x10\n" +
+	"\x03sid\x18\x02 \x01(\tR\x03sid\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"s\n" +
+	"\x10AddScansResponse\x12\x14\n" +
+	"\x05added\x18\x01 \x01(\x05R\x05added\x12\x16\n" +
+	"\x06failed\x18\x02 \x01(\x05R\x06failed\x121\n" +
+	"\aresults\x18\x03 \x03(\v2\x17.specscans.v1.AddResultR\aresults\"\x92\x01\n" +
+	"\x0fEditScanRequest\x12\x10\n" +
+	"\x03sid\x18\x01 \x01(\tR\x03sid\x12\x1b\n" +
+	"\tspec_file\x18\x02 \x01(\tR\bspecFile\x12\x1f\n" +
+	"\vscan_number\x18\x03 \x01(\rR\n" +
+	"scanNumber\x12/\n" +
+	"\x06fields\x18\x04 \x01(\v2\x17.google.protobuf.StructR\x06fields\"\xa8\x01\n" +
+	"\x12SearchScansRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12+\n" +
+	"\x04spec\x18\x02 \x01(\v2\x17.google.protobuf.StructR\x04spec\x12\x10\n" +
+	"\x03idx\x18\x03 \x01(\x05R\x03idx\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\x12'\n" +
+	"\x0finclude_deleted\x18\x05 \x01(\bR\x0eincludeDeleted2\xd3\x01\n" +
+	"\tSpecScans\x12@\n" +
+	"\bAddScans\x12\x12.specscans.v1.Scan\x1a\x1e.specscans.v1.AddScansResponse(\x01\x12=\n" +
+	"\bEditScan\x12\x1d.specscans.v1.EditScanRequest\x1a\x12.specscans.v1.Scan\x12E\n" +
+	"\vSearchScans\x12 .specscans.v1.SearchScansRequest\x1a\x12.specscans.v1.Scan0\x01B8Z6github.com/CHESSComputing/SpecScansService/specscanspbb\x06proto3"
+
+var (
+	file_specscans_proto_rawDescOnce sync.Once
+	file_specscans_proto_rawDescData []byte
+)
+
+func file_specscans_proto_rawDescGZIP() []byte {
+	file_specscans_proto_rawDescOnce.Do(func() {
+		file_specscans_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_specscans_proto_rawDesc), len(file_specscans_proto_rawDesc)))
+	})
+	return file_specscans_proto_rawDescData
+}
+
+var file_specscans_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_specscans_proto_goTypes = []any{
+	(*Identity)(nil),           // 0: specscans.v1.Identity
+	(*StatusChange)(nil),       // 1: specscans.v1.StatusChange
+	(*Scan)(nil),               // 2: specscans.v1.Scan
+	(*AddResult)(nil),          // 3: specscans.v1.AddResult
+	(*AddScansResponse)(nil),   // 4: specscans.v1.AddScansResponse
+	(*EditScanRequest)(nil),    // 5: specscans.v1.EditScanRequest
+	(*SearchScansRequest)(nil), // 6: specscans.v1.SearchScansRequest
+	nil,                        // 7: specscans.v1.Scan.MotorsEntry
+	(*structpb.Struct)(nil),    // 8: google.protobuf.Struct
+}
+var file_specscans_proto_depIdxs = []int32{
+	7,  // 0: specscans.v1.Scan.motors:type_name -> specscans.v1.Scan.MotorsEntry
+	8,  // 1: specscans.v1.Scan.variables:type_name -> google.protobuf.Struct
+	1,  // 2: specscans.v1.Scan.status_history:type_name -> specscans.v1.StatusChange
+	0,  // 3: specscans.v1.Scan.created_by:type_name -> specscans.v1.Identity
+	0,  // 4: specscans.v1.Scan.updated_by:type_name -> specscans.v1.Identity
+	0,  // 5: specscans.v1.Scan.deleted_by:type_name -> specscans.v1.Identity
+	3,  // 6: specscans.v1.AddScansResponse.results:type_name -> specscans.v1.AddResult
+	8,  // 7: specscans.v1.EditScanRequest.fields:type_name -> google.protobuf.Struct
+	8,  // 8: specscans.v1.SearchScansRequest.spec:type_name -> google.protobuf.Struct
+	2,  // 9: specscans.v1.SpecScans.AddScans:input_type -> specscans.v1.Scan
+	5,  // 10: specscans.v1.SpecScans.EditScan:input_type -> specscans.v1.EditScanRequest
+	6,  // 11: specscans.v1.SpecScans.SearchScans:input_type -> specscans.v1.SearchScansRequest
+	4,  // 12: specscans.v1.SpecScans.AddScans:output_type -> specscans.v1.AddScansResponse
+	2,  // 13: specscans.v1.SpecScans.EditScan:output_type -> specscans.v1.Scan
+	2,  // 14: specscans.v1.SpecScans.SearchScans:output_type -> specscans.v1.Scan
+	12, // [12:15] is the sub-list for method output_type
+	9,  // [9:12] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_specscans_proto_init() }
+func file_specscans_proto_init() {
+	if File_specscans_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_specscans_proto_rawDesc), len(file_specscans_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_specscans_proto_goTypes,
+		DependencyIndexes: file_specscans_proto_depIdxs,
+		MessageInfos:      file_specscans_proto_msgTypes,
+	}.Build()
+	File_specscans_proto = out.File
+	file_specscans_proto_goTypes = nil
+	file_specscans_proto_depIdxs = nil
+}
@@ -0,0 +1,206 @@
+// gRPC interface of the SpecScans service, next to its HTTP API. Calls are
+// authorized by a FOXDEN token in the "authorization" metadata ("Bearer
+// <token>"), and use the sandbox when the "sandbox" metadata is "true".
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: specscans.proto
+
+package specscanspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SpecScans_AddScans_FullMethodName    = "/specscans.v1.SpecScans/AddScans"
+	SpecScans_EditScan_FullMethodName    = "/specscans.v1.SpecScans/EditScan"
+	SpecScans_SearchScans_FullMethodName = "/specscans.v1.SpecScans/SearchScans"
+)
+
+// SpecScansClient is the client API for SpecScans service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SpecScansClient interface {
+	// Add scan records, streamed by the client; each record is added or
+	// rejected on its own
+	AddScans(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Scan, AddScansResponse], error)
+	// Edit the fields of a scan record
+	EditScan(ctx context.Context, in *EditScanRequest, opts ...grpc.CallOption) (*Scan, error)
+	// Search scan records, streamed to the client
+	SearchScans(ctx context.Context, in *SearchScansRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Scan], error)
+}
+
+type specScansClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSpecScansClient(cc grpc.ClientConnInterface) SpecScansClient {
+	return &specScansClient{cc}
+}
+
+func (c *specScansClient) AddScans(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Scan, AddScansResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SpecScans_ServiceDesc.Streams[0], SpecScans_AddScans_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Scan, AddScansResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SpecScans_AddScansClient = grpc.ClientStreamingClient[Scan, AddScansResponse]
+
+func (c *specScansClient) EditScan(ctx context.Context, in *EditScanRequest, opts ...grpc.CallOption) (*Scan, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Scan)
+	err := c.cc.Invoke(ctx, SpecScans_EditScan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *specScansClient) SearchScans(ctx context.Context, in *SearchScansRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Scan], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SpecScans_ServiceDesc.Streams[1], SpecScans_SearchScans_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SearchScansRequest, Scan]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SpecScans_SearchScansClient = grpc.ServerStreamingClient[Scan]
+
+// SpecScansServer is the server API for SpecScans service.
+// All implementations must embed UnimplementedSpecScansServer
+// for forward compatibility.
+type SpecScansServer interface {
+	// Add scan records, streamed by the client; each record is added or
+	// rejected on its own
+	AddScans(grpc.ClientStreamingServer[Scan, AddScansResponse]) error
+	// Edit the fields of a scan record
+	EditScan(context.Context, *EditScanRequest) (*Scan, error)
+	// Search scan records, streamed to the client
+	SearchScans(*SearchScansRequest, grpc.ServerStreamingServer[Scan]) error
+	mustEmbedUnimplementedSpecScansServer()
+}
+
+// UnimplementedSpecScansServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSpecScansServer struct{}
+
+func (UnimplementedSpecScansServer) AddScans(grpc.ClientStreamingServer[Scan, AddScansResponse]) error {
+	return status.Error(codes.Unimplemented, "method AddScans not implemented")
+}
+func (UnimplementedSpecScansServer) EditScan(context.Context, *EditScanRequest) (*Scan, error) {
+	return nil, status.Error(codes.Unimplemented, "method EditScan not implemented")
+}
+func (UnimplementedSpecScansServer) SearchScans(*SearchScansRequest, grpc.ServerStreamingServer[Scan]) error {
+	return status.Error(codes.Unimplemented, "method SearchScans not implemented")
+}
+func (UnimplementedSpecScansServer) mustEmbedUnimplementedSpecScansServer() {}
+func (UnimplementedSpecScansServer) testEmbeddedByValue()                   {}
+
+// UnsafeSpecScansServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SpecScansServer will
+// result in compilation errors.
+type UnsafeSpecScansServer interface {
+	mustEmbedUnimplementedSpecScansServer()
+}
+
+func RegisterSpecScansServer(s grpc.ServiceRegistrar, srv SpecScansServer) {
+	// If the following call panics, it indicates UnimplementedSpecScansServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SpecScans_ServiceDesc, srv)
+}
+
+func _SpecScans_AddScans_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SpecScansServer).AddScans(&grpc.GenericServerStream[Scan, AddScansResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SpecScans_AddScansServer = grpc.ClientStreamingServer[Scan, AddScansResponse]
+
+func _SpecScans_EditScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EditScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpecScansServer).EditScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SpecScans_EditScan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpecScansServer).EditScan(ctx, req.(*EditScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SpecScans_SearchScans_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchScansRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SpecScansServer).SearchScans(m, &grpc.GenericServerStream[SearchScansRequest, Scan]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SpecScans_SearchScansServer = grpc.ServerStreamingServer[Scan]
+
+// SpecScans_ServiceDesc is the grpc.ServiceDesc for SpecScans service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SpecScans_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "specscans.v1.SpecScans",
+	HandlerType: (*SpecScansServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EditScan",
+			Handler:    _SpecScans_EditScan_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AddScans",
+			Handler:       _SpecScans_AddScans_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "SearchScans",
+			Handler:       _SpecScans_SearchScans_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "specscans.proto",
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// PostgreSQL driver (registered as "pgx")
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// SQLDialect identifies the flavor of SQL spoken by the motor positions
+// database
+type SQLDialect string
+
+const (
+	SQLiteDialect   SQLDialect = "sqlite3"
+	MySQLDialect    SQLDialect = "mysql"
+	PostgresDialect SQLDialect = "postgres"
+)
+
+// Return the dialect and the database/sql driver name for a database type as
+// given in the DBFile configuration
+func ParseSQLDialect(dbtype string) (SQLDialect, string, error) {
+	switch strings.ToLower(dbtype) {
+	case "sqlite3", "sqlite":
+		return SQLiteDialect, dbtype, nil
+	case "mysql":
+		return MySQLDialect, dbtype, nil
+	case "postgres", "postgresql", "pgx":
+		return PostgresDialect, "pgx", nil
+	}
+	return "", "", fmt.Errorf("[SpecScansService.main.ParseSQLDialect] unsupported database type %q", dbtype)
+}
+
+// Rewrite a query written with "?" placeholders to use the placeholders of
+// the dialect ($1, $2, ... for PostgreSQL). Question marks within quoted
+// strings are left alone.
+func (d SQLDialect) Rebind(query string) string {
+	if d != PostgresDialect {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			b.WriteRune(r)
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Execute an INSERT statement (with "?" placeholders) and return the value of
+// the new row's id column. PostgreSQL does not support LastInsertId, so a
+// RETURNING clause is used there instead.
+func (d SQLDialect) InsertId(tx *sql.Tx, id_column string, query string, args ...any) (int64, error) {
+	if d == PostgresDialect {
+		var id int64
+		err := tx.QueryRow(d.Rebind(query)+" RETURNING "+id_column, args...).Scan(&id)
+		return id, err
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return -1, err
+	}
+	return result.LastInsertId()
+}
+
+// Return an INSERT statement (with "?" placeholders) adding nrows rows to
+// table that silently skips rows violating a unique constraint
+func (d SQLDialect) InsertIgnore(table string, columns []string, nrows int) string {
+	values := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), Placeholders(nrows, len(columns)))
+	switch d {
+	case MySQLDialect:
+		return strings.Replace(values, "INSERT", "INSERT IGNORE", 1)
+	case PostgresDialect:
+		return values + " ON CONFLICT DO NOTHING"
+	}
+	return strings.Replace(values, "INSERT", "INSERT OR IGNORE", 1)
+}
+
+// Check whether a table exists, without creating anything
+func (d SQLDialect) TableExists(db *sql.DB, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND LOWER(name) = LOWER(?)"
+	switch d {
+	case MySQLDialect:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND LOWER(table_name) = LOWER(?)"
+	case PostgresDialect:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND LOWER(table_name) = LOWER(?)"
+	}
+	var count int
+	err := db.QueryRow(d.Rebind(query), table).Scan(&count)
+	return count > 0, err
+}
+
+// Return the "?" placeholders of a multi-row VALUES list, e.g. "(?, ?), (?, ?)"
+// for two rows of two columns
+func Placeholders(nrows int, ncols int) string {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", ncols), ", ") + ")"
+	return strings.TrimSuffix(strings.Repeat(row+", ", nrows), ", ")
+}
@@ -1,8 +1,8 @@
-SELECT S.sid, M.motor_mne, P.motor_position
+SELECT S.sid, S.beamline, M.motor_mne, P.motor_position
 FROM MotorMnes as M
 JOIN MotorPositions AS P ON M.motor_id=P.motor_id
 JOIN ScanIds AS S ON S.scan_id=P.scan_id
-WHERE
+WHERE (
 {{ if gt (len .MotorPositionQueries) 0 }}
 
   S.scan_id IN (
@@ -14,17 +14,25 @@ WHERE
 
     {{ range $i, $MotorPositionQuery := .MotorPositionQueries }}
       {{ if $i }} OR {{ end }}
-      M.motor_mne='{{ .Mne }}'
+      M.motor_mne={{ arg .Mne }}
+      {{ if .Beamlines }}
+        AND M.beamline IN (
+          {{ range $ii, $beamline := .Beamlines }}
+            {{ if $ii }}, {{ end }}
+            {{ arg $beamline }}
+          {{ end }}
+        )
+      {{ end }}
 
       {{ if or .Exact (or .Min .Max) }}
         AND (
 
         {{ if and .Min .Max }}
-          P.motor_position BETWEEN {{ .Min }} AND {{ .Max }}
+          P.motor_position BETWEEN {{ arg .Min }} AND {{ arg .Max }}
         {{ else if .Min }}
-          P.motor_position>{{ .Min }}
+          P.motor_position>{{ arg .Min }}
         {{ else if .Max }}
-          P.motor_position<{{ .Max }}
+          P.motor_position<{{ arg .Max }}
         {{ end }}
 
         {{ if and .Exact (or .Min .Max) }}
@@ -33,12 +41,12 @@ WHERE
 
         {{ if .Exact }}
           {{ if eq 1 (len .Exact) }}
-            P.motor_position={{ index .Exact 0 }}
+            P.motor_position={{ arg (index .Exact 0) }}
           {{ else }}
             P.motor_position IN (
               {{ range $ii, $position := .Exact }}
                 {{ if $ii }}, {{ end }}
-                {{ $position }}
+                {{ arg $position }}
               {{ end }}
             )
           {{ end }}
@@ -58,15 +66,19 @@ WHERE
 {{ if gt (len .Sids) 0 }}
 
   {{ if eq 1 (len .Sids) }}
-    S.sid='{{ index .Sids 0 }}'
+    S.sid={{ arg (index .Sids 0) }}
   {{ else }}
     S.sid IN (
       {{ range $i, $sid := .Sids }}
         {{ if $i }} , {{ end }}
-        '{{ . }}'
+        {{ arg . }}
       {{ end }}
     )
   {{ end }}
 
 {{ end }}
+)
+{{ if .ExcludeDeleted }}
+  AND S.deleted_at IS NULL
+{{ end }}
 ;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS MotorPositions;
+DROP TABLE IF EXISTS MotorMnes;
+DROP TABLE IF EXISTS ScanIds;
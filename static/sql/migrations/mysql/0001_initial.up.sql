@@ -12,11 +12,10 @@ CREATE TABLE IF NOT EXISTS MotorPositions (
 scan_id INTEGER NOT NULL,
 motor_id INTEGER NOT NULL,
 motor_position DOUBLE,
-FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id) ON DELETE CASCADE ON UPDATE,
+FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id) ON DELETE CASCADE ON UPDATE CASCADE,
 FOREIGN KEY (scan_id) REFERENCES ScanIds(scan_id) ON DELETE CASCADE ON UPDATE CASCADE
 );
 
-
 -- Individual Indexes
 CREATE INDEX idx_sid ON ScanIds(sid);
 CREATE INDEX idx_motor_mne ON MotorMnes(motor_mne);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS MotorAliases;
+ALTER TABLE MotorMnes
+DROP COLUMN beamline,
+DROP COLUMN description,
+DROP COLUMN units;
@@ -0,0 +1,12 @@
+ALTER TABLE MotorMnes
+ADD COLUMN units VARCHAR(64),
+ADD COLUMN description TEXT,
+ADD COLUMN beamline VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS MotorAliases (
+alias VARCHAR(50) NOT NULL PRIMARY KEY COLLATE utf8mb4_bin,
+motor_id INTEGER NOT NULL,
+FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX idx_alias_motor_id ON MotorAliases(motor_id);
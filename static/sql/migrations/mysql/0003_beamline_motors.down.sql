@@ -0,0 +1,11 @@
+-- Fails if a mnemonic is used at more than one beamline
+ALTER TABLE ScanIds DROP COLUMN beamline;
+
+ALTER TABLE MotorAliases DROP PRIMARY KEY, ADD PRIMARY KEY (alias);
+ALTER TABLE MotorAliases DROP COLUMN beamline;
+
+ALTER TABLE MotorMnes
+DROP INDEX idx_beamline_motor_mne,
+ADD UNIQUE INDEX motor_mne (motor_mne),
+MODIFY beamline VARCHAR(64) NULL DEFAULT NULL;
+UPDATE MotorMnes SET beamline = NULL WHERE beamline = '';
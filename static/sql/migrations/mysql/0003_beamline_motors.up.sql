@@ -0,0 +1,15 @@
+-- Motors are identified by beamline and mnemonic
+UPDATE MotorMnes SET beamline = '' WHERE beamline IS NULL;
+ALTER TABLE MotorMnes
+MODIFY beamline VARCHAR(64) NOT NULL DEFAULT '',
+DROP INDEX motor_mne,
+ADD UNIQUE INDEX idx_beamline_motor_mne (beamline, motor_mne);
+
+ALTER TABLE MotorAliases ADD COLUMN beamline VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE MotorAliases a JOIN MotorMnes m ON a.motor_id = m.motor_id SET a.beamline = m.beamline;
+ALTER TABLE MotorAliases DROP PRIMARY KEY, ADD PRIMARY KEY (beamline, alias);
+
+-- Beamline of each scan's motors. Scans recorded before motors were scoped
+-- by beamline start at '', and -migrate then moves them to the beamlines of
+-- their documents (BackfillScanBeamlines).
+ALTER TABLE ScanIds ADD COLUMN beamline VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+ALTER TABLE ScanIds
+DROP COLUMN updated_at,
+DROP COLUMN updated_client,
+DROP COLUMN updated_by,
+DROP COLUMN created_at,
+DROP COLUMN created_client,
+DROP COLUMN created_by;
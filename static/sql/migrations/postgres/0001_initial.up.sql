@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS ScanIds (
+scan_id BIGSERIAL PRIMARY KEY,
+sid VARCHAR(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS MotorMnes (
+motor_id BIGSERIAL PRIMARY KEY,
+motor_mne VARCHAR(255) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS MotorPositions (
+scan_id BIGINT NOT NULL,
+motor_id BIGINT NOT NULL,
+motor_position DOUBLE PRECISION,
+FOREIGN KEY (scan_id) REFERENCES ScanIds(scan_id) ON DELETE CASCADE,
+FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id) ON DELETE CASCADE
+);
+
+-- Individual Indexes
+CREATE INDEX IF NOT EXISTS idx_sid ON ScanIds(sid);
+CREATE INDEX IF NOT EXISTS idx_motor_mne ON MotorMnes(motor_mne);
+CREATE INDEX IF NOT EXISTS idx_motor_position ON MotorPositions(motor_position);
+
+-- Multi-column index on (sid, motor_mne, motor_position)
+CREATE INDEX IF NOT EXISTS idx_sid_motor_mne_position ON MotorPositions
+    (motor_id, motor_position);
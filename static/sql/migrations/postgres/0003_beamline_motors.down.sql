@@ -0,0 +1,12 @@
+-- Fails if a mnemonic is used at more than one beamline
+ALTER TABLE ScanIds DROP COLUMN beamline;
+
+ALTER TABLE MotorAliases DROP CONSTRAINT IF EXISTS motoraliases_pkey;
+ALTER TABLE MotorAliases ADD PRIMARY KEY (alias);
+ALTER TABLE MotorAliases DROP COLUMN beamline;
+
+ALTER TABLE MotorMnes DROP CONSTRAINT IF EXISTS motormnes_beamline_motor_mne_key;
+ALTER TABLE MotorMnes ADD CONSTRAINT motormnes_motor_mne_key UNIQUE (motor_mne);
+ALTER TABLE MotorMnes ALTER COLUMN beamline DROP NOT NULL;
+ALTER TABLE MotorMnes ALTER COLUMN beamline DROP DEFAULT;
+UPDATE MotorMnes SET beamline = NULL WHERE beamline = '';
@@ -0,0 +1,16 @@
+-- Motors are identified by beamline and mnemonic
+UPDATE MotorMnes SET beamline = '' WHERE beamline IS NULL;
+ALTER TABLE MotorMnes ALTER COLUMN beamline SET DEFAULT '';
+ALTER TABLE MotorMnes ALTER COLUMN beamline SET NOT NULL;
+ALTER TABLE MotorMnes DROP CONSTRAINT IF EXISTS motormnes_motor_mne_key;
+ALTER TABLE MotorMnes ADD CONSTRAINT motormnes_beamline_motor_mne_key UNIQUE (beamline, motor_mne);
+
+ALTER TABLE MotorAliases ADD COLUMN beamline VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE MotorAliases SET beamline = m.beamline FROM MotorMnes m WHERE MotorAliases.motor_id = m.motor_id;
+ALTER TABLE MotorAliases DROP CONSTRAINT IF EXISTS motoraliases_pkey;
+ALTER TABLE MotorAliases ADD PRIMARY KEY (beamline, alias);
+
+-- Beamline of each scan's motors. Scans recorded before motors were scoped
+-- by beamline start at '', and -migrate then moves them to the beamlines of
+-- their documents (BackfillScanBeamlines).
+ALTER TABLE ScanIds ADD COLUMN beamline VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+-- Who added and last changed each scan's record (user and client), and when
+ALTER TABLE ScanIds
+ADD COLUMN created_by VARCHAR(255),
+ADD COLUMN created_client VARCHAR(255),
+ADD COLUMN created_at DOUBLE PRECISION,
+ADD COLUMN updated_by VARCHAR(255),
+ADD COLUMN updated_client VARCHAR(255),
+ADD COLUMN updated_at DOUBLE PRECISION;
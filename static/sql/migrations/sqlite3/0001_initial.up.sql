@@ -17,10 +17,10 @@ FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id)
 );
 
 -- Individual Indexes
-CREATE INDEX idx_sid ON ScanIds(sid);
-CREATE INDEX idx_motor_mne ON MotorMnes(motor_mne);
-CREATE INDEX idx_motor_position ON MotorPositions(motor_position);
+CREATE INDEX IF NOT EXISTS idx_sid ON ScanIds(sid);
+CREATE INDEX IF NOT EXISTS idx_motor_mne ON MotorMnes(motor_mne);
+CREATE INDEX IF NOT EXISTS idx_motor_position ON MotorPositions(motor_position);
 
 -- Multi-column index on (sid, motor_mne, motor_position)
-CREATE INDEX idx_sid_motor_mne_position ON MotorPositions
+CREATE INDEX IF NOT EXISTS idx_sid_motor_mne_position ON MotorPositions
     (motor_id, motor_position);
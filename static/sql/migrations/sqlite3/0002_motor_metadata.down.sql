@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS MotorAliases;
+ALTER TABLE MotorMnes DROP COLUMN beamline;
+ALTER TABLE MotorMnes DROP COLUMN description;
+ALTER TABLE MotorMnes DROP COLUMN units;
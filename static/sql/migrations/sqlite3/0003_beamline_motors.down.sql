@@ -0,0 +1,25 @@
+-- Fails if a mnemonic is used at more than one beamline
+ALTER TABLE ScanIds DROP COLUMN beamline;
+
+CREATE TABLE MotorAliases_global (
+alias VARCHAR(255) NOT NULL PRIMARY KEY,
+motor_id INTEGER NOT NULL,
+FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id)
+);
+INSERT INTO MotorAliases_global (alias, motor_id) SELECT alias, motor_id FROM MotorAliases;
+DROP TABLE MotorAliases;
+ALTER TABLE MotorAliases_global RENAME TO MotorAliases;
+CREATE INDEX IF NOT EXISTS idx_alias_motor_id ON MotorAliases(motor_id);
+
+CREATE TABLE MotorMnes_global (
+motor_id INTEGER PRIMARY KEY AUTOINCREMENT,
+motor_mne VARCHAR(255) NOT NULL UNIQUE,
+units VARCHAR(64),
+description TEXT,
+beamline VARCHAR(64)
+);
+INSERT INTO MotorMnes_global (motor_id, motor_mne, units, description, beamline)
+SELECT motor_id, motor_mne, units, description, NULLIF(beamline, '') FROM MotorMnes;
+DROP TABLE MotorMnes;
+ALTER TABLE MotorMnes_global RENAME TO MotorMnes;
+CREATE INDEX IF NOT EXISTS idx_motor_mne ON MotorMnes(motor_mne);
@@ -0,0 +1,35 @@
+-- Motors are identified by beamline and mnemonic. SQLite cannot change
+-- constraints in place, so the tables are rebuilt.
+UPDATE MotorMnes SET beamline = '' WHERE beamline IS NULL;
+
+CREATE TABLE MotorMnes_scoped (
+motor_id INTEGER PRIMARY KEY AUTOINCREMENT,
+beamline VARCHAR(64) NOT NULL DEFAULT '',
+motor_mne VARCHAR(255) NOT NULL,
+units VARCHAR(64),
+description TEXT,
+UNIQUE (beamline, motor_mne)
+);
+INSERT INTO MotorMnes_scoped (motor_id, beamline, motor_mne, units, description)
+SELECT motor_id, beamline, motor_mne, units, description FROM MotorMnes;
+DROP TABLE MotorMnes;
+ALTER TABLE MotorMnes_scoped RENAME TO MotorMnes;
+CREATE INDEX IF NOT EXISTS idx_motor_mne ON MotorMnes(motor_mne);
+
+CREATE TABLE MotorAliases_scoped (
+beamline VARCHAR(64) NOT NULL DEFAULT '',
+alias VARCHAR(255) NOT NULL,
+motor_id INTEGER NOT NULL,
+PRIMARY KEY (beamline, alias),
+FOREIGN KEY (motor_id) REFERENCES MotorMnes(motor_id)
+);
+INSERT INTO MotorAliases_scoped (beamline, alias, motor_id)
+SELECT m.beamline, a.alias, a.motor_id FROM MotorAliases a JOIN MotorMnes m ON a.motor_id = m.motor_id;
+DROP TABLE MotorAliases;
+ALTER TABLE MotorAliases_scoped RENAME TO MotorAliases;
+CREATE INDEX IF NOT EXISTS idx_alias_motor_id ON MotorAliases(motor_id);
+
+-- Beamline of each scan's motors. Scans recorded before motors were scoped
+-- by beamline start at '', and -migrate then moves them to the beamlines of
+-- their documents (BackfillScanBeamlines).
+ALTER TABLE ScanIds ADD COLUMN beamline VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS VariableValues;
+DROP TABLE IF EXISTS Variables;
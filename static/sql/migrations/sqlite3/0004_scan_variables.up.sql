@@ -0,0 +1,23 @@
+-- Registry of the variables (EPICS PVs, SPEC globals, ...) of scan records
+CREATE TABLE IF NOT EXISTS Variables (
+variable_id INTEGER PRIMARY KEY AUTOINCREMENT,
+variable_name VARCHAR(255) NOT NULL UNIQUE,
+variable_type VARCHAR(16) NOT NULL,
+units VARCHAR(64),
+description TEXT,
+declared BOOLEAN NOT NULL DEFAULT FALSE,
+indexed BOOLEAN NOT NULL DEFAULT FALSE,
+stored BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Values of the stored numeric variables of each scan
+CREATE TABLE IF NOT EXISTS VariableValues (
+scan_id INTEGER NOT NULL,
+variable_id INTEGER NOT NULL,
+variable_value FLOAT,
+PRIMARY KEY (scan_id, variable_id),
+FOREIGN KEY (scan_id) REFERENCES ScanIds(scan_id),
+FOREIGN KEY (variable_id) REFERENCES Variables(variable_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_variable_value ON VariableValues(variable_id, variable_value);
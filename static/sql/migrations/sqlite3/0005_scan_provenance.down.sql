@@ -0,0 +1,6 @@
+ALTER TABLE ScanIds DROP COLUMN updated_at;
+ALTER TABLE ScanIds DROP COLUMN updated_client;
+ALTER TABLE ScanIds DROP COLUMN updated_by;
+ALTER TABLE ScanIds DROP COLUMN created_at;
+ALTER TABLE ScanIds DROP COLUMN created_client;
+ALTER TABLE ScanIds DROP COLUMN created_by;
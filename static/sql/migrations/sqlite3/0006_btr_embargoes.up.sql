@@ -0,0 +1,6 @@
+-- Embargoes hiding the scans of beamtime requests (BTRs) from general search
+-- until the given (epoch) time
+CREATE TABLE IF NOT EXISTS Embargoes (
+btr VARCHAR(255) NOT NULL PRIMARY KEY,
+embargo_until FLOAT NOT NULL
+);
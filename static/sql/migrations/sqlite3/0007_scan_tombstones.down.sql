@@ -0,0 +1 @@
+ALTER TABLE ScanIds DROP COLUMN deleted_at;
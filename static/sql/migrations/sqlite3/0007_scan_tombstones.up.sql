@@ -0,0 +1,3 @@
+-- Time (epoch) each deleted scan was deleted; deleted scans are kept as
+-- tombstones until they are purged
+ALTER TABLE ScanIds ADD COLUMN deleted_at FLOAT;
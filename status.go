@@ -0,0 +1,289 @@
+package main
+
+// status module
+//
+// Scans follow a status lifecycle: they start "running" and end "completed",
+// "aborted" or "failed". The end statuses are final. Every change of status
+// is kept in the status history of the scan's document, and the end of a scan
+// records its end time and duration.
+//
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses of the scan lifecycle
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusAborted   = "aborted"
+	StatusFailed    = "failed"
+)
+
+// Known scan statuses
+var ScanStatuses = []string{StatusRunning, StatusCompleted, StatusAborted, StatusFailed}
+
+// Allowed changes of status; statuses without any are final
+var StatusTransitions = map[string][]string{
+	StatusRunning: {StatusCompleted, StatusAborted, StatusFailed},
+}
+
+// Record keys maintained by status transitions, which edits may not set
+var StatusKeys = []string{"end_time", "duration", "status_history"}
+
+// Errors of status transitions
+var (
+	ErrInvalidStatus     = errors.New("invalid status")
+	ErrIllegalTransition = errors.New("illegal status transition")
+	ErrScanNotFound      = errors.New("scan not found")
+)
+
+// StatusChange is an entry of the status history of a scan
+type StatusChange struct {
+	Status string  `json:"status" mapstructure:"status"`
+	Time   float64 `json:"time" mapstructure:"time"`
+}
+
+// StatusTransition is a request to change the status of a scan, at the given
+// time (now if it is 0)
+type StatusTransition struct {
+	ScanId string  `json:"sid"`
+	Status string  `json:"status"`
+	Time   float64 `json:"time,omitempty"`
+}
+
+// Check that a status is known
+func ValidateStatus(status string) error {
+	if !inList(status, ScanStatuses) {
+		return fmt.Errorf("[SpecScansService.main.ValidateStatus] %w %q; must be one of %v", ErrInvalidStatus, status, ScanStatuses)
+	}
+	return nil
+}
+
+// Check that a scan may change from one status to another
+func ValidateTransition(from string, to string) error {
+	if err := ValidateStatus(to); err != nil {
+		return err
+	}
+	if !inList(to, StatusTransitions[from]) {
+		return fmt.Errorf("[SpecScansService.main.ValidateTransition] %w from %q to %q", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
+
+// Check the status of a new record and start its status history. A record
+// added with an end status may give its end time, from which its duration is
+// recorded.
+func PrepareStatus(record *UserRecord) error {
+	if err := ValidateStatus(record.Status); err != nil {
+		return err
+	}
+	change := StatusChange{Status: record.Status, Time: record.StartTime}
+	record.Duration = 0
+	if record.EndTime != 0 {
+		if record.Status == StatusRunning {
+			return fmt.Errorf("[SpecScansService.main.PrepareStatus] a running scan has no end_time")
+		}
+		if record.EndTime < record.StartTime {
+			return fmt.Errorf("[SpecScansService.main.PrepareStatus] end_time %v is before start_time %v", record.EndTime, record.StartTime)
+		}
+		record.Duration = record.EndTime - record.StartTime
+		change.Time = record.EndTime
+	}
+	record.StatusHistory = []StatusChange{change}
+	return nil
+}
+
+// Return the update of a scan's document changing its status at the given
+// time: the new status, the end time and duration if the scan ends, and the
+// change added to its history
+func statusUpdate(record MongoRecord, status string, at float64) (map[string]any, error) {
+	if err := ValidateTransition(record.Status, status); err != nil {
+		return nil, err
+	}
+	if at < record.StartTime {
+		return nil, fmt.Errorf("[SpecScansService.main.statusUpdate] %w: time %v of status %s is before start_time %v", ErrInvalidStatus, at, status, record.StartTime)
+	}
+	set := map[string]any{"status": status}
+	if len(StatusTransitions[status]) == 0 {
+		set["end_time"] = at
+		set["duration"] = at - record.StartTime
+	}
+	update := map[string]any{
+		"$set":  set,
+		"$push": map[string]any{"status_history": StatusChange{Status: status, Time: at}},
+	}
+	return update, nil
+}
+
+// Change the status of a scan in the given stores on behalf of the given
+// caller with the given access, and return its updated document
+func TransitionStatus(stores ScanStores, access RecordAccess, by Identity, transition StatusTransition) (MongoRecord, error) {
+	docs := stores.Docs
+	record, err := accessibleRecord(stores, access, transition.ScanId)
+	if err != nil {
+		return record, err
+	}
+	if record.DeletedAt != 0 {
+		return record, fmt.Errorf("[SpecScansService.main.TransitionStatus] %w: %s", ErrScanDeleted, transition.ScanId)
+	}
+	now := epochTime(time.Now())
+	at := transition.Time
+	if at == 0 {
+		at = now
+	}
+	update, err := statusUpdate(record, transition.Status, at)
+	if err != nil {
+		return record, err
+	}
+	for k, v := range updatedFields(by, now) {
+		update["$set"].(map[string]any)[k] = v
+	}
+	// The update only applies if the status is still the one checked, a
+	// concurrent change being reported below
+	spec := map[string]any{"sid": record.ScanId, "status": record.Status}
+	if err = docs.Update(spec, update); err != nil && !errors.Is(err, ErrScanNotFound) {
+		return record, fmt.Errorf("[SpecScansService.main.TransitionStatus] docs.Update error: %w", err)
+	}
+	records, err := getMongoRecords(docs, map[string]any{"sid": record.ScanId}, 0, 0)
+	if err != nil || len(records) != 1 {
+		return record, fmt.Errorf("[SpecScansService.main.TransitionStatus] getMongoRecords error: %v", err)
+	}
+	updated := records[0]
+	history := updated.StatusHistory
+	if updated.Status != transition.Status || len(history) == 0 || history[len(history)-1].Time != at {
+		return updated, fmt.Errorf("[SpecScansService.main.TransitionStatus] %w: status of %s changed to %q concurrently", ErrIllegalTransition, record.ScanId, updated.Status)
+	}
+	if err = stores.Motors.SetScanUpdated(record.ScanId, by, now); err != nil {
+		return updated, fmt.Errorf("[SpecScansService.main.TransitionStatus] stores.Motors.SetScanUpdated error: %w", err)
+	}
+	publishScanEvent(stores, ScanStatus, record.ScanId)
+	return updated, nil
+}
+
+// Return the status fields of a scan's document
+func statusRecord(record MongoRecord) map[string]any {
+	status_record := map[string]any{
+		"sid":            record.ScanId,
+		"status":         record.Status,
+		"start_time":     record.StartTime,
+		"status_history": record.StatusHistory,
+	}
+	if record.EndTime != 0 {
+		status_record["end_time"] = record.EndTime
+		status_record["duration"] = record.Duration
+	}
+	return status_record
+}
+
+// Handler for getting the status and status history of the scans given by
+// "sid" URL parameters
+func StatusHandler(c *gin.Context) {
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	access.IncludeDeleted, err = includeDeletedRequest(c)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	sids := c.QueryArray("sid")
+	if len(sids) == 0 {
+		err := errors.New("no sid given")
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	spec := access.Restrict(map[string]any{"sid": map[string]any{"$in": anyList(sids)}})
+	records, err := getMongoRecords(stores.Docs, spec, 0, 0)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var status_records []map[string]any
+	for _, record := range records {
+		status_records = append(status_records, statusRecord(record))
+	}
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(status_records),
+			Records:  status_records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for changing the status of a scan
+func TransitionStatusHandler(c *gin.Context) {
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	defer c.Request.Body.Close()
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("ReadAll error: %v", err)
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ReaderError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var transition StatusTransition
+	err = json.Unmarshal(body, &transition)
+	if err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.UnmarshalError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	record, err := TransitionStatus(stores, access, requestIdentity(c), transition)
+	if err != nil {
+		code, srvcode := http.StatusInternalServerError, services.UpdateError
+		if errors.Is(err, ErrScanNotFound) {
+			code, srvcode = http.StatusNotFound, services.NotFoundError
+		} else if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrScanDeleted) {
+			code, srvcode = http.StatusConflict, services.ValidateError
+		} else if errors.Is(err, ErrInvalidStatus) {
+			code, srvcode = http.StatusBadRequest, services.ValidateError
+		}
+		resp := services.Response("SpecScans", code, srvcode, err)
+		c.JSON(code, resp)
+		return
+	}
+	log.Printf("Status of %s changed to %s", record.ScanId, record.Status)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: 1,
+			Records:  []map[string]any{statusRecord(record)},
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
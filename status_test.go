@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test the allowed changes of status
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		err  error
+	}{
+		{from: StatusRunning, to: StatusCompleted},
+		{from: StatusRunning, to: StatusFailed},
+		{from: StatusCompleted, to: StatusRunning, err: ErrIllegalTransition},
+		{from: StatusAborted, to: StatusCompleted, err: ErrIllegalTransition},
+		{from: StatusRunning, to: StatusRunning, err: ErrIllegalTransition},
+		{from: "n/a", to: StatusCompleted, err: ErrIllegalTransition},
+		{from: StatusRunning, to: "done", err: ErrInvalidStatus},
+	}
+	for _, tt := range tests {
+		err := ValidateTransition(tt.from, tt.to)
+		if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("ValidateTransition(%s, %s) = %v; want %v", tt.from, tt.to, err, tt.err)
+		}
+	}
+}
+
+// Test changing the status of scans and querying their status history
+func TestStatusLifecycle(t *testing.T) {
+	r := SetupTestService(t)
+
+	record := testUserRecord(1, 1709647200, map[string]float64{"samx": 1})
+	record.Status = "done"
+	response := serveTestRequest(t, r, "POST", "/add", record)
+	if response.HttpCode != http.StatusUnprocessableEntity {
+		t.Errorf("adding a record with an unknown status returned %+v", response)
+	}
+	response = serveTestRequest(t, r, "POST", "/add", testUserRecord(1, 1709647200, map[string]float64{"samx": 1}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("adding a record failed: %+v", response)
+	}
+	sid := response.Results.Records[0]["sid"]
+
+	transition := StatusTransition{ScanId: sid.(string), Status: StatusCompleted, Time: 1709647100}
+	response = serveTestRequest(t, r, "POST", "/status", transition)
+	if response.HttpCode != http.StatusBadRequest {
+		t.Errorf("ending a scan before its start returned %+v", response)
+	}
+	transition.Time = 1709647290.5
+	response = serveTestRequest(t, r, "POST", "/status", transition)
+	if response.SrvCode != services.OK {
+		t.Fatalf("completing a scan failed: %+v", response)
+	}
+	status := response.Results.Records[0]
+	if status["end_time"] != 1709647290.5 || status["duration"] != 90.5 {
+		t.Errorf("completing a scan returned %+v", status)
+	}
+
+	transition.Status = StatusRunning
+	response = serveTestRequest(t, r, "POST", "/status", transition)
+	if response.HttpCode != http.StatusConflict {
+		t.Errorf("restarting a completed scan returned %+v", response)
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sid, "status": StatusRunning})
+	if response.SrvCode == services.OK {
+		t.Errorf("editing a completed scan back to running was accepted: %+v", response)
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sid, "duration": 1.0})
+	if response.SrvCode == services.OK {
+		t.Errorf("editing the duration of a scan was accepted: %+v", response)
+	}
+	response = serveTestRequest(t, r, "POST", "/status", StatusTransition{ScanId: "unknown", Status: StatusFailed})
+	if response.HttpCode != http.StatusNotFound {
+		t.Errorf("changing the status of an unknown scan returned %+v", response)
+	}
+
+	response = serveTestRequest(t, r, "GET", "/status?sid="+sid.(string), nil)
+	if response.Results.NRecords != 1 {
+		t.Fatalf("getting the status of %s returned %+v", sid, response)
+	}
+	history, _ := response.Results.Records[0]["status_history"].([]any)
+	if len(history) != 2 {
+		t.Fatalf("status history is %+v; want 2 changes", history)
+	}
+	if change := history[1].(map[string]any); change["status"] != StatusCompleted || change["time"] != 1709647290.5 {
+		t.Errorf("last status change is %+v", change)
+	}
+	if found := searchTestService(t, r, `{"status_history.status": "completed", "duration": {"$gt": 60}}`); len(found) != 1 {
+		t.Errorf("search on status history and duration found %d records; want 1", len(found))
+	}
+}
+
+// racingDocumentStore is a document store whose documents get the given
+// status just before the next update, like a concurrent status change
+type racingDocumentStore struct {
+	DocumentStore
+	status string
+}
+
+func (s *racingDocumentStore) Update(spec map[string]any, update map[string]any) error {
+	if s.status != "" {
+		status := s.status
+		s.status = ""
+		err := s.DocumentStore.Update(map[string]any{"sid": spec["sid"]}, map[string]any{"$set": map[string]any{"status": status}})
+		if err != nil {
+			return err
+		}
+	}
+	return s.DocumentStore.Update(spec, update)
+}
+
+// Test that an edit of the status of a scan whose status changes
+// concurrently is rejected, and leaves the scan alone
+func TestEditStatusConflict(t *testing.T) {
+	r := SetupTestService(t)
+	response := serveTestRequest(t, r, "POST", "/add", testUserRecord(1, 1709647200, map[string]float64{"samx": 1}))
+	if response.SrvCode != services.OK {
+		t.Fatalf("adding a record failed: %+v", response)
+	}
+	sid := response.Results.Records[0]["sid"].(string)
+
+	ScanDocs = &racingDocumentStore{DocumentStore: ScanDocs, status: StatusFailed}
+	edit := map[string]any{"sid": sid, "status": StatusCompleted, "command": "dscan samx 0 1 10 1"}
+	response = serveTestRequest(t, r, "PUT", "/edit", edit)
+	if response.HttpCode != http.StatusConflict {
+		t.Errorf("editing the status of a scan changed concurrently returned %+v", response)
+	}
+	records, err := getMongoRecords(ScanDocs, map[string]any{"sid": sid}, 0, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("getting %s returned %+v, %v", sid, records, err)
+	}
+	if records[0].Status != StatusFailed || records[0].Command != "ascan  samx 0 1 10 1" || records[0].UpdatedAt != records[0].CreatedAt {
+		t.Errorf("scan changed concurrently is %+v after the edit", records[0])
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	mongo "github.com/CHESSComputing/golib/mongo"
+	bson "go.mongodb.org/mongo-driver/v2/bson"
+	mongodriver "go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// DocumentStore stores the documents of scan records (the portion of each
+// record that does not go to the motor positions database). Specs and
+// updates use MongoDB query and update syntax.
+type DocumentStore interface {
+	// Insert new documents
+	Insert(records ...map[string]any) error
+	// Get the documents matching spec, skipping idx documents and returning
+	// at most limit (all if limit is 0)
+	Get(spec map[string]any, idx int, limit int) ([]map[string]any, error)
+	// Count the documents matching spec
+	Count(spec map[string]any) (int, error)
+	// Apply update (e.g. {"$set": {...}}) to the first document matching
+	// spec. Unlike upserts, no document is created if none matches, so an
+	// edit of a record removed or changed since it was read does not leave a
+	// partial document behind: ErrScanNotFound is returned instead.
+	Update(spec map[string]any, update map[string]any) error
+	// Remove all documents matching spec
+	Remove(spec map[string]any) error
+	// Create an ascending index on key unless it already exists
+	EnsureIndex(key string) error
+}
+
+// MotorStore stores the motor positions of scan records
+type MotorStore interface {
+	// Insert the motor positions of a new scan, returning the scan's
+	// internal id. Inserting a scan id twice is an error.
+	InsertMotors(r MotorRecord) (int64, error)
+	// Get the motor records of scans matching query
+	QueryMotors(query MotorsDbQuery) ([]MotorRecord, error)
+	// Call fn with the id and beamline of every stored scan, in insertion
+	// order. fn must not use the store.
+	ForEachScan(fn func(sid string, beamline string) error) error
+	// Remove a scan and its motor positions
+	RemoveMotors(sid string) error
+	// Change the id of a scan
+	RenameScan(sid string, new_sid string) error
+	// Move a scan's motor positions to the motors of another beamline
+	SetScanBeamline(sid string, beamline string) error
+	// Record who last changed a scan's record, and when
+	SetScanUpdated(sid string, by Identity, at float64) error
+	// Mark a scan as deleted at the given time, or as not deleted if the
+	// time is 0
+	SetScanDeleted(sid string, at float64) error
+	// Get the catalog entries of the given motors of the given beamlines (of
+	// all motors or beamlines if none are given), sorted by beamline and
+	// mnemonic
+	GetMotorInfo(beamlines []string, mnes []string) ([]MotorInfo, error)
+	// Create or replace the catalog entry of a beamline's motor, including
+	// its aliases
+	SetMotorInfo(info MotorInfo) error
+	// Return the motors which names are aliases of at the given beamlines
+	// (at any beamline if none are given)
+	ResolveAliases(beamlines []string, names ...string) ([]MotorAlias, error)
+}
+
+// VariableStore is the registry of the variables of scan records, and the
+// storage of the values of stored variables
+type VariableStore interface {
+	// Get the registry entries of the given variables (of all variables if
+	// none are given), sorted by name
+	GetVariableInfo(names []string) ([]VariableInfo, error)
+	// Record the variables observed in an ingested record: add new ones,
+	// widen the types of undeclared ones and fill in missing units
+	ObserveVariables(observed []VariableInfo) error
+	// Declare a variable, fixing its type
+	SetVariableInfo(info VariableInfo) error
+	// Replace the values of a scan's stored variables. The scan's motors
+	// must have been inserted first.
+	SetVariableValues(sid string, values map[string]float64) error
+}
+
+// EmbargoStore stores the embargoes of beamtime requests (BTRs)
+type EmbargoStore interface {
+	// Get the embargoes of the given BTRs (of all BTRs if none are given)
+	// which last beyond the given time, sorted by BTR
+	GetEmbargoes(btrs []string, after float64) ([]Embargo, error)
+	// Set the embargo of a BTR, or lift it if it has no end time
+	SetEmbargo(embargo Embargo) error
+}
+
+// ScanStores are the stores of one namespace of scan records (production or
+// sandbox)
+type ScanStores struct {
+	Docs      DocumentStore
+	Motors    MotorStore
+	Variables VariableStore
+	Embargoes EmbargoStore
+	// Whether these are the stores of the sandbox
+	Sandbox bool
+}
+
+// Return the stores of production scan records
+func ProductionStores() ScanStores {
+	return ScanStores{Docs: ScanDocs, Motors: ScanMotors, Variables: ScanVariables, Embargoes: ScanEmbargoes}
+}
+
+// var ScanDocs is the storage of scan documents used by all handlers
+var ScanDocs DocumentStore
+
+// var ScanMotors is the storage of motor positions used by all handlers
+var ScanMotors MotorStore
+
+// var ScanVariables is the variable registry used by all handlers
+var ScanVariables VariableStore
+
+// var ScanEmbargoes is the storage of BTR embargoes used by all handlers
+var ScanEmbargoes EmbargoStore
+
+// MongoDocumentStore is a DocumentStore backed by a MongoDB collection
+type MongoDocumentStore struct {
+	DBName string
+	DBColl string
+}
+
+func NewMongoDocumentStore(dbname string, dbcoll string) *MongoDocumentStore {
+	return &MongoDocumentStore{DBName: dbname, DBColl: dbcoll}
+}
+
+func (s *MongoDocumentStore) Insert(records ...map[string]any) error {
+	for _, record := range records {
+		err := mongo.InsertRecord(s.DBName, s.DBColl, record)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.MongoDocumentStore.Insert] mongo.InsertRecord error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *MongoDocumentStore) Get(spec map[string]any, idx int, limit int) ([]map[string]any, error) {
+	return mongo.Get(s.DBName, s.DBColl, spec, idx, limit), nil
+}
+
+func (s *MongoDocumentStore) Count(spec map[string]any) (int, error) {
+	return mongo.Count(s.DBName, s.DBColl, spec), nil
+}
+
+func (s *MongoDocumentStore) Update(spec map[string]any, update map[string]any) error {
+	// mongo.Update does not tell whether a document matched, so the
+	// collection is updated directly
+	collection := mongo.Mongo.Connect().Database(s.DBName).Collection(s.DBColl)
+	result, err := collection.UpdateOne(context.TODO(), spec, update)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MongoDocumentStore.Update] UpdateOne error: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[SpecScansService.main.MongoDocumentStore.Update] %w: %v", ErrScanNotFound, spec)
+	}
+	return nil
+}
+
+func (s *MongoDocumentStore) Remove(spec map[string]any) error {
+	err := mongo.Remove(s.DBName, s.DBColl, spec)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MongoDocumentStore.Remove] mongo.Remove error: %w", err)
+	}
+	return nil
+}
+
+func (s *MongoDocumentStore) EnsureIndex(key string) error {
+	collection := mongo.Mongo.Connect().Database(s.DBName).Collection(s.DBColl)
+	index := mongodriver.IndexModel{Keys: bson.D{{Key: key, Value: 1}}}
+	_, err := collection.Indexes().CreateOne(context.TODO(), index)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.MongoDocumentStore.EnsureIndex] CreateOne error: %w", err)
+	}
+	return nil
+}
+
+// SQLMotorStore is a MotorStore backed by the SQL motor positions database
+type SQLMotorStore struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+}
+
+func NewSQLMotorStore(db *sql.DB, dialect SQLDialect) *SQLMotorStore {
+	return &SQLMotorStore{DB: db, Dialect: dialect}
+}
+
+func (s *SQLMotorStore) InsertMotors(r MotorRecord) (int64, error) {
+	return InsertMotors(r, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) QueryMotors(query MotorsDbQuery) ([]MotorRecord, error) {
+	return queryMotorsDb(query, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) ForEachScan(fn func(sid string, beamline string) error) error {
+	return forEachScan(s.DB, fn)
+}
+
+func (s *SQLMotorStore) RemoveMotors(sid string) error {
+	return RemoveMotors(sid, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) RenameScan(sid string, new_sid string) error {
+	return RenameScan(sid, new_sid, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) SetScanBeamline(sid string, beamline string) error {
+	return SetScanBeamline(sid, beamline, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) SetScanUpdated(sid string, by Identity, at float64) error {
+	return SetScanUpdated(sid, by, at, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) SetScanDeleted(sid string, at float64) error {
+	return SetScanDeleted(sid, at, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) GetMotorInfo(beamlines []string, mnes []string) ([]MotorInfo, error) {
+	return GetMotorInfo(beamlines, mnes, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) SetMotorInfo(info MotorInfo) error {
+	return SetMotorInfo(info, s.DB, s.Dialect)
+}
+
+func (s *SQLMotorStore) ResolveAliases(beamlines []string, names ...string) ([]MotorAlias, error) {
+	return ResolveAliases(beamlines, names, s.DB, s.Dialect)
+}
+
+// SQLVariableStore is a VariableStore backed by the SQL motor positions
+// database
+type SQLVariableStore struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+}
+
+func NewSQLVariableStore(db *sql.DB, dialect SQLDialect) *SQLVariableStore {
+	return &SQLVariableStore{DB: db, Dialect: dialect}
+}
+
+func (s *SQLVariableStore) GetVariableInfo(names []string) ([]VariableInfo, error) {
+	return GetVariableInfo(names, s.DB, s.Dialect)
+}
+
+func (s *SQLVariableStore) ObserveVariables(observed []VariableInfo) error {
+	return ObserveVariables(observed, s.DB, s.Dialect)
+}
+
+func (s *SQLVariableStore) SetVariableInfo(info VariableInfo) error {
+	return SetVariableInfo(info, s.DB, s.Dialect)
+}
+
+func (s *SQLVariableStore) SetVariableValues(sid string, values map[string]float64) error {
+	return SetVariableValues(sid, values, s.DB, s.Dialect)
+}
+
+// SQLEmbargoStore is an EmbargoStore backed by the SQL motor positions
+// database
+type SQLEmbargoStore struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+}
+
+func NewSQLEmbargoStore(db *sql.DB, dialect SQLDialect) *SQLEmbargoStore {
+	return &SQLEmbargoStore{DB: db, Dialect: dialect}
+}
+
+func (s *SQLEmbargoStore) GetEmbargoes(btrs []string, after float64) ([]Embargo, error) {
+	return GetEmbargoes(btrs, after, s.DB, s.Dialect)
+}
+
+func (s *SQLEmbargoStore) SetEmbargo(embargo Embargo) error {
+	return SetEmbargo(embargo, s.DB, s.Dialect)
+}
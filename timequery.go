@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys of scan records whose values are epoch times
+var TimeKeys = []string{"start_time", "end_time", "status_history.time", "created_at", "updated_at", "embargo_until", "deleted_at"}
+
+// Layouts accepted for human-readable timestamps, most specific first.
+// Timestamps without a zone offset are interpreted in the server's local time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Layout of a date without time of day
+const dateLayout = "2006-01-02"
+
+// Pattern for relative time expressions like "last 24h" or "last 7 days"
+var relativeTimePattern = regexp.MustCompile(`^last\s*(\d+)\s*([a-z]+)$`)
+
+// Pattern for weekday-relative expressions like "last tuesday" or "last
+// tuesday at 14:00"
+var weekdayTimePattern = regexp.MustCompile(`^last\s+([a-z]+)(?:\s+at\s+(\d{1,2}):(\d{2})(?::(\d{2}))?)?$`)
+
+// Names of weekdays accepted in weekday-relative expressions
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// Mongo operators whose values are single times
+var timeOperators = []string{"$eq", "$ne", "$gt", "$gte", "$lt", "$lte"}
+
+// Mongo operators whose values are lists of times
+var timeListOperators = []string{"$in", "$nin"}
+
+// Mongo operators whose values are lists of sub-queries
+var logicalOperators = []string{"$and", "$or", "$nor"}
+
+// Convert human-readable values of the time keys in a mongo query spec into
+// epoch times. Numeric values are left as they are.
+func ConvertTimeQueries(spec map[string]any) (map[string]any, error) {
+	return convertTimeQueries(spec, time.Now())
+}
+
+func convertTimeQueries(spec map[string]any, now time.Time) (map[string]any, error) {
+	if spec == nil {
+		return spec, nil
+	}
+	converted := make(map[string]any, len(spec))
+	for key, val := range spec {
+		if inList(key, logicalOperators) {
+			subqueries, ok := val.([]any)
+			if !ok {
+				converted[key] = val
+				continue
+			}
+			var converted_subqueries []any
+			for _, subquery := range subqueries {
+				subquery_map, ok := subquery.(map[string]any)
+				if !ok {
+					converted_subqueries = append(converted_subqueries, subquery)
+					continue
+				}
+				converted_subquery, err := convertTimeQueries(subquery_map, now)
+				if err != nil {
+					return spec, err
+				}
+				converted_subqueries = append(converted_subqueries, converted_subquery)
+			}
+			converted[key] = converted_subqueries
+		} else if inList(key, TimeKeys) {
+			converted_val, err := convertTimeQuery(val, now)
+			if err != nil {
+				return spec, fmt.Errorf("[SpecScansService.main.ConvertTimeQueries] invalid value for %s: %w", key, err)
+			}
+			converted[key] = converted_val
+		} else {
+			converted[key] = val
+		}
+	}
+	return converted, nil
+}
+
+// Convert the query value for a single time key. Strings may be timestamps,
+// ranges ("<start>..<end>" or "<start>/<end>") or relative expressions ("last
+// 24h", "today", "yesterday", "last tuesday", "last tuesday at 14:00"); maps
+// may contain comparison operators with human-readable values. "last <weekday>"
+// is the most recent such day before today, so on a Tuesday "last tuesday" is
+// a week ago.
+func convertTimeQuery(val any, now time.Time) (any, error) {
+	switch v := val.(type) {
+	case string:
+		return parseTimeString(v, now)
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for op, opval := range v {
+			if inList(op, timeOperators) {
+				t, err := parseTimeBound(opval, now)
+				if err != nil {
+					return val, err
+				}
+				converted[op] = t
+			} else if inList(op, timeListOperators) {
+				opvals, ok := opval.([]any)
+				if !ok {
+					return val, fmt.Errorf("%s requires a list of times", op)
+				}
+				var times []any
+				for _, item := range opvals {
+					t, err := parseTimeBound(item, now)
+					if err != nil {
+						return val, err
+					}
+					times = append(times, t)
+				}
+				converted[op] = times
+			} else {
+				converted[op] = opval
+			}
+		}
+		return converted, nil
+	default:
+		return val, nil
+	}
+}
+
+// Parse a string time query value into either an epoch time or a mongo range
+// spec
+func parseTimeString(s string, now time.Time) (any, error) {
+	s = strings.TrimSpace(s)
+	if epoch, err := strconv.ParseFloat(s, 64); err == nil {
+		return epoch, nil
+	}
+	lower := strings.ToLower(s)
+	switch lower {
+	case "today":
+		start := startOfDay(now)
+		return map[string]any{"$gte": epochTime(start), "$lt": epochTime(start.AddDate(0, 0, 1))}, nil
+	case "yesterday":
+		start := startOfDay(now).AddDate(0, 0, -1)
+		return map[string]any{"$gte": epochTime(start), "$lt": epochTime(start.AddDate(0, 0, 1))}, nil
+	}
+	if match := relativeTimePattern.FindStringSubmatch(lower); match != nil {
+		duration, err := parseRelativeDuration(match[1], match[2])
+		if err != nil {
+			return s, err
+		}
+		return map[string]any{"$gte": epochTime(now.Add(-duration)), "$lte": epochTime(now)}, nil
+	}
+	for _, sep := range []string{"..", "/"} {
+		if start, end, found := strings.Cut(s, sep); found {
+			return parseTimeRange(start, end, now)
+		}
+	}
+	if day, ok := parseDay(s, now); ok {
+		// A bare date matches the whole day
+		return map[string]any{"$gte": epochTime(day), "$lt": epochTime(day.AddDate(0, 0, 1))}, nil
+	}
+	t, err := parseTimestamp(s, now)
+	if err != nil {
+		return s, err
+	}
+	return epochTime(t), nil
+}
+
+// Parse a "<start>..<end>" range. Either end may be omitted for an open range.
+// A bare date as the end of the range includes that whole day.
+func parseTimeRange(start, end string, now time.Time) (map[string]any, error) {
+	start = strings.TrimSpace(start)
+	end = strings.TrimSpace(end)
+	if start == "" && end == "" {
+		return nil, errors.New("time range must have a start or an end")
+	}
+	spec := map[string]any{}
+	if start != "" {
+		t, err := parseTimestampOrDate(start, now)
+		if err != nil {
+			return nil, err
+		}
+		spec["$gte"] = epochTime(t)
+	}
+	if end != "" {
+		if day, ok := parseDay(end, now); ok {
+			spec["$lt"] = epochTime(day.AddDate(0, 0, 1))
+		} else {
+			t, err := parseTimestamp(end, now)
+			if err != nil {
+				return nil, err
+			}
+			spec["$lte"] = epochTime(t)
+		}
+	}
+	return spec, nil
+}
+
+// Parse a single bound used with a comparison operator into an epoch time
+func parseTimeBound(val any, now time.Time) (any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return val, nil
+	}
+	if epoch, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return epoch, nil
+	}
+	t, err := parseTimestampOrDate(strings.TrimSpace(s), now)
+	if err != nil {
+		return val, err
+	}
+	return epochTime(t), nil
+}
+
+func parseTimestampOrDate(s string, now time.Time) (time.Time, error) {
+	if day, ok := parseDay(s, now); ok {
+		return day, nil
+	}
+	return parseTimestamp(s, now)
+}
+
+// Parse a whole day, either a bare date or "last <weekday>", into the start
+// of that day
+func parseDay(s string, now time.Time) (time.Time, bool) {
+	if day, err := time.ParseInLocation(dateLayout, s, now.Location()); err == nil {
+		return day, true
+	}
+	match := weekdayTimePattern.FindStringSubmatch(strings.ToLower(s))
+	if match == nil || match[2] != "" {
+		return time.Time{}, false
+	}
+	return lastWeekday(match[1], now)
+}
+
+func parseTimestamp(s string, now time.Time) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if match := weekdayTimePattern.FindStringSubmatch(strings.ToLower(s)); match != nil && match[2] != "" {
+		day, ok := lastWeekday(match[1], now)
+		hour, _ := strconv.Atoi(match[2])
+		minute, _ := strconv.Atoi(match[3])
+		second, _ := strconv.Atoi(match[4])
+		if ok && hour < 24 && minute < 60 && second < 60 {
+			year, month, mday := day.Date()
+			return time.Date(year, month, mday, hour, minute, second, 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse time %q", s)
+}
+
+// Return the start of the most recent given weekday before today
+func lastWeekday(name string, now time.Time) (time.Time, bool) {
+	weekday, ok := weekdayNames[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	days := (int(now.Weekday())-int(weekday)+6)%7 + 1
+	return startOfDay(now).AddDate(0, 0, -days), true
+}
+
+func parseRelativeDuration(amount string, unit string) (time.Duration, error) {
+	n, err := strconv.Atoi(amount)
+	if err != nil {
+		return 0, fmt.Errorf("invalid relative time amount %q", amount)
+	}
+	var base time.Duration
+	switch unit {
+	case "s", "sec", "secs", "second", "seconds":
+		base = time.Second
+	case "m", "min", "mins", "minute", "minutes":
+		base = time.Minute
+	case "h", "hr", "hrs", "hour", "hours":
+		base = time.Hour
+	case "d", "day", "days":
+		base = 24 * time.Hour
+	case "w", "wk", "wks", "week", "weeks":
+		base = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("unknown relative time unit %q", unit)
+	}
+	return time.Duration(n) * base, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// Return the epoch time of t in (fractional) seconds, as stored in the
+// databases
+func epochTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// Return the time corresponding to an epoch time in seconds
+func fromEpochTime(epoch float64) time.Time {
+	sec := int64(epoch)
+	nsec := int64((epoch - float64(sec)) * 1e9)
+	return time.Unix(sec, nsec)
+}
+
+// Replace the epoch values of time keys in records with ISO 8601 timestamps.
+// Dotted keys are followed into nested documents and lists of documents.
+func FormatRecordTimes(records []map[string]any) {
+	for _, record := range records {
+		for _, key := range TimeKeys {
+			formatTime(record, strings.Split(key, "."))
+		}
+	}
+}
+
+// Replace the epoch value at a path of keys in a document, or in each of a
+// list of documents, with an ISO 8601 timestamp
+func formatTime(value any, path []string) {
+	switch value := value.(type) {
+	case map[string]any:
+		if len(path) > 1 {
+			formatTime(value[path[0]], path[1:])
+		} else if epoch, ok := value[path[0]].(float64); ok {
+			value[path[0]] = fromEpochTime(epoch).Format(time.RFC3339Nano)
+		}
+	case []map[string]any:
+		for _, item := range value {
+			formatTime(item, path)
+		}
+	case []any:
+		for _, item := range value {
+			formatTime(item, path)
+		}
+	}
+}
+
+func inList(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
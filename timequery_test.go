@@ -0,0 +1,149 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test conversion of human-readable start_time queries to epoch times
+func TestConvertTimeQueries(t *testing.T) {
+	loc := time.FixedZone("EST", -5*3600)
+	now := time.Date(2024, 3, 5, 14, 0, 0, 0, loc)
+	day := epochTime(time.Date(2024, 3, 5, 0, 0, 0, 0, loc))
+	tests := []struct {
+		query    map[string]any
+		expected map[string]any
+	}{
+		{
+			query:    map[string]any{"start_time": 1709665200.5},
+			expected: map[string]any{"start_time": 1709665200.5},
+		},
+		{
+			query:    map[string]any{"start_time": "2024-03-05T14:00:00Z"},
+			expected: map[string]any{"start_time": 1709647200.0},
+		},
+		{
+			query:    map[string]any{"start_time": "2024-03-05T14:00:00"},
+			expected: map[string]any{"start_time": epochTime(now)},
+		},
+		{
+			query: map[string]any{"start_time": "2024-03-05"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day, "$lt": day + 86400}},
+		},
+		{
+			query: map[string]any{"start_time": "last 24h"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": epochTime(now) - 86400, "$lte": epochTime(now)}},
+		},
+		{
+			query: map[string]any{"start_time": "last 2 weeks"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": epochTime(now) - 14*86400, "$lte": epochTime(now)}},
+		},
+		{
+			query: map[string]any{"start_time": "today"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day, "$lt": day + 86400}},
+		},
+		{
+			query: map[string]any{"start_time": "last tuesday"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day - 7*86400, "$lt": day - 6*86400}},
+		},
+		{
+			query:    map[string]any{"start_time": "Last Monday at 14:00"},
+			expected: map[string]any{"start_time": epochTime(now) - 86400},
+		},
+		{
+			query: map[string]any{"start_time": map[string]any{"$gte": "last sun at 9:30:15"}},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day - 2*86400 + 9*3600 + 30*60 + 15}},
+		},
+		{
+			query: map[string]any{"start_time": "last friday..last monday"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day - 4*86400, "$lt": day}},
+		},
+		{
+			query: map[string]any{"start_time": "2024-03-04..2024-03-05"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day - 86400, "$lt": day + 86400}},
+		},
+		{
+			query: map[string]any{"start_time": "2024-03-05T00:00:00/2024-03-05T14:00:00"},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gte": day, "$lte": epochTime(now)}},
+		},
+		{
+			query: map[string]any{"start_time": map[string]any{"$gt": "2024-03-05", "$lt": 1.0}},
+			expected: map[string]any{"start_time": map[string]any{
+				"$gt": day, "$lt": 1.0}},
+		},
+		{
+			query: map[string]any{"$or": []any{
+				map[string]any{"start_time": "2024-03-05T14:00:00"},
+				map[string]any{"beamline": "3a"},
+			}},
+			expected: map[string]any{"$or": []any{
+				map[string]any{"start_time": epochTime(now)},
+				map[string]any{"beamline": "3a"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			got, err := convertTimeQueries(tt.query, now)
+			if err != nil {
+				t.Fatalf("convertTimeQueries(%v) error: %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("convertTimeQueries(%v) = %v; want %v", tt.query, got, tt.expected)
+			}
+		})
+	}
+
+	invalid := []map[string]any{
+		{"start_time": "last caturday"},
+		{"start_time": "last tuesday at 25:00"},
+		{"start_time": "last 3 fortnights"},
+		{"start_time": ".."},
+		{"start_time": map[string]any{"$in": "2024-03-05"}},
+	}
+	for _, query := range invalid {
+		if _, err := convertTimeQueries(query, now); err == nil {
+			t.Errorf("convertTimeQueries(%v) should fail", query)
+		}
+	}
+}
+
+// Test rendering of epoch times as ISO 8601 timestamps
+func TestFormatRecordTimes(t *testing.T) {
+	records := []map[string]any{{"start_time": 1709647200.0, "scan_number": 1}}
+	FormatRecordTimes(records)
+	got, err := time.Parse(time.RFC3339Nano, records[0]["start_time"].(string))
+	if err != nil {
+		t.Fatalf("start_time was not formatted as ISO 8601: %v", records[0]["start_time"])
+	}
+	if got.Unix() != 1709647200 {
+		t.Errorf("formatted start_time %v does not match epoch time", got)
+	}
+
+	// Times in nested documents are formatted too
+	records = []map[string]any{{"status_history": []any{
+		map[string]any{"status": "running", "time": 1709647200.0},
+		map[string]any{"status": "completed", "time": 1709647260.0},
+	}}}
+	FormatRecordTimes(records)
+	for i, change := range records[0]["status_history"].([]any) {
+		formatted, ok := change.(map[string]any)["time"].(string)
+		if !ok {
+			t.Fatalf("status_history time was not formatted: %v", change)
+		}
+		got, err := time.Parse(time.RFC3339Nano, formatted)
+		if err != nil || got.Unix() != 1709647200+60*int64(i) {
+			t.Errorf("formatted status_history time %s does not match epoch time", formatted)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+// tombstone module
+//
+// Deleting a scan turns it into a tombstone rather than removing it: its
+// document records when and by whom it was deleted, and its motor rows are
+// marked deleted. Tombstones are left out of searches unless they are asked
+// for with include_deleted, may be restored, and are only removed for good
+// when they are purged.
+//
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Record keys maintained by deletes and restores, which adds and edits may not
+// set
+var DeletedKeys = []string{"deleted_at", "deleted_by"}
+
+// Errors of deletes and restores
+var (
+	ErrScanDeleted    = errors.New("scan is deleted")
+	ErrScanNotDeleted = errors.New("scan is not deleted")
+)
+
+// Return whether a request asks for deleted scans, with the
+// "include_deleted" URL parameter
+func includeDeletedRequest(c *gin.Context) (bool, error) {
+	value := c.Query("include_deleted")
+	if value == "" {
+		return false, nil
+	}
+	include_deleted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid include_deleted parameter %q", value)
+	}
+	return include_deleted, nil
+}
+
+// Get the document of a scan the given caller may read
+func accessibleRecord(stores ScanStores, access RecordAccess, sid string) (MongoRecord, error) {
+	var record MongoRecord
+	records, err := getMongoRecords(stores.Docs, map[string]any{"sid": sid}, 0, 0)
+	if err != nil {
+		return record, fmt.Errorf("[SpecScansService.main.accessibleRecord] getMongoRecords error: %w", err)
+	}
+	if len(records) != 1 {
+		return record, fmt.Errorf("[SpecScansService.main.accessibleRecord] %w: %s", ErrScanNotFound, sid)
+	}
+	record = records[0]
+	access.IncludeDeleted = true
+	if !access.Allows(CompleteRecord(record, MotorRecord{})) {
+		return record, fmt.Errorf("[SpecScansService.main.accessibleRecord] %w: %s", ErrScanNotFound, sid)
+	}
+	return record, nil
+}
+
+// Turn a scan in the given stores into a tombstone on behalf of the given
+// caller. The motor rows go first in deletes and restores, so a failure
+// leaves motor rows which searches check against the scan's document.
+func DeleteRecord(stores ScanStores, access RecordAccess, by Identity, sid string) error {
+	record, err := accessibleRecord(stores, access, sid)
+	if err != nil {
+		return err
+	}
+	if record.DeletedAt != 0 {
+		return fmt.Errorf("[SpecScansService.main.DeleteRecord] %w: %s", ErrScanDeleted, sid)
+	}
+	now := epochTime(time.Now())
+	if err = stores.Motors.SetScanDeleted(sid, now); err != nil {
+		return fmt.Errorf("[SpecScansService.main.DeleteRecord] stores.Motors.SetScanDeleted error: %w", err)
+	}
+	set := updatedFields(by, now)
+	set["deleted_at"] = now
+	set["deleted_by"] = provenanceIdentity(by)
+	if err = stores.Docs.Update(map[string]any{"sid": sid}, map[string]any{"$set": set}); err != nil {
+		return fmt.Errorf("[SpecScansService.main.DeleteRecord] stores.Docs.Update error: %w", err)
+	}
+	publishScanEvent(stores, ScanDeleted, sid)
+	return nil
+}
+
+// Restore a scan in the given stores from its tombstone on behalf of the
+// given caller
+func RestoreRecord(stores ScanStores, access RecordAccess, by Identity, sid string) error {
+	record, err := accessibleRecord(stores, access, sid)
+	if err != nil {
+		return err
+	}
+	if record.DeletedAt == 0 {
+		return fmt.Errorf("[SpecScansService.main.RestoreRecord] %w: %s", ErrScanNotDeleted, sid)
+	}
+	now := epochTime(time.Now())
+	if err = stores.Motors.SetScanDeleted(sid, 0); err != nil {
+		return fmt.Errorf("[SpecScansService.main.RestoreRecord] stores.Motors.SetScanDeleted error: %w", err)
+	}
+	update := map[string]any{
+		"$set":   updatedFields(by, now),
+		"$unset": map[string]any{"deleted_at": "", "deleted_by": ""},
+	}
+	if err = stores.Docs.Update(map[string]any{"sid": sid}, update); err != nil {
+		return fmt.Errorf("[SpecScansService.main.RestoreRecord] stores.Docs.Update error: %w", err)
+	}
+	return nil
+}
+
+// Handler for deleting or restoring the scans given by "sid" URL parameters
+func tombstoneHandler(c *gin.Context, action string, apply func(ScanStores, RecordAccess, Identity, string) error) {
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	access, err := requestAccess(c, stores)
+	if err != nil {
+		abortAccessError(c, err)
+		return
+	}
+	sids := c.QueryArray("sid")
+	if len(sids) == 0 {
+		err := errors.New("no sid given")
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	by := requestIdentity(c)
+	var records []map[string]any
+	for _, sid := range sids {
+		err = apply(stores, access, by, sid)
+		if err != nil {
+			code, srvcode := http.StatusInternalServerError, services.UpdateError
+			if errors.Is(err, ErrScanNotFound) {
+				code, srvcode = http.StatusNotFound, services.NotFoundError
+			} else if errors.Is(err, ErrScanDeleted) || errors.Is(err, ErrScanNotDeleted) {
+				code, srvcode = http.StatusConflict, services.ValidateError
+			}
+			resp := services.Response("SpecScans", code, srvcode, err)
+			resp.Results = services.ServiceResults{NRecords: len(records), Records: records}
+			c.JSON(code, resp)
+			return
+		}
+		log.Printf("Scan %s %s by %s", sid, action, by.User)
+		records = append(records, map[string]any{"sid": sid})
+	}
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(records),
+			Records:  records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for deleting the scans given by "sid" URL parameters, which become
+// tombstones
+func DeleteHandler(c *gin.Context) {
+	tombstoneHandler(c, "deleted", DeleteRecord)
+}
+
+// Handler for restoring the deleted scans given by "sid" URL parameters
+func RestoreHandler(c *gin.Context) {
+	tombstoneHandler(c, "restored", RestoreRecord)
+}
+
+// Handler for purging tombstones for good, of the scans given by "sid" and
+// "btr" URL parameters and deleted before the epoch time given by the
+// "before" URL parameter (all tombstones if none are given). Only admins may
+// purge tombstones.
+func PurgeDeletedHandler(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	stores, err := requestStores(c)
+	if err != nil {
+		abortStoresError(c, err)
+		return
+	}
+	deleted := map[string]any{"$exists": true}
+	if before := c.Query("before"); before != "" {
+		at, err := strconv.ParseFloat(before, 64)
+		if err != nil {
+			resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+			c.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		deleted["$lt"] = at
+	}
+	spec := map[string]any{"deleted_at": deleted}
+	for _, key := range []string{"sid", "btr"} {
+		if values := c.QueryArray(key); len(values) > 0 {
+			spec[key] = map[string]any{"$in": anyList(values)}
+		}
+	}
+	purged, err := PurgeRecords(stores, spec)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DatabaseError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	log.Printf("Purged %d deleted records matching %v", purged, spec)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: purged,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// Test marking the motor rows of scans as deleted
+func TestSetScanDeleted(t *testing.T) {
+	srvConfig.Config = &srvConfig.SrvConfig{}
+	srvConfig.Config.SpecScans.WebServer.StaticDir = "static"
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	for _, sid := range []string{"sid_1", "sid_2"} {
+		if _, err := InsertMotors(MotorRecord{ScanId: sid, Beamline: "3a", Motors: map[string]float64{"samx": 1}}, db, SQLiteDialect); err != nil {
+			t.Fatalf("InsertMotors error: %v", err)
+		}
+	}
+	if err := SetScanDeleted("sid_1", 100, db, SQLiteDialect); err != nil {
+		t.Fatalf("SetScanDeleted error: %v", err)
+	}
+	if err := SetScanDeleted("unknown", 100, db, SQLiteDialect); err == nil {
+		t.Error("SetScanDeleted of an unknown scan should fail")
+	}
+	query := MotorsDbQuery{MotorPositionQueries: []MotorPositionQuery{{Mne: "samx", Exact: []float64{1}}}}
+	for _, exclude := range []bool{false, true} {
+		query.ExcludeDeleted = exclude
+		records, err := queryMotorsDb(query, db, SQLiteDialect)
+		if want := map[bool]int{false: 2, true: 1}[exclude]; err != nil || len(records) != want {
+			t.Errorf("queryMotorsDb excluding deleted scans %v found %d records, %v; want %d", exclude, len(records), err, want)
+		}
+	}
+	if err := SetScanDeleted("sid_1", 0, db, SQLiteDialect); err != nil {
+		t.Fatalf("SetScanDeleted error: %v", err)
+	}
+	if records, _ := queryMotorsDb(query, db, SQLiteDialect); len(records) != 2 {
+		t.Errorf("queryMotorsDb found %d records after a restore; want 2", len(records))
+	}
+}
+
+// Test deleting scans into tombstones, restoring them and purging them
+func TestTombstones(t *testing.T) {
+	r := SetupTestService(t)
+	srvConfig.Config.AccessRules.AdminGroup = "admins"
+
+	var sids []string
+	for i, samx := range []float64{1, 2} {
+		response := serveTestRequest(t, r, "POST", "/add", testUserRecord(uint16(i+1), 1709647200+float64(i), map[string]float64{"samx": samx}))
+		if response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+		sids = append(sids, response.Results.Records[0]["sid"].(string))
+	}
+	count := func(url string, query string) int {
+		request := services.ServiceRequest{ServiceQuery: services.ServiceQuery{Query: query}}
+		response := serveTestRequest(t, r, "POST", url, request)
+		if response.HttpCode != http.StatusOK {
+			t.Fatalf("search %s for %s failed: %+v", url, query, response)
+		}
+		return len(response.Results.Records)
+	}
+
+	response := serveTestRequest(t, r, "DELETE", "/records?sid="+sids[0], nil)
+	if response.SrvCode != services.OK {
+		t.Fatalf("deleting a scan failed: %+v", response)
+	}
+	for _, query := range []string{"{}", `{"motors.samx": {"$lt": 3}}`, `{"beamline": "3a", "motors.samx": 1}`} {
+		if n, all := count("/search", query), count("/search?include_deleted=true", query); n != all-1 {
+			t.Errorf("search for %s found %d records and %d with deleted ones", query, n, all)
+		}
+	}
+	records := searchTestService(t, r, `{"deleted_by.user": "staff"}`)
+	if len(records) != 0 {
+		t.Errorf("search without include_deleted found deleted scans")
+	}
+	if n := count("/search?include_deleted=true", `{"deleted_by.user": "staff"}`); n != 1 {
+		t.Errorf("found %d scans deleted by staff; want 1", n)
+	}
+
+	response = serveTestRequest(t, r, "DELETE", "/records?sid="+sids[0], nil)
+	if response.HttpCode != http.StatusConflict {
+		t.Errorf("deleting a deleted scan returned %+v", response)
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sids[0], "command": "dscan"})
+	if response.SrvCode == services.OK {
+		t.Errorf("editing a deleted scan was accepted: %+v", response)
+	}
+	response = serveTestRequest(t, r, "POST", "/status", StatusTransition{ScanId: sids[0], Status: StatusCompleted})
+	if response.HttpCode != http.StatusConflict {
+		t.Errorf("changing the status of a deleted scan returned %+v", response)
+	}
+	user := testToken(t, authz.CustomClaims{User: "user", Scope: "read write delete", Btrs: []string{"other-456-b"}})
+	response = serveTokenRequest(t, r, "POST", "/restore?sid="+sids[0], user, nil)
+	if response.HttpCode != http.StatusNotFound {
+		t.Errorf("restoring a scan of another BTR returned %+v", response)
+	}
+
+	response = serveTestRequest(t, r, "POST", "/restore?sid="+sids[0], nil)
+	if response.SrvCode != services.OK {
+		t.Fatalf("restoring a scan failed: %+v", response)
+	}
+	if n := count("/search", `{"motors.samx": {"$lt": 3}}`); n != 2 {
+		t.Errorf("found %d records after a restore; want 2", n)
+	}
+	response = serveTestRequest(t, r, "POST", "/restore?sid="+sids[0], nil)
+	if response.HttpCode != http.StatusConflict {
+		t.Errorf("restoring a scan which is not deleted returned %+v", response)
+	}
+
+	response = serveTestRequest(t, r, "DELETE", "/records?sid="+sids[1], nil)
+	if response.SrvCode != services.OK {
+		t.Fatalf("deleting a scan failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "DELETE", "/tombstones", nil)
+	if response.HttpCode != http.StatusForbidden {
+		t.Errorf("purging tombstones without being an admin returned %+v", response)
+	}
+	admin := testToken(t, authz.CustomClaims{User: "admin", Scope: "read write delete", Groups: []string{"admins"}})
+	response = serveTokenRequest(t, r, "DELETE", "/tombstones", admin, nil)
+	if response.SrvCode != services.OK || response.Results.NRecords != 1 {
+		t.Fatalf("purging tombstones returned %+v", response)
+	}
+	if n := count("/search?include_deleted=true", "{}"); n != 1 {
+		t.Errorf("found %d records after a purge; want 1", n)
+	}
+	if found, _ := ScanMotors.QueryMotors(MotorsDbQuery{Sids: []string{sids[1]}}); len(found) != 0 {
+		t.Errorf("purged scan still has motors %+v", found)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of scan variables
+const (
+	VariableNumber  = "number"
+	VariableString  = "string"
+	VariableBoolean = "boolean"
+	VariableArray   = "array"
+	VariableObject  = "object"
+	VariableMixed   = "mixed" // undeclared variables seen with values of different types
+)
+
+// Types a variable may be declared with
+var VariableTypes = []string{VariableNumber, VariableString, VariableBoolean, VariableArray, VariableObject}
+
+// VariableInfo is the registry entry of a scan variable (an EPICS PV, a SPEC
+// global, ...). Entries are added as records are ingested; declaring a
+// variable fixes its type, and optionally indexes it in the document store and
+// stores its values in the SQL database.
+type VariableInfo struct {
+	Name        string `json:"name" mapstructure:"name"`
+	Type        string `json:"type" mapstructure:"type"`
+	Units       string `json:"units,omitempty" mapstructure:"units"`
+	Description string `json:"description,omitempty" mapstructure:"description"`
+	Declared    bool   `json:"declared" mapstructure:"declared"`
+	Indexed     bool   `json:"indexed" mapstructure:"indexed"`
+	Stored      bool   `json:"stored" mapstructure:"stored"`
+}
+
+// Check that a variable declaration is usable
+func (info VariableInfo) Validate() error {
+	if info.Name == "" || strings.ContainsAny(info.Name, ".$") {
+		return fmt.Errorf("invalid variable name %q", info.Name)
+	}
+	if !inList(info.Type, VariableTypes) {
+		return fmt.Errorf("variable %s has type %q; must be one of %v", info.Name, info.Type, VariableTypes)
+	}
+	if info.Stored && info.Type != VariableNumber {
+		return fmt.Errorf("variable %s of type %s cannot be stored; only %s variables can", info.Name, info.Type, VariableNumber)
+	}
+	return nil
+}
+
+// Return the variable type of a JSON-decoded value ("" for null)
+func variableType(value any) string {
+	switch value.(type) {
+	case float64, float32, int, int32, int64:
+		return VariableNumber
+	case string:
+		return VariableString
+	case bool:
+		return VariableBoolean
+	case []any:
+		return VariableArray
+	case map[string]any:
+		return VariableObject
+	}
+	return ""
+}
+
+// Return the type of a variable seen with values of both types
+func mergeVariableTypes(a string, b string) string {
+	if a == "" || a == b {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return VariableMixed
+}
+
+// Return the number a variable value represents, if any. Strings holding
+// numbers (as SPEC writes many globals) count.
+func variableNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// Split a submitted variable value into its value and units. Values may be
+// given with their units as {"value": value, "units": units}.
+func variableValue(value any) (any, string) {
+	v, ok := value.(map[string]any)
+	if !ok {
+		return value, ""
+	}
+	units, ok := v["units"].(string)
+	if _, has_value := v["value"]; !ok || !has_value || len(v) != 2 {
+		return value, ""
+	}
+	return v["value"], units
+}
+
+// Prepare the variables of a record for ingestion. Units given with values
+// are removed from the values, values of declared numeric variables are
+// converted to numbers, and the registry entries observed in the record are
+// returned along with the values of its stored variables.
+func PrepareVariables(registry VariableStore, variables map[string]any) (map[string]any, []VariableInfo, map[string]float64, error) {
+	if len(variables) == 0 {
+		return variables, nil, nil, nil
+	}
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	infos, err := registry.GetVariableInfo(names)
+	if err != nil {
+		return variables, nil, nil, fmt.Errorf("[SpecScansService.main.PrepareVariables] registry.GetVariableInfo error: %w", err)
+	}
+	registered := make(map[string]VariableInfo, len(infos))
+	for _, info := range infos {
+		registered[info.Name] = info
+	}
+	values := make(map[string]any, len(variables))
+	observed := make([]VariableInfo, 0, len(variables))
+	stored := make(map[string]float64)
+	for _, name := range names {
+		value, units := variableValue(variables[name])
+		info, ok := registered[name]
+		if ok && info.Declared && info.Type == VariableNumber && value != nil {
+			number, ok := variableNumber(value)
+			if !ok {
+				return variables, nil, nil, fmt.Errorf("[SpecScansService.main.PrepareVariables] value %v of %s variable %s is not a number", value, info.Type, name)
+			}
+			value = number
+			if info.Stored {
+				stored[name] = number
+			}
+		} else if ok && info.Declared && value != nil && variableType(value) != info.Type {
+			return variables, nil, nil, fmt.Errorf("[SpecScansService.main.PrepareVariables] value %v of %s variable %s has type %s", value, info.Type, name, variableType(value))
+		}
+		values[name] = value
+		observed = append(observed, VariableInfo{Name: name, Type: variableType(value), Units: units})
+	}
+	return values, observed, stored, nil
+}
+
+// Give queries on numeric variables ("variables.<name>" keys) numeric
+// semantics: strings holding numbers are converted to numbers, and strings
+// "<min>..<max>" to ranges (either end may be omitted). Queries on other
+// variables are left as they are.
+func ConvertVariableQueries(registry VariableStore, spec map[string]any) (map[string]any, error) {
+	names := variableQueryNames(spec, nil)
+	if len(names) == 0 {
+		return spec, nil
+	}
+	infos, err := registry.GetVariableInfo(names)
+	if err != nil {
+		return spec, fmt.Errorf("[SpecScansService.main.ConvertVariableQueries] registry.GetVariableInfo error: %w", err)
+	}
+	var numeric []string
+	for _, info := range infos {
+		if info.Type == VariableNumber {
+			numeric = append(numeric, info.Name)
+		}
+	}
+	if len(numeric) == 0 {
+		return spec, nil
+	}
+	return convertVariableQueries(spec, numeric)
+}
+
+// Collect the names of the variables queried by a mongo query spec
+func variableQueryNames(spec map[string]any, names []string) []string {
+	for key, val := range spec {
+		if inList(key, logicalOperators) {
+			subqueries, _ := val.([]any)
+			for _, subquery := range subqueries {
+				if subquery_map, ok := subquery.(map[string]any); ok {
+					names = variableQueryNames(subquery_map, names)
+				}
+			}
+		} else if name, found := strings.CutPrefix(key, "variables."); found && !inList(name, names) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func convertVariableQueries(spec map[string]any, numeric []string) (map[string]any, error) {
+	converted := make(map[string]any, len(spec))
+	for key, val := range spec {
+		if inList(key, logicalOperators) {
+			subqueries, ok := val.([]any)
+			if !ok {
+				converted[key] = val
+				continue
+			}
+			var converted_subqueries []any
+			for _, subquery := range subqueries {
+				subquery_map, ok := subquery.(map[string]any)
+				if !ok {
+					converted_subqueries = append(converted_subqueries, subquery)
+					continue
+				}
+				converted_subquery, err := convertVariableQueries(subquery_map, numeric)
+				if err != nil {
+					return spec, err
+				}
+				converted_subqueries = append(converted_subqueries, converted_subquery)
+			}
+			converted[key] = converted_subqueries
+		} else if name, found := strings.CutPrefix(key, "variables."); found && inList(name, numeric) {
+			converted_val, err := convertNumberQuery(val)
+			if err != nil {
+				return spec, fmt.Errorf("[SpecScansService.main.ConvertVariableQueries] invalid value for %s: %w", key, err)
+			}
+			converted[key] = converted_val
+		} else {
+			converted[key] = val
+		}
+	}
+	return converted, nil
+}
+
+// Convert the query value for a single numeric variable. Comparison operators
+// are those of time queries.
+func convertNumberQuery(val any) (any, error) {
+	switch v := val.(type) {
+	case string:
+		if lower, upper, found := strings.Cut(v, ".."); found {
+			return parseNumberRange(lower, upper)
+		}
+		return parseNumberBound(v)
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for op, opval := range v {
+			if inList(op, timeOperators) {
+				number, err := parseNumberBound(opval)
+				if err != nil {
+					return val, err
+				}
+				converted[op] = number
+			} else if inList(op, timeListOperators) {
+				opvals, ok := opval.([]any)
+				if !ok {
+					return val, fmt.Errorf("%s requires a list of numbers", op)
+				}
+				var numbers []any
+				for _, item := range opvals {
+					number, err := parseNumberBound(item)
+					if err != nil {
+						return val, err
+					}
+					numbers = append(numbers, number)
+				}
+				converted[op] = numbers
+			} else {
+				converted[op] = opval
+			}
+		}
+		return converted, nil
+	default:
+		return val, nil
+	}
+}
+
+// Parse a "<min>..<max>" range into a mongo range spec
+func parseNumberRange(lower string, upper string) (map[string]any, error) {
+	lower = strings.TrimSpace(lower)
+	upper = strings.TrimSpace(upper)
+	if lower == "" && upper == "" {
+		return nil, errors.New("range must have a minimum or a maximum")
+	}
+	spec := map[string]any{}
+	if lower != "" {
+		number, err := parseNumberBound(lower)
+		if err != nil {
+			return nil, err
+		}
+		spec["$gte"] = number
+	}
+	if upper != "" {
+		number, err := parseNumberBound(upper)
+		if err != nil {
+			return nil, err
+		}
+		spec["$lte"] = number
+	}
+	return spec, nil
+}
+
+// Parse a single value compared with a numeric variable
+func parseNumberBound(val any) (float64, error) {
+	number, ok := variableNumber(val)
+	if !ok {
+		return 0, fmt.Errorf("%v is not a number", val)
+	}
+	return number, nil
+}
+
+// Interface of *sql.DB and *sql.Tx used to read the variable registry
+type sqlQuerier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// Get the registry entries of the given variables (of all variables if none
+// are given) from the motors database, sorted by name
+func GetVariableInfo(names []string, db *sql.DB, dialect SQLDialect) ([]VariableInfo, error) {
+	infos, err := getVariableInfo(db, dialect, names)
+	if err != nil {
+		return infos, fmt.Errorf("[SpecScansService.main.GetVariableInfo] %w", err)
+	}
+	return infos, nil
+}
+
+func getVariableInfo(q sqlQuerier, dialect SQLDialect, names []string) ([]VariableInfo, error) {
+	infos := []VariableInfo{}
+	condition, args := inCondition("variable_name", names)
+	statement := "SELECT variable_name, variable_type, units, description, declared, indexed, stored FROM Variables" +
+		whereClause(condition) + " ORDER BY variable_name"
+	rows, err := q.Query(dialect.Rebind(statement), args...)
+	if err != nil {
+		return infos, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var info VariableInfo
+		var units, description sql.NullString
+		err = rows.Scan(&info.Name, &info.Type, &units, &description, &info.Declared, &info.Indexed, &info.Stored)
+		if err != nil {
+			return infos, err
+		}
+		info.Units, info.Description = units.String, description.String
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// Record the variables observed in an ingested record in the motors database:
+// new variables are added, the types of undeclared variables are widened and
+// missing units are filled in
+func ObserveVariables(observed []VariableInfo, db *sql.DB, dialect SQLDialect) error {
+	if len(observed) == 0 {
+		return nil
+	}
+	// Variables are handled in sorted order so that concurrent ingestions
+	// lock Variables rows in the same order
+	observed = append([]VariableInfo{}, observed...)
+	sort.Slice(observed, func(i, j int) bool { return observed[i].Name < observed[j].Name })
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.ObserveVariables] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < len(observed); i += MotorInsertBatchSize {
+		batch := observed[i:min(i+MotorInsertBatchSize, len(observed))]
+		names := make([]string, len(batch))
+		args := make([]any, 0, 3*len(batch))
+		for j, info := range batch {
+			names[j] = info.Name
+			args = append(args, info.Name, info.Type, nullString(info.Units))
+		}
+		statement := dialect.InsertIgnore("Variables", []string{"variable_name", "variable_type", "units"}, len(batch))
+		_, err = tx.Exec(dialect.Rebind(statement), args...)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.ObserveVariables] tx.Exec error: %w", err)
+		}
+		registered, err := getVariableInfo(tx, dialect, names)
+		if err != nil {
+			return fmt.Errorf("[SpecScansService.main.ObserveVariables] getVariableInfo error: %w", err)
+		}
+		for _, info := range registered {
+			j := sort.Search(len(batch), func(j int) bool { return batch[j].Name >= info.Name })
+			updated := observeVariable(info, batch[j])
+			if updated == info {
+				continue
+			}
+			_, err = tx.Exec(dialect.Rebind("UPDATE Variables SET variable_type = ?, units = ? WHERE variable_name = ?"),
+				updated.Type, nullString(updated.Units), updated.Name)
+			if err != nil {
+				return fmt.Errorf("[SpecScansService.main.ObserveVariables] tx.Exec error: %w", err)
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.ObserveVariables] tx.Commit error: %w", err)
+	}
+	return nil
+}
+
+// Return a registry entry updated with an observation of its variable
+func observeVariable(info VariableInfo, observed VariableInfo) VariableInfo {
+	if !info.Declared {
+		info.Type = mergeVariableTypes(info.Type, observed.Type)
+	}
+	if info.Units == "" {
+		info.Units = observed.Units
+	}
+	return info
+}
+
+// Declare a variable in the motors database
+func SetVariableInfo(info VariableInfo, db *sql.DB, dialect SQLDialect) error {
+	err := info.Validate()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableInfo] %w", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableInfo] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+	statement := dialect.InsertIgnore("Variables", []string{"variable_name", "variable_type"}, 1)
+	_, err = tx.Exec(dialect.Rebind(statement), info.Name, info.Type)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableInfo] tx.Exec error: %w", err)
+	}
+	_, err = tx.Exec(dialect.Rebind("UPDATE Variables SET variable_type = ?, units = ?, description = ?, declared = ?, indexed = ?, stored = ? WHERE variable_name = ?"),
+		info.Type, nullString(info.Units), nullString(info.Description), true, info.Indexed, info.Stored, info.Name)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableInfo] tx.Exec error: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableInfo] tx.Commit error: %w", err)
+	}
+	return nil
+}
+
+// Replace the values of a scan's stored variables in the motors database. The
+// scan's motors must have been inserted first.
+func SetVariableValues(sid string, values map[string]float64, db *sql.DB, dialect SQLDialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] db.Begin error: %w", err)
+	}
+	defer tx.Rollback()
+	var scan_id int64
+	err = tx.QueryRow(dialect.Rebind("SELECT scan_id FROM ScanIds WHERE sid = ?"), sid).Scan(&scan_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] tx.QueryRow error: %w", err)
+	}
+	_, err = tx.Exec(dialect.Rebind("DELETE FROM VariableValues WHERE scan_id = ?"), scan_id)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] tx.Exec error: %w", err)
+	}
+	if len(values) == 0 {
+		return tx.Commit()
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	condition, args := inCondition("variable_name", names)
+	rows, err := tx.Query(dialect.Rebind("SELECT variable_id, variable_name FROM Variables"+whereClause(condition)), args...)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] tx.Query error: %w", err)
+	}
+	args = make([]any, 0, 3*len(values))
+	for rows.Next() {
+		var variable_id int64
+		var name string
+		if err = rows.Scan(&variable_id, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("[SpecScansService.main.SetVariableValues] rows.Scan error: %w", err)
+		}
+		args = append(args, scan_id, variable_id, values[name])
+	}
+	rows.Close()
+	if len(args) != 3*len(values) {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] found %d of %d variables", len(args)/3, len(values))
+	}
+	statement := "INSERT INTO VariableValues (scan_id, variable_id, variable_value) VALUES " + Placeholders(len(values), 3)
+	_, err = tx.Exec(dialect.Rebind(statement), args...)
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] tx.Exec error: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[SpecScansService.main.SetVariableValues] tx.Commit error: %w", err)
+	}
+	return nil
+}
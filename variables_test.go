@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test the variable registry in the SQL motors database
+func TestSQLVariableRegistry(t *testing.T) {
+	db := SetupTestDB(t)
+	defer db.Close()
+	store := NewSQLVariableStore(db, SQLiteDialect)
+
+	err := store.ObserveVariables([]VariableInfo{
+		{Name: "ring_current", Type: VariableNumber, Units: "mA"},
+		{Name: "sample_temp", Type: VariableString},
+	})
+	if err != nil {
+		t.Fatalf("ObserveVariables error: %v", err)
+	}
+	err = store.ObserveVariables([]VariableInfo{
+		{Name: "ring_current", Type: VariableNumber, Units: "A"},
+		{Name: "sample_temp", Type: VariableNumber, Units: "K"},
+	})
+	if err != nil {
+		t.Fatalf("ObserveVariables error: %v", err)
+	}
+	infos, err := store.GetVariableInfo(nil)
+	if err != nil {
+		t.Fatalf("GetVariableInfo error: %v", err)
+	}
+	expected := []VariableInfo{
+		{Name: "ring_current", Type: VariableNumber, Units: "mA"},
+		{Name: "sample_temp", Type: VariableMixed, Units: "K"},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("GetVariableInfo returned %+v; want %+v", infos, expected)
+	}
+
+	// Declared types are not widened
+	err = store.SetVariableInfo(VariableInfo{Name: "sample_temp", Type: VariableNumber, Units: "K", Stored: true})
+	if err != nil {
+		t.Fatalf("SetVariableInfo error: %v", err)
+	}
+	if err = store.ObserveVariables([]VariableInfo{{Name: "sample_temp", Type: VariableString}}); err != nil {
+		t.Fatalf("ObserveVariables error: %v", err)
+	}
+	infos, _ = store.GetVariableInfo([]string{"sample_temp"})
+	if len(infos) != 1 || infos[0].Type != VariableNumber || !infos[0].Declared || !infos[0].Stored {
+		t.Errorf("GetVariableInfo returned %+v after declaring sample_temp", infos)
+	}
+	if err = store.SetVariableInfo(VariableInfo{Name: "mode", Type: VariableString, Stored: true}); err == nil {
+		t.Error("SetVariableInfo accepted a stored string variable")
+	}
+
+	// Stored values are replaced, and removed with their scan
+	if _, err = InsertMotors(MotorRecord{ScanId: "sid_1", Motors: map[string]float64{"samx": 1}}, db, SQLiteDialect); err != nil {
+		t.Fatalf("InsertMotors error: %v", err)
+	}
+	for _, value := range []float64{295.5, 300.25} {
+		if err = store.SetVariableValues("sid_1", map[string]float64{"sample_temp": value}); err != nil {
+			t.Fatalf("SetVariableValues error: %v", err)
+		}
+	}
+	var value float64
+	err = db.QueryRow("SELECT variable_value FROM VariableValues").Scan(&value)
+	if err != nil || value != 300.25 {
+		t.Errorf("VariableValues has value %v, %v; want 300.25", value, err)
+	}
+	if err = store.SetVariableValues("sid_1", map[string]float64{"unknown": 1}); err == nil {
+		t.Error("SetVariableValues accepted an unknown variable")
+	}
+	if err = RemoveMotors("sid_1", db, SQLiteDialect); err != nil {
+		t.Fatalf("RemoveMotors error: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM VariableValues").Scan(&count)
+	if count != 0 {
+		t.Errorf("VariableValues has %d rows after removing the scan", count)
+	}
+}
+
+// Test giving queries on numeric variables numeric semantics
+func TestConvertVariableQueries(t *testing.T) {
+	ScanVariables = NewMemoryVariableStore()
+	ScanVariables.ObserveVariables([]VariableInfo{
+		{Name: "ring_current", Type: VariableNumber},
+		{Name: "mode", Type: VariableString},
+	})
+	tests := []struct {
+		spec     map[string]any
+		expected map[string]any
+		fail     bool
+	}{
+		{
+			spec:     map[string]any{"variables.ring_current": "100"},
+			expected: map[string]any{"variables.ring_current": 100.0},
+		},
+		{
+			spec:     map[string]any{"variables.ring_current": "90..110"},
+			expected: map[string]any{"variables.ring_current": map[string]any{"$gte": 90.0, "$lte": 110.0}},
+		},
+		{
+			spec:     map[string]any{"variables.ring_current": map[string]any{"$gt": "90", "$in": []any{"100", 110.0}}},
+			expected: map[string]any{"variables.ring_current": map[string]any{"$gt": 90.0, "$in": []any{100.0, 110.0}}},
+		},
+		{
+			spec: map[string]any{"$or": []any{map[string]any{"variables.ring_current": "..110"}, map[string]any{"variables.mode": "10"}}},
+			expected: map[string]any{"$or": []any{
+				map[string]any{"variables.ring_current": map[string]any{"$lte": 110.0}},
+				map[string]any{"variables.mode": "10"},
+			}},
+		},
+		{
+			spec: map[string]any{"variables.ring_current": "high"},
+			fail: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := ConvertVariableQueries(ScanVariables, tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("ConvertVariableQueries(%v) should fail", tt.spec)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ConvertVariableQueries(%v) = %v, %v; want %v", tt.spec, got, err, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+// webhooks module
+//
+// Webhooks let downstream services (reduction pipelines, the data management
+// service) react to scan events: each event of a record matching a webhook's
+// query is POSTed as JSON to the webhook's URL, signed with HMAC-SHA256 of
+// the body when the webhook has a secret. Failed deliveries are retried with
+// exponential backoff, and each delivery, with all its attempts, is kept in a
+// delivery log. Deliveries are made concurrently, so they may arrive out of
+// order; events carry their time. Webhooks are managed by FOXDEN admins, and
+// are delivered events of all records.
+//
+// Webhook secrets are write-only: they are set with PUT /webhooks and never
+// returned by the API, which only tells whether a webhook is signed. Signing
+// needs the secrets themselves, so they are stored as given in the webhooks
+// collection; access to it must be restricted like access to the secrets.
+//
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	services "github.com/CHESSComputing/golib/services"
+	"github.com/gin-gonic/gin"
+	mapstructure "github.com/mitchellh/mapstructure"
+)
+
+// var WebhookDocs is the storage of webhooks
+var WebhookDocs DocumentStore
+
+// var WebhookDeliveryDocs is the storage of the delivery log of webhooks
+var WebhookDeliveryDocs DocumentStore
+
+// Types of scan events webhooks may be fired on
+var ScanEventTypes = []string{ScanAdded, ScanEdited, ScanDeleted, ScanStatus}
+
+// Number of times the delivery of an event to a webhook is attempted
+var WebhookAttempts = 5
+
+// Delay before retrying a failed delivery, doubled for each further retry
+var WebhookBackoff = 2 * time.Second
+
+// Client delivering events to webhooks
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Deliveries to webhooks in progress
+var webhookDeliveries sync.WaitGroup
+
+// Outcomes of deliveries
+const (
+	DeliverySucceeded = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Header of webhook requests with the HMAC-SHA256 of their body, as
+// "sha256=<hex digest>"
+const WebhookSignatureHeader = "X-SpecScans-Signature"
+
+// Webhook is an URL the events of the records matching a query, in the
+// syntax of POST /search, are delivered to
+type Webhook struct {
+	Id      string   `json:"id" mapstructure:"id"`
+	URL     string   `json:"url" mapstructure:"url"`
+	Events  []string `json:"events,omitempty" mapstructure:"events"` // all events if none
+	Query   string   `json:"query,omitempty" mapstructure:"query"`   // all records if none
+	Secret  string   `json:"secret,omitempty" mapstructure:"secret"` // key of the signatures of requests, if any; write-only
+	Sandbox bool     `json:"sandbox" mapstructure:"sandbox"`         // whether events of sandbox records are delivered, instead of production ones
+}
+
+// Check that a webhook has an id, an HTTP URL and known events
+func (hook Webhook) Validate() error {
+	if hook.Id == "" {
+		return errors.New("a webhook needs an id")
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", hook.URL)
+	}
+	for _, event := range hook.Events {
+		if !inList(event, ScanEventTypes) {
+			return fmt.Errorf("unknown event %q; events are %v", event, ScanEventTypes)
+		}
+	}
+	return nil
+}
+
+// Return the query of a webhook
+func (hook Webhook) query() string {
+	if hook.Query == "" {
+		return "{}"
+	}
+	return hook.Query
+}
+
+// WebhookAttempt is an attempt to deliver an event to a webhook
+type WebhookAttempt struct {
+	Time     float64 `json:"time"`
+	HttpCode int     `json:"http_code,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook
+type WebhookDelivery struct {
+	Id       string           `json:"id"`
+	Webhook  string           `json:"webhook"`
+	URL      string           `json:"url"`
+	Event    string           `json:"event"`
+	ScanId   string           `json:"sid"`
+	Time     float64          `json:"time"`
+	Status   string           `json:"status"`
+	Attempts []WebhookAttempt `json:"attempts"`
+}
+
+// Return the webhooks of the sandbox or of production
+func GetWebhooks(sandbox bool) ([]Webhook, error) {
+	var hooks []Webhook
+	if WebhookDocs == nil {
+		return hooks, nil
+	}
+	documents, err := WebhookDocs.Get(map[string]any{"sandbox": sandbox}, 0, 0)
+	if err != nil {
+		return hooks, fmt.Errorf("[SpecScansService.main.GetWebhooks] WebhookDocs.Get error: %w", err)
+	}
+	for _, document := range documents {
+		var hook Webhook
+		err = mapstructure.Decode(document, &hook)
+		if err != nil {
+			return hooks, fmt.Errorf("[SpecScansService.main.GetWebhooks] mapstructure.Decode error: %w", err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// Time for which the webhooks read to deliver events are cached. Changes
+// through this service take effect at once; this bounds the delay of changes
+// made through other instances sharing the webhook store.
+var WebhookCacheTTL = time.Minute
+
+// webhookCache holds the webhooks read from a webhook store
+type webhookCache struct {
+	mu     sync.Mutex
+	store  DocumentStore
+	hooks  map[bool][]Webhook
+	expiry time.Time
+}
+
+// Webhooks read to deliver events, which scan events do not read again
+var webhooks = &webhookCache{}
+
+// Return the webhooks of the sandbox or of production, from the cache unless
+// it has expired or holds those of another store
+func (w *webhookCache) Get(sandbox bool) ([]Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.store != WebhookDocs || time.Now().After(w.expiry) {
+		w.store = WebhookDocs
+		w.hooks = make(map[bool][]Webhook)
+		w.expiry = time.Now().Add(WebhookCacheTTL)
+	}
+	if hooks, ok := w.hooks[sandbox]; ok {
+		return hooks, nil
+	}
+	hooks, err := GetWebhooks(sandbox)
+	if err != nil {
+		return hooks, err
+	}
+	w.hooks[sandbox] = hooks
+	return hooks, nil
+}
+
+// Drop the cached webhooks, after webhooks are changed
+func (w *webhookCache) Invalidate() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks = nil
+	w.store = nil
+}
+
+// Return the webhooks of the sandbox or of production fired on an event
+func eventWebhooks(event string, sandbox bool) []Webhook {
+	hooks, err := webhooks.Get(sandbox)
+	if err != nil {
+		log.Printf("Error getting the webhooks of %s events: %v", event, err)
+	}
+	var fired []Webhook
+	for _, hook := range hooks {
+		if len(hook.Events) == 0 || inList(event, hook.Events) {
+			fired = append(fired, hook)
+		}
+	}
+	return fired
+}
+
+// Deliver an event of a record of the given stores to the webhooks it
+// matches, in the background
+func deliverWebhooks(stores ScanStores, hooks []Webhook, event ScanEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding the %s event of %s for webhooks: %v", event.Event, event.Record.ScanId, err)
+		return
+	}
+	for _, hook := range hooks {
+		filter, err := NewScanFilter(stores, hook.query())
+		if err == nil {
+			var match bool
+			match, err = filter.Match(event.Record)
+			if err == nil && !match {
+				continue
+			}
+		}
+		if err != nil {
+			log.Printf("Error matching record %s against webhook %s: %v", event.Record.ScanId, hook.Id, err)
+			continue
+		}
+		webhookDeliveries.Add(1)
+		go func(hook Webhook) {
+			defer webhookDeliveries.Done()
+			recordWebhookDelivery(deliverWebhook(hook, event, body))
+		}(hook)
+	}
+}
+
+// Return the signature of a webhook request body with the given secret
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check whether a delivery failing with the given HTTP status code (0 if
+// the request failed) may succeed when retried
+func retryableDelivery(code int) bool {
+	return code == 0 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// Deliver an event, encoded as the given body, to a webhook, retrying with
+// exponential backoff, and return the delivery
+func deliverWebhook(hook Webhook, event ScanEvent, body []byte) WebhookDelivery {
+	delivery := WebhookDelivery{
+		Id:      uuidv7(time.Now().UnixMilli()),
+		Webhook: hook.Id,
+		URL:     hook.URL,
+		Event:   event.Event,
+		ScanId:  event.Record.ScanId,
+		Time:    event.Time,
+		Status:  DeliveryFailed,
+	}
+	backoff := WebhookBackoff
+	for i := 0; i < WebhookAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		attempt := WebhookAttempt{Time: epochTime(time.Now())}
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			attempt.Error = err.Error()
+			delivery.Attempts = append(delivery.Attempts, attempt)
+			break
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "SpecScansService")
+		req.Header.Set("X-SpecScans-Event", event.Event)
+		req.Header.Set("X-SpecScans-Delivery", delivery.Id)
+		if hook.Secret != "" {
+			req.Header.Set(WebhookSignatureHeader, webhookSignature(hook.Secret, body))
+		}
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			attempt.Error = err.Error()
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			attempt.HttpCode = resp.StatusCode
+			if resp.StatusCode >= 300 {
+				attempt.Error = resp.Status
+			}
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		if attempt.Error == "" {
+			delivery.Status = DeliverySucceeded
+			break
+		}
+		if !retryableDelivery(attempt.HttpCode) {
+			break
+		}
+	}
+	if delivery.Status == DeliveryFailed {
+		log.Printf("Delivery of the %s event of %s to webhook %s failed after %d attempts", delivery.Event, delivery.ScanId, hook.Id, len(delivery.Attempts))
+	}
+	return delivery
+}
+
+// Record a delivery in the delivery log
+func recordWebhookDelivery(delivery WebhookDelivery) {
+	if WebhookDeliveryDocs == nil {
+		return
+	}
+	var document map[string]any
+	err := normalizeDocument(delivery, &document)
+	if err == nil {
+		err = WebhookDeliveryDocs.Insert(document)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to record delivery %s to webhook %s: %v", delivery.Id, delivery.Webhook, err)
+	}
+}
+
+// Abort the request if webhooks are not configured; return whether they are
+func requireWebhooks(c *gin.Context) bool {
+	if WebhookDocs == nil || WebhookDeliveryDocs == nil {
+		err := errors.New("webhooks are not configured")
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.DatabaseError, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
+		return false
+	}
+	return true
+}
+
+// Handler for listing webhooks (without their secrets)
+func WebhooksHandler(c *gin.Context) {
+	if !requireAdmin(c) || !requireWebhooks(c) {
+		return
+	}
+	var records []map[string]any
+	for _, sandbox := range []bool{false, true} {
+		hooks, err := GetWebhooks(sandbox)
+		if err != nil {
+			resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+			c.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+		for _, hook := range hooks {
+			records = append(records, webhookListing(hook))
+		}
+	}
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(records),
+			Records:  records,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Return the listing of a webhook, which tells whether it is signed instead
+// of its secret
+func webhookListing(hook Webhook) map[string]any {
+	return map[string]any{
+		"id":      hook.Id,
+		"url":     hook.URL,
+		"events":  hook.Events,
+		"query":   hook.Query,
+		"sandbox": hook.Sandbox,
+		"signed":  hook.Secret != "",
+	}
+}
+
+// Handler for adding a webhook, or replacing the webhook with its id
+func EditWebhookHandler(c *gin.Context) {
+	if !requireAdmin(c) || !requireWebhooks(c) {
+		return
+	}
+	defer c.Request.Body.Close()
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("ReadAll error: %v", err)
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.ReaderError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	var hook Webhook
+	err = json.Unmarshal(body, &hook)
+	if err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.UnmarshalError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	err = hook.Validate()
+	if err == nil {
+		// Check the webhook's query on the stores its events are of
+		var stores ScanStores
+		stores, err = scanStores(hook.Sandbox, nil)
+		if err == nil {
+			_, err = NewScanFilter(stores, hook.query())
+		}
+	}
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ValidateError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	// An existing webhook is replaced in place, all its fields set, so that
+	// a failed replacement leaves it as it was
+	document := map[string]any{
+		"id":      hook.Id,
+		"url":     hook.URL,
+		"events":  anyList(hook.Events),
+		"query":   hook.Query,
+		"secret":  hook.Secret,
+		"sandbox": hook.Sandbox,
+	}
+	spec := map[string]any{"id": hook.Id}
+	count, err := WebhookDocs.Count(spec)
+	if err == nil && count > 0 {
+		err = WebhookDocs.Update(spec, map[string]any{"$set": document})
+	} else if err == nil {
+		err = WebhookDocs.Insert(document)
+	}
+	webhooks.Invalidate()
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.UpdateError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	log.Printf("Webhook %s to %s set by %s", hook.Id, hook.URL, requestIdentity(c).User)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: 1,
+			Records:  []map[string]any{{"id": hook.Id}},
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for removing the webhook given by the "id" URL parameter
+func DeleteWebhookHandler(c *gin.Context) {
+	if !requireAdmin(c) || !requireWebhooks(c) {
+		return
+	}
+	id := c.Query("id")
+	count, err := WebhookDocs.Count(map[string]any{"id": id})
+	if err == nil && count > 0 {
+		err = WebhookDocs.Remove(map[string]any{"id": id})
+		webhooks.Invalidate()
+	}
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.UpdateError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	if count == 0 {
+		err := fmt.Errorf("no webhook %q", id)
+		resp := services.Response("SpecScans", http.StatusNotFound, services.NotFoundError, err)
+		c.JSON(http.StatusNotFound, resp)
+		return
+	}
+	log.Printf("Webhook %s removed by %s", id, requestIdentity(c).User)
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: 1,
+			Records:  []map[string]any{{"id": id}},
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for querying the delivery log of webhooks, by webhook, scan id,
+// event and status
+func WebhookDeliveriesHandler(c *gin.Context) {
+	if !requireAdmin(c) || !requireWebhooks(c) {
+		return
+	}
+	spec := map[string]any{}
+	for _, key := range []string{"webhook", "sid", "event", "status"} {
+		if value := c.Query(key); value != "" {
+			spec[key] = value
+		}
+	}
+	idx, limit, err := pageParams(c)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusBadRequest, services.ParametersError, err)
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	deliveries, err := WebhookDeliveryDocs.Get(spec, idx, limit)
+	if err != nil {
+		resp := services.Response("SpecScans", http.StatusInternalServerError, services.QueryError, err)
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+	for _, delivery := range deliveries {
+		delete(delivery, "_id")
+	}
+	response := services.ServiceResponse{
+		HttpCode: http.StatusOK,
+		SrvCode:  services.OK,
+		Service:  "SpecScans",
+		Results: services.ServiceResults{
+			NRecords: len(deliveries),
+			Records:  deliveries,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Return the "idx" and "limit" URL parameters of a request (0 if not given)
+func pageParams(c *gin.Context) (int, int, error) {
+	var idx, limit int
+	for key, value := range map[string]*int{"idx": &idx, "limit": &limit} {
+		if param := c.Query(key); param != "" {
+			n, err := strconv.Atoi(param)
+			if err != nil || n < 0 {
+				return 0, 0, fmt.Errorf("invalid %s parameter %q", key, param)
+			}
+			*value = n
+		}
+	}
+	return idx, limit, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	authz "github.com/CHESSComputing/golib/authz"
+	srvConfig "github.com/CHESSComputing/golib/config"
+	services "github.com/CHESSComputing/golib/services"
+)
+
+// webhookTestRequest is a request received by the stand-in of a webhook
+type webhookTestRequest struct {
+	path      string
+	event     string
+	signature string
+	body      []byte
+}
+
+// Test delivering scan events to webhooks, with retries and a delivery log
+func TestWebhooks(t *testing.T) {
+	r := SetupTestService(t)
+	srvConfig.Config.AccessRules.AdminGroup = "admins"
+	backoff := WebhookBackoff
+	WebhookBackoff = time.Millisecond
+	defer func() { WebhookBackoff = backoff }()
+
+	// Stand-in of downstream services: /flaky fails twice before accepting
+	// requests, and /gone rejects them all
+	var mu sync.Mutex
+	var requests []webhookTestRequest
+	flaky_failures := 2
+	standin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, webhookTestRequest{req.URL.Path, req.Header.Get("X-SpecScans-Event"), req.Header.Get(WebhookSignatureHeader), body})
+		switch {
+		case req.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		case flaky_failures > 0:
+			flaky_failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer standin.Close()
+	received := func() []webhookTestRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookTestRequest(nil), requests...)
+	}
+
+	admin := testToken(t, authz.CustomClaims{User: "admin", Scope: "read write", Groups: []string{"admins"}})
+	hooks := []Webhook{
+		{Id: "pipeline", URL: standin.URL + "/flaky", Events: []string{ScanAdded, ScanStatus, ScanDeleted}, Query: `{"scan_number": 2}`, Secret: "secret"},
+		{Id: "all", URL: standin.URL + "/gone"},
+	}
+	for _, hook := range hooks {
+		response := serveTokenRequest(t, r, "PUT", "/webhooks", admin, hook)
+		if response.SrvCode != services.OK {
+			t.Fatalf("adding webhook %s failed: %+v", hook.Id, response)
+		}
+	}
+	invalid := []Webhook{
+		{Id: "ftp", URL: "ftp://example.org/hook"},
+		{Id: "events", URL: standin.URL, Events: []string{"purge"}},
+		{Id: "query", URL: standin.URL, Query: "{"},
+	}
+	for _, hook := range invalid {
+		response := serveTokenRequest(t, r, "PUT", "/webhooks", admin, hook)
+		if response.HttpCode != http.StatusBadRequest {
+			t.Errorf("adding invalid webhook %s returned %+v", hook.Id, response)
+		}
+	}
+	response := serveTestRequest(t, r, "PUT", "/webhooks", Webhook{Id: "staff", URL: standin.URL})
+	if response.HttpCode != http.StatusForbidden {
+		t.Errorf("adding a webhook as staff returned %+v", response)
+	}
+
+	// Events are delivered one at a time, for the deliveries to /flaky to be
+	// in order
+	var sids []string
+	for i := 1; i <= 2; i++ {
+		response := serveTestRequest(t, r, "POST", "/add", testUserRecord(uint16(i), float64(1709647200+i), map[string]float64{"samx": float64(i)}))
+		if response.SrvCode != services.OK {
+			t.Fatalf("adding a record failed: %+v", response)
+		}
+		sids = append(sids, response.Results.Records[0]["sid"].(string))
+		webhookDeliveries.Wait()
+	}
+	changes := []struct {
+		method string
+		url    string
+		body   any
+	}{
+		{"PUT", "/edit", map[string]any{"sid": sids[1], "command": "dscan samx 0 1 10 1"}},
+		{"POST", "/status", StatusTransition{ScanId: sids[1], Status: StatusCompleted, Time: 1709647290}},
+		{"DELETE", "/records?sid=" + sids[1], nil},
+	}
+	for _, change := range changes {
+		response := serveTestRequest(t, r, change.method, change.url, change.body)
+		if response.SrvCode != services.OK {
+			t.Fatalf("%s %s failed: %+v", change.method, change.url, response)
+		}
+		webhookDeliveries.Wait()
+	}
+
+	requested := received()
+	var delivered []webhookTestRequest
+	for _, request := range requested {
+		if request.path == "/flaky" {
+			delivered = append(delivered, request)
+		}
+	}
+	if len(delivered) != 5 || len(requested) != 10 {
+		t.Fatalf("stand-in got %d requests, %d to /flaky; want 10 and 5", len(requested), len(delivered))
+	}
+	expected := []string{ScanAdded, ScanAdded, ScanAdded, ScanStatus, ScanDeleted}
+	for i, request := range delivered {
+		var event ScanEvent
+		if err := json.Unmarshal(request.body, &event); err != nil {
+			t.Fatalf("request %d has body %s: %v", i, request.body, err)
+		}
+		if request.event != expected[i] || event.Event != expected[i] || event.Record.ScanId != sids[1] {
+			t.Errorf("request %d to /flaky delivered %s event %+v; want %s of %s", i, request.event, event, expected[i], sids[1])
+		}
+		if request.signature != webhookSignature("secret", request.body) {
+			t.Errorf("request %d has signature %q", i, request.signature)
+		}
+	}
+
+	deliveries := func(query string) []map[string]any {
+		response := serveTokenRequest(t, r, "GET", "/webhooks/deliveries?"+query, admin, nil)
+		if response.SrvCode != services.OK {
+			t.Fatalf("querying the delivery log failed: %+v", response)
+		}
+		return response.Results.Records
+	}
+	pipeline_log := deliveries("webhook=pipeline")
+	if len(pipeline_log) != 3 {
+		t.Fatalf("delivery log has %d deliveries to pipeline; want 3: %+v", len(pipeline_log), pipeline_log)
+	}
+	attempts, _ := pipeline_log[0]["attempts"].([]any)
+	if pipeline_log[0]["status"] != DeliverySucceeded || pipeline_log[0]["event"] != ScanAdded || len(attempts) != 3 {
+		t.Fatalf("first delivery to pipeline is %+v", pipeline_log[0])
+	}
+	for i, code := range []float64{503, 503, 200} {
+		if attempt := attempts[i].(map[string]any); attempt["http_code"] != code {
+			t.Errorf("attempt %d of the first delivery is %+v", i, attempt)
+		}
+	}
+	if failed_log := deliveries("webhook=all&status=" + DeliveryFailed); len(failed_log) != 5 || len(failed_log[0]["attempts"].([]any)) != 1 {
+		t.Errorf("delivery log has failed deliveries to all %+v; want 5 of 1 attempt", failed_log)
+	}
+
+	response = serveTokenRequest(t, r, "GET", "/webhooks", admin, nil)
+	if response.SrvCode != services.OK || response.Results.NRecords != 2 {
+		t.Fatalf("listing webhooks returned %+v", response)
+	}
+	for _, hook := range response.Results.Records {
+		if _, ok := hook["secret"]; ok || hook["signed"] != (hook["id"] == "pipeline") {
+			t.Errorf("webhook listed as %+v", hook)
+		}
+	}
+	response = serveTokenRequest(t, r, "DELETE", "/webhooks?id=all", admin, nil)
+	if response.SrvCode != services.OK {
+		t.Fatalf("removing a webhook failed: %+v", response)
+	}
+	response = serveTestRequest(t, r, "PUT", "/edit", map[string]any{"sid": sids[0], "command": "dscan samx 0 1 10 1"})
+	if response.SrvCode != services.OK {
+		t.Fatalf("editing a record failed: %+v", response)
+	}
+	webhookDeliveries.Wait()
+	if requested := received(); len(requested) != 10 {
+		t.Errorf("stand-in got %d requests after the webhook was removed; want 10", len(requested))
+	}
+	response = serveTokenRequest(t, r, "DELETE", "/webhooks?id=all", admin, nil)
+	if response.HttpCode != http.StatusNotFound {
+		t.Errorf("removing a removed webhook returned %+v", response)
+	}
+}
+
+// countingDocumentStore is a document store counting the queries of documents
+type countingDocumentStore struct {
+	DocumentStore
+	gets int
+}
+
+func (s *countingDocumentStore) Get(spec map[string]any, idx int, limit int) ([]map[string]any, error) {
+	s.gets++
+	return s.DocumentStore.Get(spec, idx, limit)
+}
+
+// Test replacing webhooks in place, and that events do not read webhooks
+// again until they change
+func TestWebhookCache(t *testing.T) {
+	r := SetupTestService(t)
+	srvConfig.Config.AccessRules.AdminGroup = "admins"
+	hooks := &countingDocumentStore{DocumentStore: WebhookDocs}
+	WebhookDocs = hooks
+	admin := testToken(t, authz.CustomClaims{User: "admin", Scope: "read write", Groups: []string{"admins"}})
+	for _, hook := range []Webhook{
+		{Id: "pipeline", URL: "http://localhost:1/old", Events: []string{ScanDeleted}, Secret: "secret"},
+		{Id: "pipeline", URL: "http://localhost:1/new", Events: []string{ScanStatus}},
+	} {
+		if response := serveTokenRequest(t, r, "PUT", "/webhooks", admin, hook); response.SrvCode != services.OK {
+			t.Fatalf("setting webhook %+v failed: %+v", hook, response)
+		}
+	}
+	if fired := eventWebhooks(ScanStatus, false); len(fired) != 1 || fired[0].URL != "http://localhost:1/new" || fired[0].Secret != "" {
+		t.Errorf("replaced webhook is fired as %+v", fired)
+	}
+	if n, _ := WebhookDocs.Count(map[string]any{}); n != 1 {
+		t.Errorf("%d webhooks are stored after replacing one; want 1", n)
+	}
+
+	gets := hooks.gets
+	for _, event := range ScanEventTypes {
+		eventWebhooks(event, false)
+	}
+	if hooks.gets != gets {
+		t.Errorf("webhooks were read %d times for events; want 0", hooks.gets-gets)
+	}
+	response := serveTokenRequest(t, r, "DELETE", "/webhooks?id=pipeline", admin, nil)
+	if response.SrvCode != services.OK {
+		t.Fatalf("removing a webhook failed: %+v", response)
+	}
+	if fired := eventWebhooks(ScanStatus, false); len(fired) != 0 {
+		t.Errorf("removed webhook is fired as %+v", fired)
+	}
+}